@@ -742,6 +742,17 @@ func (r *GitRepositoryReconciler) gitCheckout(ctx context.Context,
 		}
 	}
 
+	// Log the progress of fetching large repositories, which is only
+	// reported by libgit2.
+	if obj.Spec.GitImplementation == sourcev1.LibGit2Implementation {
+		log := ctrl.LoggerFrom(ctx)
+		checkoutOpts.Progress = func(p git.TransferProgress) {
+			log.V(logger.DebugLevel).Info("fetching objects", "url", obj.Spec.URL,
+				"receivedObjects", p.ReceivedObjects, "indexedObjects", p.IndexedObjects,
+				"totalObjects", p.TotalObjects, "receivedBytes", p.ReceivedBytes)
+		}
+	}
+
 	gitCtx, cancel := context.WithTimeout(ctx, obj.Spec.Timeout.Duration)
 	defer cancel()
 
@@ -870,6 +881,13 @@ func (r *GitRepositoryReconciler) verifyCommitSignature(ctx context.Context, obj
 	// observations if there is none
 	if obj.Spec.Verification == nil || obj.Spec.Verification.Mode == "" {
 		conditions.Delete(obj, sourcev1.SourceVerifiedCondition)
+		// Record the unverified signer of the commit for auditing purposes
+		if commit.Signature != "" {
+			if keyID, err := commit.SignerKeyID(); err == nil {
+				ctrl.LoggerFrom(ctx).V(logger.DebugLevel).Info("commit signature not verified",
+					"revision", commit.String(), "signerKeyID", keyID)
+			}
+		}
 		return sreconcile.ResultSuccess, nil
 	}
 
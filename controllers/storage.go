@@ -327,14 +327,77 @@ func SourceIgnoreFilter(ps []gitignore.Pattern, domain []string) ArchiveFileFilt
 	}
 }
 
+// ArchiveOptions are the options used by Storage.ArchiveWithOptions.
+type ArchiveOptions struct {
+	// Filter excludes matching files from the archive, it is given the path
+	// of the file before any prefix is stripped or added.
+	Filter ArchiveFileFilter
+	// StripPrefix is removed from the path of all entries. Entries which are
+	// not within the prefix are excluded from the archive.
+	StripPrefix string
+	// AddPrefix is prepended to the path of all entries, after StripPrefix
+	// has been removed.
+	AddPrefix string
+}
+
+// archivePath returns the path of the entry for the given path in the
+// archive, or false if the entry should be excluded.
+func (o ArchiveOptions) archivePath(p string) (string, bool) {
+	if o.StripPrefix != "" {
+		prefix := filepath.Clean(o.StripPrefix)
+		switch {
+		case p == prefix:
+			p = "."
+		case strings.HasPrefix(p, prefix+string(filepath.Separator)):
+			p = strings.TrimPrefix(p, prefix+string(filepath.Separator))
+		default:
+			return "", false
+		}
+	}
+	if o.AddPrefix != "" {
+		p = filepath.Join(o.AddPrefix, p)
+	}
+	return p, true
+}
+
+// validate returns an error if the prefixes are absolute, or point outside
+// of the archive.
+func (o ArchiveOptions) validate() error {
+	for _, p := range []struct{ name, prefix string }{
+		{"strip", o.StripPrefix},
+		{"add", o.AddPrefix},
+	} {
+		if p.prefix == "" {
+			continue
+		}
+		clean := filepath.Clean(p.prefix)
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid %s prefix '%s': must be a relative path within the archive", p.name, p.prefix)
+		}
+	}
+	return nil
+}
+
 // Archive atomically archives the given directory as a tarball to the given v1beta1.Artifact path, excluding
 // directories and any ArchiveFileFilter matches. While archiving, any environment specific data (for example,
 // the user and group name) is stripped from file headers.
 // If successful, it sets the checksum and last update time on the artifact.
 func (s *Storage) Archive(artifact *sourcev1.Artifact, dir string, filter ArchiveFileFilter) (err error) {
+	return s.ArchiveWithOptions(artifact, dir, ArchiveOptions{Filter: filter})
+}
+
+// ArchiveWithOptions atomically archives the given directory as a tarball to the given v1beta1.Artifact path, in
+// the same way as Archive. In addition, the paths of the entries can be rewritten using the StripPrefix and
+// AddPrefix ArchiveOptions, to root the archive at a subdirectory of dir.
+// If successful, it sets the checksum and last update time on the artifact.
+func (s *Storage) ArchiveWithOptions(artifact *sourcev1.Artifact, dir string, opts ArchiveOptions) (err error) {
 	if f, err := os.Stat(dir); os.IsNotExist(err) || !f.IsDir() {
 		return fmt.Errorf("invalid dir path: %s", dir)
 	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	filter := opts.Filter
 
 	localPath := s.LocalPath(*artifact)
 	tf, err := os.CreateTemp(filepath.Split(localPath))
@@ -383,7 +446,11 @@ func (s *Storage) Archive(artifact *sourcev1.Artifact, dir string, filter Archiv
 				return err
 			}
 		}
-		header.Name = relFilePath
+		name, ok := opts.archivePath(relFilePath)
+		if !ok {
+			return nil
+		}
+		header.Name = name
 
 		// We want to remove any environment specific data as well, this
 		// ensures the checksum is purely content based.
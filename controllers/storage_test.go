@@ -170,12 +170,14 @@ func TestStorage_Archive(t *testing.T) {
 	}
 
 	tests := []struct {
-		name     string
-		files    map[string][]byte
-		filter   ArchiveFileFilter
-		want     map[string][]byte
-		wantDirs []string
-		wantErr  bool
+		name        string
+		files       map[string][]byte
+		filter      ArchiveFileFilter
+		stripPrefix string
+		addPrefix   string
+		want        map[string][]byte
+		wantDirs    []string
+		wantErr     bool
 	}{
 		{
 			name: "no filter",
@@ -236,6 +238,64 @@ func TestStorage_Archive(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "strip prefix",
+			files: map[string][]byte{
+				"charts/podinfo/Chart.yaml":            []byte(`name: podinfo`),
+				"charts/podinfo/templates/deploy.yaml": nil,
+				"charts/other/Chart.yaml":              nil,
+				"README.md":                            nil,
+			},
+			stripPrefix: "charts/podinfo/",
+			want: map[string][]byte{
+				"Chart.yaml":               []byte(`name: podinfo`),
+				"templates/deploy.yaml":    nil,
+				"!charts/other/Chart.yaml": nil,
+				"!other/Chart.yaml":        nil,
+				"!README.md":               nil,
+			},
+			wantDirs: []string{
+				"templates",
+				"!charts",
+			},
+		},
+		{
+			name: "strip and add prefix",
+			files: map[string][]byte{
+				"deploy/chart/Chart.yaml": []byte(`name: podinfo`),
+				"README.md":               nil,
+			},
+			filter:      SourceIgnoreFilter(nil, nil),
+			stripPrefix: "deploy/chart",
+			addPrefix:   "podinfo",
+			want: map[string][]byte{
+				"podinfo/Chart.yaml": []byte(`name: podinfo`),
+				"!README.md":         nil,
+			},
+			wantDirs: []string{
+				"podinfo",
+				"!deploy",
+			},
+		},
+		{
+			name: "add prefix",
+			files: map[string][]byte{
+				"Chart.yaml": nil,
+			},
+			addPrefix: "chart",
+			want: map[string][]byte{
+				"chart/Chart.yaml": nil,
+				"!Chart.yaml":      nil,
+			},
+		},
+		{
+			name: "prefix outside of archive",
+			files: map[string][]byte{
+				"Chart.yaml": nil,
+			},
+			stripPrefix: "../chart",
+			wantErr:     true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -251,8 +311,19 @@ func TestStorage_Archive(t *testing.T) {
 			if err := storage.MkdirAll(artifact); err != nil {
 				t.Fatalf("artifact directory creation failed: %v", err)
 			}
-			if err := storage.Archive(&artifact, dir, tt.filter); (err != nil) != tt.wantErr {
-				t.Errorf("Archive() error = %v, wantErr %v", err, tt.wantErr)
+			if tt.stripPrefix == "" && tt.addPrefix == "" {
+				if err := storage.Archive(&artifact, dir, tt.filter); (err != nil) != tt.wantErr {
+					t.Errorf("Archive() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			} else {
+				opts := ArchiveOptions{Filter: tt.filter, StripPrefix: tt.stripPrefix, AddPrefix: tt.addPrefix}
+				err := storage.ArchiveWithOptions(&artifact, dir, opts)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("ArchiveWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
 			}
 			matchFiles(t, storage, artifact, tt.want, tt.wantDirs)
 		})
@@ -51,6 +51,9 @@ import (
 type TransportPool struct {
 }
 
+// idleConnTimeout is the IdleConnTimeout of the transports in the pool.
+const idleConnTimeout = 60 * time.Second
+
 var pool = &sync.Pool{
 	New: func() interface{} {
 		return &http.Transport{
@@ -65,7 +68,7 @@ var pool = &sync.Pool{
 			// By setting a low value to IdleConnTimeout the connections
 			// will be closed after that period of inactivity, allowing the
 			// transport to be garbage collected.
-			IdleConnTimeout: 60 * time.Second,
+			IdleConnTimeout: idleConnTimeout,
 
 			// use safe defaults based off http.DefaultTransport
 			DialContext: (&net.Dialer{
@@ -97,6 +100,11 @@ func Release(transport *http.Transport) error {
 	}
 
 	transport.TLSClientConfig = nil
+	// Restore the connection settings a user of the transport may have
+	// tuned.
+	transport.IdleConnTimeout = idleConnTimeout
+	transport.ResponseHeaderTimeout = 0
+	transport.DisableKeepAlives = false
 
 	pool.Put(transport)
 	return nil
@@ -19,6 +19,7 @@ package transport
 import (
 	"crypto/tls"
 	"testing"
+	"time"
 )
 
 func Test_TransportReuse(t *testing.T) {
@@ -40,6 +41,9 @@ func Test_TransportReuse(t *testing.T) {
 	if t3.TLSClientConfig == nil || t3.TLSClientConfig.ServerName != "testing" {
 		t.Errorf("TLSClientConfig not properly configured")
 	}
+	t3.IdleConnTimeout = time.Second
+	t3.ResponseHeaderTimeout = time.Second
+	t3.DisableKeepAlives = true
 
 	err = Release(t3)
 	if err != nil {
@@ -48,6 +52,9 @@ func Test_TransportReuse(t *testing.T) {
 	if t3.TLSClientConfig != nil {
 		t.Errorf("TLSClientConfig not cleared after release")
 	}
+	if t3.IdleConnTimeout != idleConnTimeout || t3.ResponseHeaderTimeout != 0 || t3.DisableKeepAlives {
+		t.Errorf("connection settings not restored after release")
+	}
 
 	err = Release(nil)
 	if err == nil {
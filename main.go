@@ -32,6 +32,7 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	"github.com/fluxcd/pkg/runtime/client"
 	helper "github.com/fluxcd/pkg/runtime/controller"
@@ -49,6 +50,7 @@ import (
 	"github.com/fluxcd/source-controller/internal/cache"
 	"github.com/fluxcd/source-controller/internal/helm"
 	"github.com/fluxcd/source-controller/pkg/git"
+	"github.com/fluxcd/source-controller/pkg/git/libgit2"
 	"github.com/fluxcd/source-controller/pkg/git/libgit2/managed"
 	// +kubebuilder:scaffold:imports
 )
@@ -101,6 +103,7 @@ func main() {
 		helmCachePurgeInterval   string
 		artifactRetentionTTL     time.Duration
 		artifactRetentionRecords int
+		gitMaxCheckoutsPerHost   int
 	)
 
 	flag.StringVar(&metricsAddr, "metrics-addr", envOrDefault("METRICS_ADDR", ":8080"),
@@ -135,6 +138,8 @@ func main() {
 		"The list of key exchange algorithms to use for ssh connections, arranged from most preferred to the least.")
 	flag.StringSliceVar(&git.HostKeyAlgos, "ssh-hostkey-algos", []string{},
 		"The list of hostkey algorithms to use for ssh connections, arranged from most preferred to the least.")
+	flag.IntVar(&gitMaxCheckoutsPerHost, "git-max-checkouts-per-host", 0,
+		"The maximum number of concurrent libgit2 checkouts from the same Git host, 0 means unlimited.")
 	flag.DurationVar(&artifactRetentionTTL, "artifact-retention-ttl", 60*time.Second,
 		"The duration of time that artifacts will be kept in storage before being garbage collected.")
 	flag.IntVar(&artifactRetentionRecords, "artifact-retention-records", 2,
@@ -208,6 +213,11 @@ func main() {
 		// Log the error, but don't exit so as to not block reconcilers that are healthy.
 		setupLog.Error(err, "unable to initialize libgit2 managed transport")
 	}
+	libgit2.SetMaxCheckoutsPerHost(gitMaxCheckoutsPerHost)
+	if err = mgr.Add(manager.RunnableFunc(managed.SweepTransportOptions)); err != nil {
+		setupLog.Error(err, "unable to set up libgit2 managed transport options sweeper")
+		os.Exit(1)
+	}
 
 	if err = (&controllers.GitRepositoryReconciler{
 		Client:                      mgr.GetClient(),
@@ -0,0 +1,158 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Cleanup removes the working tree at the given path, and is intended to be
+// called once the caller is done with the result of a checkout.
+//
+// When SecureCleanup is set, the content of every regular file is overwritten
+// with zeros and synced to disk before the tree is removed. This is a best
+// effort: copy-on-write and journaling filesystems, snapshots and the wear
+// levelling of flash storage may all retain copies of the original data.
+// The tree is removed even when wiping it fails.
+func (o CheckoutOptions) Cleanup(path string) error {
+	var errs []error
+	if o.SecureCleanup {
+		if err := wipeFiles(path); err != nil {
+			errs = append(errs, fmt.Errorf("failed to wipe working tree '%s': %w", path, err))
+		}
+	}
+	if err := os.RemoveAll(path); err != nil {
+		errs = append(errs, err)
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// PathState is the state of a checkout path before a checkout, which allows
+// everything the checkout writes to the path to be removed again without
+// touching content which was already present.
+type PathState struct {
+	path    string
+	created bool
+	empty   bool
+}
+
+// RecordPathState records the state of the given path, and must be called
+// before the checkout writes to it.
+func RecordPathState(path string) PathState {
+	entries, err := os.ReadDir(path)
+	return PathState{
+		path:    path,
+		created: os.IsNotExist(err),
+		empty:   err == nil && len(entries) == 0,
+	}
+}
+
+// Owned returns if all content of the path is written by the checkout,
+// because the path did not exist or was empty when its state was recorded.
+func (s PathState) Owned() bool {
+	return s.created || s.empty
+}
+
+// Restore removes everything written to the path since its state was
+// recorded. The path itself is removed when it did not exist, and its
+// contents when it was empty. A path which was not empty is left alone, as
+// its contents were not written by the checkout.
+func (s PathState) Restore() error {
+	switch {
+	case s.created:
+		return os.RemoveAll(s.path)
+	case s.empty:
+		return RemoveDirContents(s.path)
+	default:
+		return nil
+	}
+}
+
+// RemoveDirContents removes all content from the given directory, without
+// removing the directory itself. It does not return an error if the
+// directory does not exist.
+func RemoveDirContents(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipeFiles overwrites the content of all regular files in the given
+// directory with zeros. Symlinks are not followed. Read-only files, like the
+// objects written by git, are made writable first.
+func wipeFiles(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return wipeFile(path)
+	})
+}
+
+func wipeFile(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if perm := fi.Mode().Perm(); perm&0o200 == 0 {
+		if err = os.Chmod(path, perm|0o200); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = io.CopyN(f, zeroReader{}, fi.Size()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// zeroReader is an io.Reader which always fills the given buffer with zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
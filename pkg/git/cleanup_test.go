@@ -0,0 +1,145 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCheckoutOptions_Cleanup(t *testing.T) {
+	tests := []struct {
+		name   string
+		secure bool
+	}{
+		{name: "plain removal", secure: false},
+		{name: "secure removal", secure: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			dir := t.TempDir()
+			g.Expect(os.MkdirAll(filepath.Join(dir, "sub"), 0o755)).To(Succeed())
+			g.Expect(os.WriteFile(filepath.Join(dir, "sub", "secret.yaml"), []byte("secret"), 0o644)).To(Succeed())
+			g.Expect(os.Symlink(filepath.Join("sub", "secret.yaml"), filepath.Join(dir, "link"))).To(Succeed())
+
+			opts := CheckoutOptions{SecureCleanup: tt.secure}
+			g.Expect(opts.Cleanup(dir)).To(Succeed())
+			g.Expect(dir).ToNot(BeAnExistingFile())
+		})
+	}
+}
+
+func Test_wipeFiles(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secret.yaml")
+	g.Expect(os.WriteFile(file, []byte("secret"), 0o644)).To(Succeed())
+
+	g.Expect(wipeFiles(dir)).To(Succeed())
+
+	b, err := os.ReadFile(file)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(b).To(Equal(make([]byte, len("secret"))))
+}
+
+func TestCheckoutOptions_Cleanup_readOnlyFiles(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("skipping test as root can write read-only files")
+	}
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	packDir := filepath.Join(dir, ".git", "objects", "pack")
+	g.Expect(os.MkdirAll(packDir, 0o755)).To(Succeed())
+	pack := filepath.Join(packDir, "pack-1.pack")
+	g.Expect(os.WriteFile(pack, []byte("secret"), 0o444)).To(Succeed())
+
+	g.Expect(wipeFiles(dir)).To(Succeed())
+	b, err := os.ReadFile(pack)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(b).To(Equal(make([]byte, len("secret"))))
+
+	opts := CheckoutOptions{SecureCleanup: true}
+	g.Expect(opts.Cleanup(dir)).To(Succeed())
+	g.Expect(dir).ToNot(BeAnExistingFile())
+}
+
+func TestPathState_Restore(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(path string) error
+		wantOwned  bool
+		wantExists bool
+		wantFiles  []string
+	}{
+		{
+			name:      "path did not exist",
+			setup:     func(string) error { return nil },
+			wantOwned: true,
+		},
+		{
+			name:       "path was empty",
+			setup:      func(path string) error { return os.Mkdir(path, 0o700) },
+			wantOwned:  true,
+			wantExists: true,
+		},
+		{
+			name: "path was not empty",
+			setup: func(path string) error {
+				if err := os.Mkdir(path, 0o700); err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join(path, "keep"), nil, 0o600)
+			},
+			wantExists: true,
+			wantFiles:  []string{"keep", "partial"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			path := filepath.Join(t.TempDir(), "checkout")
+			g.Expect(tt.setup(path)).To(Succeed())
+
+			state := RecordPathState(path)
+			g.Expect(state.Owned()).To(Equal(tt.wantOwned))
+			g.Expect(os.MkdirAll(path, 0o700)).To(Succeed())
+			g.Expect(os.WriteFile(filepath.Join(path, "partial"), nil, 0o600)).To(Succeed())
+			g.Expect(state.Restore()).To(Succeed())
+
+			entries, err := os.ReadDir(path)
+			if !tt.wantExists {
+				g.Expect(os.IsNotExist(err)).To(BeTrue())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			var files []string
+			for _, e := range entries {
+				files = append(files, e.Name())
+			}
+			g.Expect(files).To(Equal(tt.wantFiles))
+		})
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrFileTooLarge is returned when a file in the tree of the commit being
+	// checked out exceeds CheckoutOptions.MaxFileSize.
+	ErrFileTooLarge = errors.New("file exceeds maximum size")
+
+	// ErrPackChecksumMismatch is returned when the packfile received from a
+	// remote does not match the checksum provided by the server.
+	ErrPackChecksumMismatch = errors.New("pack checksum mismatch")
+
+	// ErrNoMergeBase is returned when two commits do not share a common
+	// ancestor.
+	ErrNoMergeBase = errors.New("no merge base found")
+
+	// ErrCommitSignatureInvalid is returned when the checked out commit is
+	// not signed, or its signature can not be verified against
+	// CheckoutOptions.PublicKeyRing or CheckoutOptions.SSHPublicKeys.
+	ErrCommitSignatureInvalid = errors.New("commit signature invalid")
+
+	// ErrNonFastForward is returned when CheckoutOptions.RequireFastForward
+	// is set, and the commit of the branch is not a descendant of the
+	// commit of CheckoutOptions.LastRevision.
+	ErrNonFastForward = errors.New("non-fast-forward update detected")
+
+	// ErrRepositorySizeExceeded is returned when the number of bytes
+	// received while fetching exceeds CheckoutOptions.MaxSize.
+	ErrRepositorySizeExceeded = errors.New("repository size limit exceeded")
+
+	// ErrFetchTimeout is returned when a single fetch from the remote does
+	// not complete within CheckoutOptions.FetchTimeout.
+	ErrFetchTimeout = errors.New("fetch timed out")
+
+	// ErrWorktreeMismatch is returned when CheckoutOptions.VerifyWorktree is
+	// set, and the working tree does not match the tree of the checked out
+	// commit.
+	ErrWorktreeMismatch = errors.New("working tree does not match commit tree")
+
+	// ErrCheckoutConflict is returned when files modified in the working
+	// tree prevent a checkout with CheckoutModeSafe or
+	// CheckoutModeRecreateMissing.
+	ErrCheckoutConflict = errors.New("checkout conflicts with modified files")
+
+	// ErrShallowNotSupported is returned when a clone limited by
+	// CheckoutOptions.Depth fails, and the remote does not advertise
+	// support for shallow clones.
+	ErrShallowNotSupported = errors.New("remote does not support shallow clones")
+)
+
+// GitError is an error returned by an Implementation, which preserves the
+// class and code of the underlying error, allowing callers to handle specific
+// conditions without matching on the message. The values of Class and Code
+// are Implementation specific, for libgit2 they equal the git2go.ErrorClass
+// and git2go.ErrorCode of the error.
+type GitError struct {
+	// Message is the human-readable error message.
+	Message string
+	// Class is the category of the error, e.g. network or reference.
+	Class int
+	// Code is the specific error code, e.g. authentication failure.
+	Code int
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the human-readable error message.
+func (e *GitError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the underlying error.
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// UnsupportedOptionError is returned when a CheckoutOptions field is set
+// which the Implementation can not honour, and ignoring it would weaken the
+// guarantees of the checkout, e.g. skipping signature verification.
+type UnsupportedOptionError struct {
+	// Option is the name of the CheckoutOptions field.
+	Option string
+	// Implementation which does not support the option.
+	Implementation Implementation
+}
+
+// Error returns a message naming the option and Implementation.
+func (e *UnsupportedOptionError) Error() string {
+	return fmt.Sprintf("checkout option '%s' not supported by implementation '%s'", e.Option, e.Implementation)
+}
+
+// AuthenticationError is returned when the remote requires credentials which
+// were not provided, or rejects the provided credentials.
+type AuthenticationError struct {
+	// URL of the remote.
+	URL string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the message of the underlying error.
+func (e *AuthenticationError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *AuthenticationError) Unwrap() error {
+	return e.Err
+}
+
+// RefNotFoundError is returned when the reference, commit or SemVer range to
+// check out can not be resolved at the remote.
+type RefNotFoundError struct {
+	// Ref is the reference, commit or SemVer range which was not found.
+	Ref string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the message of the underlying error.
+func (e *RefNotFoundError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *RefNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// TransportError is returned when communicating with the remote fails, for
+// example due to a network error or an unexpected response.
+type TransportError struct {
+	// URL of the remote.
+	URL string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the message of the underlying error.
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// TooManyRequestsError is returned when the remote rate limits the requests
+// made to it, with HTTP status 429. It wraps a TransportError.
+type TooManyRequestsError struct {
+	// URL of the remote.
+	URL string
+	// RetryAfter is the delay requested by the remote before making another
+	// request, or zero if it did not request one.
+	RetryAfter time.Duration
+	// Err is the underlying error.
+	Err error
+}
+
+// NewTooManyRequestsError returns a TooManyRequestsError for the remote at the
+// given URL, with the delay parsed from the value of the Retry-After header,
+// which is either a number of seconds or an HTTP date.
+func NewTooManyRequestsError(url, retryAfter string, err error) *TooManyRequestsError {
+	var delay time.Duration
+	if seconds, perr := strconv.Atoi(retryAfter); perr == nil && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, perr := http.ParseTime(retryAfter); perr == nil {
+		if d := time.Until(date); d > 0 {
+			delay = d
+		}
+	}
+	return &TooManyRequestsError{
+		URL:        url,
+		RetryAfter: delay,
+		Err:        &TransportError{URL: url, Err: err},
+	}
+}
+
+// Error returns the message of the underlying error.
+func (e *TooManyRequestsError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TooManyRequestsError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestNewTooManyRequestsError(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		want       time.Duration
+	}{
+		{name: "seconds", retryAfter: "30", want: 30 * time.Second},
+		{name: "past date", retryAfter: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)},
+		{name: "invalid", retryAfter: "soon"},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := NewTooManyRequestsError("https://example.com/repo", tt.retryAfter, errors.New("rate limited"))
+			g.Expect(err.RetryAfter).To(Equal(tt.want))
+			g.Expect(err.Error()).To(Equal("rate limited"))
+
+			var transportErr *TransportError
+			g.Expect(errors.As(err, &transportErr)).To(BeTrue())
+			g.Expect(transportErr.URL).To(Equal("https://example.com/repo"))
+		})
+	}
+
+	t.Run("future date", func(t *testing.T) {
+		g := NewWithT(t)
+
+		date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+		err := NewTooManyRequestsError("https://example.com/repo", date, errors.New("rate limited"))
+		g.Expect(err.RetryAfter).To(BeNumerically("~", time.Hour, time.Minute))
+	})
+}
@@ -24,10 +24,16 @@ import (
 	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
 )
 
 type Implementation string
 
+// EmptyTreeHash is the SHA1 hash of a tree without any entries.
+const EmptyTreeHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
 type Hash []byte
 
 // String returns the SHA1 Hash as a string.
@@ -52,12 +58,76 @@ type Commit struct {
 	// Committer is the one performing the commit, might be different from
 	// Author.
 	Committer Signature
-	// Signature is the PGP signature of the commit.
+	// Signature is the PGP or SSH signature of the commit.
 	Signature string
 	// Encoded is the encoded commit, without any signature.
 	Encoded []byte
 	// Message is the commit message, contains arbitrary text.
 	Message string
+	// Parents holds the hashes of the parent commits, in order. It is empty
+	// for a root commit, and has more than one entry for a merge commit.
+	// Nil for a partial commit constructed without the commit object.
+	Parents []Hash
+	// EmptyTree is true if the tree of the commit does not have any entries,
+	// in which case a successful checkout results in an empty working
+	// directory.
+	EmptyTree bool
+	// Warnings holds the non-fatal issues encountered while checking out the
+	// commit. Each warning is prefixed with its WarningCode, see
+	// ParseWarning.
+	Warnings []string
+	// URL is the URL the commit was checked out from, which differs from
+	// the URL of the repository when it was checked out from one of the
+	// CheckoutOptions.MirrorURLs. Only set if MirrorURLs are configured.
+	URL string
+	// Transfer holds the final statistics of fetching the objects of the
+	// commit, if CheckoutOptions.Progress was set and objects were fetched.
+	Transfer *TransferProgress
+	// Tag holds the annotated tag the commit was checked out through. Nil
+	// for lightweight tags, and when the commit was not checked out through
+	// a tag.
+	Tag *Tag
+	// Notes holds the content of the git note attached to the commit in
+	// CheckoutOptions.NotesRef. Empty if NotesRef is not set, or the commit
+	// does not have a note.
+	Notes string
+	// Branches holds the full names of the branches containing the commit,
+	// for example 'refs/heads/main', if CheckoutOptions.ResolveBranches is
+	// set. Empty when the commit is not on any branch.
+	Branches []string
+	// Stats holds the statistics of the repository the commit was checked
+	// out from, if CheckoutOptions.CollectStats is set.
+	Stats *RepositoryStats
+	// Partial is true when the commit was not checked out, as it still
+	// equals the CheckoutOptions.LastRevision. Only Hash and Reference are
+	// set then.
+	Partial bool
+}
+
+// RepositoryStats describes the local repository of a checkout.
+type RepositoryStats struct {
+	// Objects is the number of objects in the object database.
+	Objects int
+	// Size is the number of bytes the object database takes up on disk.
+	Size int64
+	// Refs is the number of references, including remote-tracking
+	// branches and tags.
+	Refs int
+	// LargestBlob is the size in bytes of the largest blob.
+	LargestBlob int64
+}
+
+// Tag is an annotated tag.
+type Tag struct {
+	// Hash is the SHA1 hash of the tag object.
+	Hash Hash
+	// Name is the name of the tag, for example 'v1.0.0'.
+	Name string
+	// Tagger is the one who created the tag. Zero if the tag does not
+	// record a tagger.
+	Tagger Signature
+	// Message is the tag message, contains arbitrary text.
+	Message string
 }
 
 // String returns a string representation of the Commit, composed
@@ -93,6 +163,124 @@ func (c *Commit) Verify(keyRing ...string) (string, error) {
 	return "", fmt.Errorf("failed to verify commit with any of the given key rings")
 }
 
+// VerifySignature verifies the given armored signature over the payload of a
+// commit or tag. The format of the signature is detected from its armor: SSH
+// signatures, as created by Git with 'gpg.format=ssh', are verified against
+// the public keys in authorizedKeys, which uses the authorized_keys format.
+// Any other signature is verified as OpenPGP against keyRing. It returns the
+// fingerprint of the key the signature was verified with, or an error.
+func VerifySignature(signature string, payload []byte, keyRing, authorizedKeys string) (string, error) {
+	if signature == "" {
+		return "", fmt.Errorf("payload does not have a signature")
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(signature), sshSignatureArmorStart) {
+		if authorizedKeys == "" {
+			return "", fmt.Errorf("payload has a SSH signature, but no SSH public keys are configured")
+		}
+		keys, err := parseAuthorizedKeys([]byte(authorizedKeys))
+		if err != nil {
+			return "", fmt.Errorf("failed to parse SSH public keys: %w", err)
+		}
+		sig, err := parseSSHSignature(signature)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse SSH signature: %w", err)
+		}
+		if sig.Namespace != sshSignatureNamespace {
+			return "", fmt.Errorf("unexpected SSH signature namespace '%s'", sig.Namespace)
+		}
+		if err = sig.verify(payload); err != nil {
+			return "", fmt.Errorf("failed to verify SSH signature: %w", err)
+		}
+		fingerprint := ssh.FingerprintSHA256(sig.PublicKey)
+		for _, k := range keys {
+			if bytes.Equal(k.Marshal(), sig.PublicKey.Marshal()) {
+				return fingerprint, nil
+			}
+		}
+		return "", fmt.Errorf("key '%s' is not one of the given SSH public keys", fingerprint)
+	}
+
+	if keyRing == "" {
+		return "", fmt.Errorf("payload has a PGP signature, but no PGP key ring is configured")
+	}
+	c := &Commit{Signature: signature, Encoded: payload}
+	return c.Verify(keyRing)
+}
+
+// VerifySSHAllowedSigners verifies the SSH signature of the commit against the
+// given allowed_signers file contents, as used by Git's SSH signing support.
+// The signing key must be listed for a principal matching the email of the
+// Committer, for the "git" namespace, and be valid at the time of the commit.
+// It returns the fingerprint of the key the signature was verified with, or
+// an error.
+func (c *Commit) VerifySSHAllowedSigners(allowedSigners []byte) (string, error) {
+	if c.Signature == "" {
+		return "", fmt.Errorf("commit does not have a SSH signature")
+	}
+	sig, err := parseSSHSignature(c.Signature)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse SSH signature: %w", err)
+	}
+	if sig.Namespace != sshSignatureNamespace {
+		return "", fmt.Errorf("unexpected SSH signature namespace '%s'", sig.Namespace)
+	}
+	signers, err := parseAllowedSigners(allowedSigners)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse allowed signers: %w", err)
+	}
+	if err = sig.verify(c.Encoded); err != nil {
+		return "", fmt.Errorf("failed to verify SSH signature: %w", err)
+	}
+
+	fingerprint := ssh.FingerprintSHA256(sig.PublicKey)
+	for _, s := range signers {
+		if !bytes.Equal(s.PublicKey.Marshal(), sig.PublicKey.Marshal()) {
+			continue
+		}
+		if s.allows(c.Committer.Email, sshSignatureNamespace, c.Committer.When) {
+			return fingerprint, nil
+		}
+	}
+	return "", fmt.Errorf("key '%s' is not an allowed signer for '%s'", fingerprint, c.Committer.Email)
+}
+
+// SignerKeyID returns the identifier of the key the commit was signed with,
+// as declared by the Signature. For PGP signatures this is the issuer key ID
+// in hexadecimal, for SSH signatures the SHA256 fingerprint of the public
+// key. The signature is only parsed, not verified, and the result must not
+// be trusted for anything other than informational purposes.
+func (c *Commit) SignerKeyID() (string, error) {
+	if c.Signature == "" {
+		return "", fmt.Errorf("commit does not have a signature")
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(c.Signature), sshSignatureArmorStart) {
+		sig, err := parseSSHSignature(c.Signature)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse SSH signature: %w", err)
+		}
+		return ssh.FingerprintSHA256(sig.PublicKey), nil
+	}
+
+	block, err := armor.Decode(strings.NewReader(c.Signature))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode PGP signature: %w", err)
+	}
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read PGP signature packet: %w", err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return "", fmt.Errorf("unexpected PGP packet type %T", p)
+	}
+	if sig.IssuerKeyId == nil {
+		return "", fmt.Errorf("PGP signature does not have an issuer key ID")
+	}
+	return fmt.Sprintf("%016X", *sig.IssuerKeyId), nil
+}
+
 // ShortMessage returns the first 50 characters of a commit subject.
 func (c *Commit) ShortMessage() string {
 	subject := strings.Split(c.Message, "\n")[0]
@@ -103,6 +291,121 @@ func (c *Commit) ShortMessage() string {
 	return subject
 }
 
+// Trailers returns the trailers of the commit message, which are the
+// "Key: value" lines of its last paragraph, as defined by
+// git-interpret-trailers(1). Lines starting with whitespace continue the
+// value of the preceding trailer. Values of a key which occurs more than once,
+// like Signed-off-by, are returned in order. The map is empty if the message
+// does not end with a trailer block.
+func (c *Commit) Trailers() map[string][]string {
+	trailers := make(map[string][]string)
+
+	lines := strings.Split(strings.ReplaceAll(c.Message, "\r\n", "\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	// The trailer block must be separated from the subject, and the body if
+	// any, by a blank line.
+	start := -1
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) == "" {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return trailers
+	}
+
+	type trailer struct{ key, value string }
+	var block []trailer
+	for _, l := range lines[start:] {
+		if l[0] == ' ' || l[0] == '\t' {
+			if len(block) == 0 {
+				return trailers
+			}
+			block[len(block)-1].value += " " + strings.TrimSpace(l)
+			continue
+		}
+		key, value, ok := strings.Cut(l, ":")
+		if !ok || !isTrailerKey(key) {
+			return trailers
+		}
+		block = append(block, trailer{key: key, value: strings.TrimSpace(value)})
+	}
+	for _, t := range block {
+		trailers[t.key] = append(trailers[t.key], t.value)
+	}
+	return trailers
+}
+
+// isTrailerKey returns if the given string is a valid trailer key, which
+// consists of alphanumeric characters and hyphens.
+func isTrailerKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// RepoInfo describes a remote repository, as derived from the references it
+// advertises.
+type RepoInfo struct {
+	// DefaultBranch is the name of the branch the remote HEAD points to.
+	// It is empty if it could not be determined.
+	DefaultBranch string
+	// Branches holds the names of all branches, for example 'main'.
+	Branches []string
+	// Tags holds the names of all tags, for example 'v1.0.0'.
+	Tags []string
+	// Empty is true if the remote does not advertise any references.
+	Empty bool
+}
+
+// RemoteRef is a reference advertised by a remote repository.
+type RemoteRef struct {
+	// Name is the full name of the reference, for example 'refs/tags/v1.0.0'.
+	Name string
+	// Hash is the SHA1 hash of the commit the reference points to, with
+	// annotated tags peeled to their target.
+	Hash Hash
+}
+
+// WarningCode identifies the kind of a non-fatal issue recorded in
+// Commit.Warnings, allowing consumers to react to it programmatically.
+type WarningCode string
+
+const (
+	// WarningShallowFetchUnsupported indicates a shallow fetch was requested
+	// but not supported, and a full fetch was performed instead.
+	WarningShallowFetchUnsupported WarningCode = "ShallowFetchUnsupported"
+	// WarningPartialCloneUnsupported indicates a partial clone was requested
+	// but not supported, and all objects were fetched instead.
+	WarningPartialCloneUnsupported WarningCode = "PartialCloneUnsupported"
+)
+
+// NewWarning formats a warning with the given code and message, for
+// inclusion in Commit.Warnings.
+func NewWarning(code WarningCode, msg string) string {
+	return fmt.Sprintf("%s: %s", code, msg)
+}
+
+// ParseWarning returns the WarningCode and message of the given warning, as
+// formatted by NewWarning. The code is empty if the warning is not prefixed
+// with one.
+func ParseWarning(warning string) (WarningCode, string) {
+	code, msg, ok := strings.Cut(warning, ": ")
+	if !ok || code == "" || strings.ContainsAny(code, " \t") {
+		return "", warning
+	}
+	return WarningCode(code), msg
+}
+
 type CheckoutStrategy interface {
 	Checkout(ctx context.Context, path, url string, config *AuthOptions) (*Commit, error)
 }
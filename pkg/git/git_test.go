@@ -21,6 +21,7 @@ import (
 	"time"
 
 	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
 )
 
 const (
@@ -220,6 +221,165 @@ func TestCommit_Verify(t *testing.T) {
 	}
 }
 
+func TestCommit_SignerKeyID(t *testing.T) {
+	g := NewWithT(t)
+	sshSigner := newSSHSigner(g)
+
+	tests := []struct {
+		name    string
+		commit  *Commit
+		want    string
+		wantErr string
+	}{
+		{
+			name: "PGP signature",
+			commit: &Commit{
+				Encoded:   []byte(malformedEncodedCommitFixture),
+				Signature: signatureCommitFixture,
+			},
+			want: keyRingFingerprintFixture,
+		},
+		{
+			name: "SSH signature",
+			commit: &Commit{
+				Encoded:   []byte(malformedEncodedCommitFixture),
+				Signature: sshSign(g, sshSigner, sshSignatureNamespace, []byte(encodedCommitFixture)),
+			},
+			want: ssh.FingerprintSHA256(sshSigner.PublicKey()),
+		},
+		{
+			name: "Malformed signature",
+			commit: &Commit{
+				Signature: "-----BEGIN PGP SIGNATURE-----\n\ninvalid\n-----END PGP SIGNATURE-----",
+			},
+			wantErr: "failed to read PGP signature packet",
+		},
+		{
+			name:    "Missing signature",
+			commit:  &Commit{},
+			wantErr: "commit does not have a signature",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := tt.commit.SignerKeyID()
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				g.Expect(got).To(BeEmpty())
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	g := NewWithT(t)
+
+	signer := newSSHSigner(g)
+	other := newSSHSigner(g)
+	authorizedKeys := string(ssh.MarshalAuthorizedKey(other.PublicKey())) +
+		"# trusted signer\n" + "no-pty " + string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	tagPayload := []byte("object eb167bc68d0a11530923b1f24b4978535d10b879\ntype commit\ntag v1.0.0\n" +
+		"tagger Jane Doe <jane@example.com> 1654084800 +0000\n\nRelease v1.0.0\n")
+
+	tests := []struct {
+		name           string
+		signature      string
+		payload        []byte
+		keyRing        string
+		authorizedKeys string
+		want           string
+		wantErr        string
+	}{
+		{
+			name:           "SSH signed commit",
+			signature:      sshSign(g, signer, sshSignatureNamespace, []byte(encodedCommitFixture)),
+			payload:        []byte(encodedCommitFixture),
+			authorizedKeys: authorizedKeys,
+			want:           ssh.FingerprintSHA256(signer.PublicKey()),
+		},
+		{
+			name:           "SSH signed annotated tag",
+			signature:      sshSign(g, signer, sshSignatureNamespace, tagPayload),
+			payload:        tagPayload,
+			keyRing:        armoredKeyRingFixture,
+			authorizedKeys: authorizedKeys,
+			want:           ssh.FingerprintSHA256(signer.PublicKey()),
+		},
+		{
+			name:           "SSH signature of other payload",
+			signature:      sshSign(g, signer, sshSignatureNamespace, tagPayload),
+			payload:        []byte(encodedCommitFixture),
+			authorizedKeys: authorizedKeys,
+			wantErr:        "failed to verify SSH signature",
+		},
+		{
+			name:           "SSH signature with unexpected namespace",
+			signature:      sshSign(g, signer, "file", []byte(encodedCommitFixture)),
+			payload:        []byte(encodedCommitFixture),
+			authorizedKeys: authorizedKeys,
+			wantErr:        "unexpected SSH signature namespace 'file'",
+		},
+		{
+			name:           "SSH signature of unknown key",
+			signature:      sshSign(g, signer, sshSignatureNamespace, []byte(encodedCommitFixture)),
+			payload:        []byte(encodedCommitFixture),
+			authorizedKeys: string(ssh.MarshalAuthorizedKey(other.PublicKey())),
+			wantErr:        "is not one of the given SSH public keys",
+		},
+		{
+			name:      "SSH signature without SSH public keys",
+			signature: sshSign(g, signer, sshSignatureNamespace, []byte(encodedCommitFixture)),
+			payload:   []byte(encodedCommitFixture),
+			keyRing:   armoredKeyRingFixture,
+			wantErr:   "no SSH public keys are configured",
+		},
+		{
+			name:           "PGP signed commit",
+			signature:      signatureCommitFixture,
+			payload:        []byte(encodedCommitFixture),
+			keyRing:        armoredKeyRingFixture,
+			authorizedKeys: authorizedKeys,
+			want:           keyRingFingerprintFixture,
+		},
+		{
+			name:           "PGP signature without key ring",
+			signature:      signatureCommitFixture,
+			payload:        []byte(encodedCommitFixture),
+			authorizedKeys: authorizedKeys,
+			wantErr:        "no PGP key ring is configured",
+		},
+		{
+			name:    "Missing signature",
+			payload: []byte(encodedCommitFixture),
+			keyRing: armoredKeyRingFixture,
+			wantErr: "payload does not have a signature",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := VerifySignature(tt.signature, tt.payload, tt.keyRing, tt.authorizedKeys)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				g.Expect(got).To(BeEmpty())
+				return
+			}
+
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
+
 func TestCommit_ShortMessage(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -265,6 +425,113 @@ of the commit`,
 	}
 }
 
+func TestCommit_Trailers(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    map[string][]string
+	}{
+		{
+			name: "trailer block",
+			message: `title of the commit
+
+detailed description
+of the commit
+
+Change-Id: I0123456789abcdef
+Signed-off-by: Jane Doe <jane@example.com>
+Signed-off-by: John Doe <john@example.com>
+`,
+			want: map[string][]string{
+				"Change-Id":     {"I0123456789abcdef"},
+				"Signed-off-by": {"Jane Doe <jane@example.com>", "John Doe <john@example.com>"},
+			},
+		},
+		{
+			name:    "trailer block without body",
+			message: "title of the commit\n\nCo-authored-by: Jane Doe <jane@example.com>",
+			want: map[string][]string{
+				"Co-authored-by": {"Jane Doe <jane@example.com>"},
+			},
+		},
+		{
+			name:    "continuation line",
+			message: "title of the commit\r\n\r\nReviewed-by: Jane Doe\r\n  <jane@example.com>\r\n",
+			want: map[string][]string{
+				"Reviewed-by": {"Jane Doe <jane@example.com>"},
+			},
+		},
+		{
+			name: "last paragraph is not a trailer block",
+			message: `title of the commit
+
+Signed-off-by: Jane Doe <jane@example.com>
+and this line is part of the body`,
+			want: map[string][]string{},
+		},
+		{
+			name: "last paragraph with prose",
+			message: `title of the commit
+
+Fixes the following: a bug`,
+			want: map[string][]string{},
+		},
+		{
+			name:    "subject only",
+			message: "Signed-off-by: Jane Doe <jane@example.com>",
+			want:    map[string][]string{},
+		},
+		{
+			name:    "empty message",
+			message: "",
+			want:    map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := Commit{Message: tt.message}
+			g.Expect(c.Trailers()).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestParseWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		warning  string
+		wantCode WarningCode
+		wantMsg  string
+	}{
+		{
+			name:     "warning with code",
+			warning:  NewWarning(WarningShallowFetchUnsupported, "falling back to full fetch: depth 1"),
+			wantCode: WarningShallowFetchUnsupported,
+			wantMsg:  "falling back to full fetch: depth 1",
+		},
+		{
+			name:    "warning without code",
+			warning: "falling back to full fetch: depth 1",
+			wantMsg: "falling back to full fetch: depth 1",
+		},
+		{
+			name:    "plain warning",
+			warning: "something happened",
+			wantMsg: "something happened",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			code, msg := ParseWarning(tt.warning)
+			g.Expect(code).To(Equal(tt.wantCode))
+			g.Expect(msg).To(Equal(tt.wantMsg))
+		})
+	}
+}
+
 func TestIsConcreteCommit(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -21,9 +21,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/Masterminds/semver/v3"
 	extgogit "github.com/go-git/go-git/v5"
@@ -32,6 +34,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-logr/logr"
 
 	"github.com/fluxcd/pkg/gitutil"
 	"github.com/fluxcd/pkg/version"
@@ -41,27 +44,213 @@ import (
 
 // CheckoutStrategyForOptions returns the git.CheckoutStrategy for the given
 // git.CheckoutOptions.
-func CheckoutStrategyForOptions(_ context.Context, opts git.CheckoutOptions) git.CheckoutStrategy {
+func CheckoutStrategyForOptions(ctx context.Context, opts git.CheckoutOptions) git.CheckoutStrategy {
+	for _, o := range unsupportedOptions {
+		if o.set(opts) {
+			return &unsupportedOption{option: o.option}
+		}
+	}
+	for _, msg := range ignoredOptionMessages(opts) {
+		logr.FromContextOrDiscard(ctx).Info(msg)
+	}
+	if opts.MaxFileSize > 0 && !opts.ResolveOnly {
+		// Resolve the commit without writing the working tree, which is only
+		// written once the size of the files has been validated.
+		resolveOpts := opts
+		resolveOpts.ResolveOnly = true
+		return &checkoutWithMaxFileSize{
+			strategy:          checkoutStrategy(resolveOpts),
+			limit:             opts.MaxFileSize,
+			recurseSubmodules: opts.RecurseSubmodules,
+		}
+	}
+	return checkoutStrategy(opts)
+}
+
+// unsupportedOptions are the options which can not be ignored without
+// weakening the checkout, in the order they are checked in.
+var unsupportedOptions = []struct {
+	option string
+	set    func(git.CheckoutOptions) bool
+}{
+	{"PublicKeyRing", func(o git.CheckoutOptions) bool { return o.PublicKeyRing != "" }},
+	{"SSHPublicKeys", func(o git.CheckoutOptions) bool { return o.SSHPublicKeys != "" }},
+	{"AllowedSignersPath", func(o git.CheckoutOptions) bool { return o.AllowedSignersPath != "" }},
+	{"RequireFastForward", func(o git.CheckoutOptions) bool { return o.RequireFastForward }},
+	{"RollbackCommit", func(o git.CheckoutOptions) bool { return o.RollbackCommit != "" }},
+}
+
+// ignoredOptions are the options which are not supported by this
+// Implementation, and are ignored with the described fallback.
+var ignoredOptions = []struct {
+	feature  string
+	fallback string
+	set      func(git.CheckoutOptions) bool
+}{
+	{"git shallow-since fetch", "falling back to depth-based fetch", func(o git.CheckoutOptions) bool { return !o.ShallowSince.IsZero() }},
+	{"git partial clone", "falling back to full fetch", func(o git.CheckoutOptions) bool { return o.Filter != "" }},
+	{"git autocrlf configuration", "files are written as stored", func(o git.CheckoutOptions) bool { return o.AutoCRLF != "" }},
+	{"git mirror URLs", "ignoring mirrors", func(o git.CheckoutOptions) bool { return len(o.MirrorURLs) > 0 }},
+	{"git checkout metrics", "ignoring recorder", func(o git.CheckoutOptions) bool { return o.Metrics != nil }},
+	{"git metadata removal", "keeping .git directory", func(o git.CheckoutOptions) bool { return o.RemoveGitMetadata }},
+	{"git repository size limit", "ignoring limit", func(o git.CheckoutOptions) bool { return o.MaxSize > 0 }},
+	{"git custom index path", "", func(o git.CheckoutOptions) bool { return o.IndexPath != "" }},
+	{"git transfer progress", "", func(o git.CheckoutOptions) bool { return o.Progress != nil }},
+	{"git repository reuse", "falling back to clone", func(o git.CheckoutOptions) bool { return o.ReuseRepository }},
+	{"git fetch retries", "falling back to a single attempt", func(o git.CheckoutOptions) bool { return o.FetchRetries > 0 }},
+	{"git fetch timeout", "falling back to the context deadline", func(o git.CheckoutOptions) bool { return o.FetchTimeout > 0 }},
+	{"git notes", "", func(o git.CheckoutOptions) bool { return o.NotesRef != "" }},
+	{"git commit branch resolution", "", func(o git.CheckoutOptions) bool { return o.ResolveBranches }},
+	{"git repository statistics", "", func(o git.CheckoutOptions) bool { return o.CollectStats }},
+	{"git checkout mode", "falling back to force", func(o git.CheckoutOptions) bool {
+		return o.CheckoutMode != "" && o.CheckoutMode != git.CheckoutModeForce
+	}},
+	{"git sparse checkout", "falling back to a full checkout", func(o git.CheckoutOptions) bool { return len(o.SparsePaths) > 0 }},
+	{"git working tree verification", "", func(o git.CheckoutOptions) bool { return o.VerifyWorktree }},
+}
+
+// ignoredOptionMessages returns a message for every option set in the given
+// git.CheckoutOptions which is ignored by this Implementation.
+func ignoredOptionMessages(opts git.CheckoutOptions) []string {
+	var msgs []string
+	for _, o := range ignoredOptions {
+		if !o.set(opts) {
+			continue
+		}
+		msg := fmt.Sprintf("%s not supported by implementation '%s'", o.feature, Implementation)
+		if o.fallback != "" {
+			msg += ", " + o.fallback
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// checkoutStrategy returns the git.CheckoutStrategy for the reference the
+// given git.CheckoutOptions point to.
+func checkoutStrategy(opts git.CheckoutOptions) git.CheckoutStrategy {
 	switch {
+	case opts.Name != "":
+		return &CheckoutRef{Name: opts.Name, RecurseSubmodules: opts.RecurseSubmodules, ResolveOnly: opts.ResolveOnly}
 	case opts.Commit != "":
-		return &CheckoutCommit{Branch: opts.Branch, Commit: opts.Commit, RecurseSubmodules: opts.RecurseSubmodules}
+		return &CheckoutCommit{Branch: opts.Branch, Commit: opts.Commit, RecurseSubmodules: opts.RecurseSubmodules, ResolveOnly: opts.ResolveOnly}
 	case opts.SemVer != "":
-		return &CheckoutSemVer{SemVer: opts.SemVer, RecurseSubmodules: opts.RecurseSubmodules}
+		return &CheckoutSemVer{SemVer: opts.SemVer, TagPrefix: opts.TagPrefix, TagFilter: opts.TagFilter, IgnorePrerelease: opts.IgnorePrerelease,
+			RecurseSubmodules: opts.RecurseSubmodules, ResolveOnly: opts.ResolveOnly}
+	case opts.LatestTag:
+		return &CheckoutLatestTag{TagPrefix: opts.TagPrefix, TagFilter: opts.TagFilter, IgnorePrerelease: opts.IgnorePrerelease,
+			RecurseSubmodules: opts.RecurseSubmodules, ResolveOnly: opts.ResolveOnly}
 	case opts.Tag != "":
-		return &CheckoutTag{Tag: opts.Tag, RecurseSubmodules: opts.RecurseSubmodules, LastRevision: opts.LastRevision}
+		return &CheckoutTag{Tag: opts.Tag, RecurseSubmodules: opts.RecurseSubmodules, LastRevision: opts.LastRevision, Depth: opts.Depth, ResolveOnly: opts.ResolveOnly}
 	default:
 		branch := opts.Branch
 		if branch == "" {
 			branch = git.DefaultBranch
 		}
-		return &CheckoutBranch{Branch: branch, RecurseSubmodules: opts.RecurseSubmodules, LastRevision: opts.LastRevision}
+		return &CheckoutBranch{Branch: branch, RecurseSubmodules: opts.RecurseSubmodules, LastRevision: opts.LastRevision, Depth: opts.Depth, ResolveOnly: opts.ResolveOnly}
+	}
+}
+
+// unsupportedOption is a git.CheckoutStrategy which fails for an option that
+// can not be ignored without weakening the checkout, rather than silently
+// checking out without it.
+type unsupportedOption struct {
+	option string
+}
+
+func (c *unsupportedOption) Checkout(_ context.Context, _, _ string, _ *git.AuthOptions) (*git.Commit, error) {
+	return nil, &git.UnsupportedOptionError{Option: c.option, Implementation: Implementation}
+}
+
+// checkoutWithMaxFileSize is a git.CheckoutStrategy which resolves the
+// commit using a strategy which does not write the working tree, and checks
+// the commit out only when no file in its tree exceeds the limit.
+type checkoutWithMaxFileSize struct {
+	strategy          git.CheckoutStrategy
+	limit             int64
+	recurseSubmodules bool
+}
+
+func (c *checkoutWithMaxFileSize) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
+	commit, err := c.strategy.Checkout(ctx, path, url, opts)
+	if err != nil || commit.Partial {
+		return commit, err
+	}
+
+	repo, err := extgogit.PlainOpen(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository at '%s': %w", path, err)
+	}
+	cc, err := repo.CommitObject(plumbing.NewHash(commit.Hash.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve commit object for '%s': %w", commit.Hash, err)
+	}
+	if err = validateFileSizes(cc, c.limit); err != nil {
+		return nil, err
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Git worktree: %w", err)
+	}
+	err = w.Checkout(&extgogit.CheckoutOptions{
+		Hash:  cc.Hash,
+		Force: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to checkout commit '%s': %w", commit.Hash, err)
+	}
+	if c.recurseSubmodules {
+		authMethod, err := transportAuth(opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct auth method with options: %w", err)
+		}
+		if err = updateSubmodules(ctx, w, authMethod); err != nil {
+			return nil, err
+		}
+	}
+	return commit, nil
+}
+
+// updateSubmodules initializes and updates the submodules of the worktree,
+// recursively.
+func updateSubmodules(ctx context.Context, w *extgogit.Worktree, authMethod transport.AuthMethod) error {
+	subs, err := w.Submodules()
+	if err != nil {
+		return fmt.Errorf("failed to list submodules: %w", err)
 	}
+	if err = subs.UpdateContext(ctx, &extgogit.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: recurseSubmodules(true),
+		Auth:              authMethod,
+	}); err != nil {
+		return fmt.Errorf("failed to update submodules: %w", err)
+	}
+	return nil
+}
+
+// validateFileSizes returns git.ErrFileTooLarge naming the first file in the
+// tree of the given commit which exceeds the limit.
+func validateFileSizes(c *object.Commit, limit int64) error {
+	files, err := c.Files()
+	if err != nil {
+		return fmt.Errorf("failed to list files of commit '%s': %w", c.Hash, err)
+	}
+	return files.ForEach(func(f *object.File) error {
+		if f.Size > limit {
+			return fmt.Errorf("%w: '%s' is %d bytes, limit is %d bytes",
+				git.ErrFileTooLarge, f.Name, f.Size, limit)
+		}
+		return nil
+	})
 }
 
 type CheckoutBranch struct {
 	Branch            string
 	RecurseSubmodules bool
 	LastRevision      string
+	Depth             int
+	ResolveOnly       bool
 }
 
 func (c *CheckoutBranch) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
@@ -92,6 +281,7 @@ func (c *CheckoutBranch) Checkout(ctx context.Context, path, url string, opts *g
 			c := &git.Commit{
 				Hash:      hash,
 				Reference: plumbing.NewBranchReferenceName(c.Branch).String(),
+				Partial:   true,
 			}
 			return c, nil
 		}
@@ -103,15 +293,18 @@ func (c *CheckoutBranch) Checkout(ctx context.Context, path, url string, opts *g
 		RemoteName:        git.DefaultOrigin,
 		ReferenceName:     plumbing.NewBranchReferenceName(c.Branch),
 		SingleBranch:      true,
-		NoCheckout:        false,
-		Depth:             1,
+		NoCheckout:        c.ResolveOnly,
+		Depth:             cloneDepth(c.Depth),
 		RecurseSubmodules: recurseSubmodules(c.RecurseSubmodules),
 		Progress:          nil,
 		Tags:              extgogit.NoTags,
 		CABundle:          caBundle(opts),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to clone '%s': %w", url, gitutil.GoGitError(err))
+		if c.Depth > 0 && rejectsShallow(ctx, url, authMethod, caBundle(opts)) {
+			return nil, fmt.Errorf("unable to clone '%s' with depth %d: %w", url, c.Depth, git.ErrShallowNotSupported)
+		}
+		return nil, remoteError(url, "refs/heads/"+c.Branch, fmt.Errorf("unable to clone '%s': %w", url, gitutil.GoGitError(err)))
 	}
 	head, err := repo.Head()
 	if err != nil {
@@ -149,6 +342,8 @@ type CheckoutTag struct {
 	Tag               string
 	RecurseSubmodules bool
 	LastRevision      string
+	Depth             int
+	ResolveOnly       bool
 }
 
 func (c *CheckoutTag) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
@@ -178,6 +373,7 @@ func (c *CheckoutTag) Checkout(ctx context.Context, path, url string, opts *git.
 			c := &git.Commit{
 				Hash:      hash,
 				Reference: ref.String(),
+				Partial:   true,
 			}
 			return c, nil
 		}
@@ -188,15 +384,18 @@ func (c *CheckoutTag) Checkout(ctx context.Context, path, url string, opts *git.
 		RemoteName:        git.DefaultOrigin,
 		ReferenceName:     plumbing.NewTagReferenceName(c.Tag),
 		SingleBranch:      true,
-		NoCheckout:        false,
-		Depth:             1,
+		NoCheckout:        c.ResolveOnly,
+		Depth:             cloneDepth(c.Depth),
 		RecurseSubmodules: recurseSubmodules(c.RecurseSubmodules),
 		Progress:          nil,
 		Tags:              extgogit.NoTags,
 		CABundle:          caBundle(opts),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to clone '%s': %w", url, gitutil.GoGitError(err))
+		if c.Depth > 0 && rejectsShallow(ctx, url, authMethod, caBundle(opts)) {
+			return nil, fmt.Errorf("unable to clone '%s' with depth %d: %w", url, c.Depth, git.ErrShallowNotSupported)
+		}
+		return nil, remoteError(url, "refs/tags/"+c.Tag, fmt.Errorf("unable to clone '%s': %w", url, gitutil.GoGitError(err)))
 	}
 	head, err := repo.Head()
 	if err != nil {
@@ -206,13 +405,44 @@ func (c *CheckoutTag) Checkout(ctx context.Context, path, url string, opts *git.
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve commit object for HEAD '%s': %w", head.Hash(), err)
 	}
-	return buildCommitWithRef(cc, ref)
+	commit, err := buildCommitWithRef(cc, ref)
+	if err != nil {
+		return nil, err
+	}
+	if commit.Tag, err = lookupAnnotatedTag(repo, ref); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// lookupAnnotatedTag returns the annotated tag the given tag reference points
+// to, or nil if it is a lightweight tag.
+func lookupAnnotatedTag(repo *extgogit.Repository, ref plumbing.ReferenceName) (*git.Tag, error) {
+	r, err := repo.Reference(ref, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tag '%s': %w", ref.Short(), err)
+	}
+	t, err := repo.TagObject(r.Hash())
+	if err == plumbing.ErrObjectNotFound {
+		// Lightweight tags point directly to a commit.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tag object '%s': %w", ref.Short(), err)
+	}
+	return &git.Tag{
+		Hash:    git.Hash(t.Hash.String()),
+		Name:    t.Name,
+		Tagger:  buildSignature(t.Tagger),
+		Message: t.Message,
+	}, nil
 }
 
 type CheckoutCommit struct {
 	Branch            string
 	Commit            string
 	RecurseSubmodules bool
+	ResolveOnly       bool
 }
 
 func (c *CheckoutCommit) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
@@ -237,7 +467,7 @@ func (c *CheckoutCommit) Checkout(ctx context.Context, path, url string, opts *g
 	}
 	repo, err := extgogit.PlainCloneContext(ctx, path, false, cloneOpts)
 	if err != nil {
-		return nil, fmt.Errorf("unable to clone '%s': %w", url, gitutil.GoGitError(err))
+		return nil, remoteError(url, cloneOpts.ReferenceName.String(), fmt.Errorf("unable to clone '%s': %w", url, gitutil.GoGitError(err)))
 	}
 	w, err := repo.Worktree()
 	if err != nil {
@@ -245,7 +475,14 @@ func (c *CheckoutCommit) Checkout(ctx context.Context, path, url string, opts *g
 	}
 	cc, err := repo.CommitObject(plumbing.NewHash(c.Commit))
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve commit object for '%s': %w", c.Commit, err)
+		err = fmt.Errorf("failed to resolve commit object for '%s': %w", c.Commit, err)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			err = &git.RefNotFoundError{Ref: c.Commit, Err: err}
+		}
+		return nil, err
+	}
+	if c.ResolveOnly {
+		return buildCommitWithRef(cc, cloneOpts.ReferenceName)
 	}
 	err = w.Checkout(&extgogit.CheckoutOptions{
 		Hash:  cc.Hash,
@@ -257,9 +494,94 @@ func (c *CheckoutCommit) Checkout(ctx context.Context, path, url string, opts *g
 	return buildCommitWithRef(cc, cloneOpts.ReferenceName)
 }
 
+// CheckoutRef checks out the commit a fully qualified reference points to,
+// e.g. 'refs/pull/1/head', in detached HEAD mode.
+type CheckoutRef struct {
+	Name              string
+	RecurseSubmodules bool
+	ResolveOnly       bool
+}
+
+func (c *CheckoutRef) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
+	if !strings.HasPrefix(c.Name, "refs/") {
+		return nil, fmt.Errorf("reference '%s' is not fully qualified, it must start with 'refs/'", c.Name)
+	}
+	authMethod, err := transportAuth(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct auth method with options: %w", err)
+	}
+
+	repo, err := extgogit.PlainInit(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to init repository at '%s': %w", path, err)
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultOrigin,
+		URLs: []string{url},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create remote for '%s': %w", url, err)
+	}
+	// Fetch the exact reference, as the refspecs of a clone assume branches
+	// or tags.
+	ref := plumbing.ReferenceName(c.Name)
+	err = remote.FetchContext(ctx, &extgogit.FetchOptions{
+		RemoteName: git.DefaultOrigin,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", ref, ref))},
+		Auth:       authMethod,
+		Tags:       extgogit.NoTags,
+		CABundle:   caBundle(opts),
+	})
+	if err != nil {
+		return nil, remoteError(url, c.Name, fmt.Errorf("unable to fetch reference '%s' from '%s': %w", c.Name, url, gitutil.GoGitError(err)))
+	}
+	r, err := repo.Reference(ref, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve reference '%s': %w", c.Name, err)
+	}
+	cc, err := peelToCommit(repo, r.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve commit of reference '%s': %w", c.Name, err)
+	}
+	if c.ResolveOnly {
+		return buildCommitWithRef(cc, ref)
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Git worktree: %w", err)
+	}
+	err = w.Checkout(&extgogit.CheckoutOptions{
+		Hash:  cc.Hash,
+		Force: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to checkout reference '%s': %w", c.Name, err)
+	}
+	if c.RecurseSubmodules {
+		if err = updateSubmodules(ctx, w, authMethod); err != nil {
+			return nil, err
+		}
+	}
+	return buildCommitWithRef(cc, ref)
+}
+
+// CheckoutSemVer checks out the tag with the highest version matching the
+// SemVer constraint. Of equal versions, the tag pointing to the most recent
+// commit wins, and then the tag which sorts last in lexical order.
 type CheckoutSemVer struct {
-	SemVer            string
+	SemVer string
+	// TagPrefix limits the checkout to tags with the prefix, which is
+	// stripped before the remainder of the tag is parsed as a version.
+	TagPrefix string
+	// TagFilter limits the checkout to tags with a name matching the glob
+	// pattern, which are the only tags resolved to a commit.
+	TagFilter string
+	// IgnorePrerelease excludes pre-release versions, unless the SemVer
+	// constraint contains a pre-release version.
+	IgnorePrerelease  bool
 	RecurseSubmodules bool
+	ResolveOnly       bool
 }
 
 func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
@@ -267,6 +589,42 @@ func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *g
 	if err != nil {
 		return nil, fmt.Errorf("semver parse error: %w", err)
 	}
+	ignorePrerelease := c.IgnorePrerelease && !constraintHasPrerelease(c.SemVer)
+	return checkoutLatestVersion(ctx, path, url, opts, verConstraint, c.TagPrefix, c.TagFilter, ignorePrerelease, c.RecurseSubmodules, c.ResolveOnly,
+		&git.RefNotFoundError{Ref: c.SemVer, Err: fmt.Errorf("no match found for semver: %s", c.SemVer)})
+}
+
+// CheckoutLatestTag checks out the tag with the highest version, ordering
+// tags which only differ by build metadata like CheckoutSemVer.
+type CheckoutLatestTag struct {
+	// TagPrefix limits the checkout to tags with the prefix, which is
+	// stripped before the remainder of the tag is parsed as a version.
+	TagPrefix string
+	// TagFilter limits the checkout to tags with a name matching the glob
+	// pattern, which are the only tags resolved to a commit.
+	TagFilter string
+	// IgnorePrerelease excludes pre-release versions.
+	IgnorePrerelease  bool
+	RecurseSubmodules bool
+	ResolveOnly       bool
+}
+
+func (c *CheckoutLatestTag) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
+	return checkoutLatestVersion(ctx, path, url, opts, nil, c.TagPrefix, c.TagFilter, c.IgnorePrerelease, c.RecurseSubmodules, c.ResolveOnly,
+		&git.RefNotFoundError{Ref: "refs/tags/" + c.TagPrefix, Err: fmt.Errorf("no version tags found at '%s'", url)})
+}
+
+// checkoutLatestVersion clones the repository with all tags, and checks out
+// the tag with the latest version matching the constraint, or any version if
+// the constraint is nil. Only tags with the given prefix are considered, which
+// is stripped before they are parsed, and pre-release versions are excluded if
+// ignorePrerelease is set. Tags not matching the glob pattern of tagFilter are
+// ignored, and noMatch is returned when no tag matches.
+func checkoutLatestVersion(ctx context.Context, path, url string, opts *git.AuthOptions, constraint *semver.Constraints,
+	tagPrefix, tagFilter string, ignorePrerelease, recurse, resolveOnly bool, noMatch error) (*git.Commit, error) {
+	if _, err := filepath.Match(tagFilter, ""); err != nil {
+		return nil, fmt.Errorf("invalid tag filter '%s': %w", tagFilter, err)
+	}
 
 	authMethod, err := transportAuth(opts)
 	if err != nil {
@@ -277,15 +635,15 @@ func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *g
 		URL:               url,
 		Auth:              authMethod,
 		RemoteName:        git.DefaultOrigin,
-		NoCheckout:        false,
+		NoCheckout:        resolveOnly,
 		Depth:             1,
-		RecurseSubmodules: recurseSubmodules(c.RecurseSubmodules),
+		RecurseSubmodules: recurseSubmodules(recurse),
 		Progress:          nil,
 		Tags:              extgogit.AllTags,
 		CABundle:          caBundle(opts),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to clone '%s': %w", url, gitutil.GoGitError(err))
+		return nil, remoteError(url, "", fmt.Errorf("unable to clone '%s': %w", url, gitutil.GoGitError(err)))
 	}
 
 	repoTags, err := repo.Tags()
@@ -296,18 +654,30 @@ func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *g
 	tags := make(map[string]string)
 	tagTimestamps := make(map[string]time.Time)
 	if err = repoTags.ForEach(func(t *plumbing.Reference) error {
-		revision := plumbing.Revision(t.Name().String())
-		hash, err := repo.ResolveRevision(revision)
-		if err != nil {
-			return fmt.Errorf("unable to resolve tag revision: %w", err)
+		name := t.Name().Short()
+		if !strings.HasPrefix(name, tagPrefix) {
+			return nil
 		}
-		commit, err := repo.CommitObject(*hash)
+		if tagFilter != "" {
+			if ok, _ := filepath.Match(tagFilter, name); !ok {
+				return nil
+			}
+		}
+		commit, err := peelToCommit(repo, t.Hash())
 		if err != nil {
-			return fmt.Errorf("unable to resolve commit of a tag revision: %w", err)
+			return fmt.Errorf("unable to resolve commit of tag '%s': %w", name, err)
+		}
+		// Key the tags by their name without the prefix, which equals the
+		// original of the parsed version.
+		cleanName := strings.TrimPrefix(name, tagPrefix)
+		tagTimestamps[cleanName] = commit.Committer.When
+		// Prefer the tagger date of annotated tags, which is later than the
+		// commit date when an older commit is tagged.
+		if tagObject, err := repo.TagObject(t.Hash()); err == nil {
+			tagTimestamps[cleanName] = tagObject.Tagger.When
 		}
-		tagTimestamps[t.Name().Short()] = commit.Committer.When
 
-		tags[t.Name().Short()] = t.Strings()[1]
+		tags[cleanName] = t.Strings()[1]
 		return nil
 	}); err != nil {
 		return nil, err
@@ -319,13 +689,16 @@ func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *g
 		if err != nil {
 			continue
 		}
-		if !verConstraint.Check(v) {
+		if ignorePrerelease && v.Prerelease() != "" {
+			continue
+		}
+		if constraint != nil && !constraint.Check(v) {
 			continue
 		}
 		matchedVersions = append(matchedVersions, v)
 	}
 	if len(matchedVersions) == 0 {
-		return nil, fmt.Errorf("no match found for semver: %s", c.SemVer)
+		return nil, noMatch
 	}
 
 	// Sort versions
@@ -341,10 +714,16 @@ func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *g
 		// versions into a chronological order. This is especially important for
 		// versions that differ only by build metadata, because it is not considered
 		// a part of the comparable version in Semver
-		return tagTimestamps[left.Original()].Before(tagTimestamps[right.Original()])
+		leftTime, rightTime := tagTimestamps[left.Original()], tagTimestamps[right.Original()]
+		if !leftTime.Equal(rightTime) {
+			return leftTime.Before(rightTime)
+		}
+		// Tags pointing to commits with the same timestamp are sorted in
+		// lexical order, so that the last version is deterministic.
+		return left.Original() < right.Original()
 	})
 	v := matchedVersions[len(matchedVersions)-1]
-	t := v.Original()
+	t := tagPrefix + v.Original()
 
 	w, err := repo.Worktree()
 	if err != nil {
@@ -352,8 +731,21 @@ func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *g
 	}
 
 	ref := plumbing.NewTagReferenceName(t)
+	tagRef, err := repo.Reference(ref, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tag '%s': %w", t, err)
+	}
+	// Check out the commit the tag peels to, as the worktree does not follow
+	// annotated tags which point to other annotated tags.
+	commit, err := peelToCommit(repo, tagRef.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve commit of tag '%s': %w", t, err)
+	}
+	if resolveOnly {
+		return buildCommitWithRef(commit, ref)
+	}
 	err = w.Checkout(&extgogit.CheckoutOptions{
-		Branch: ref,
+		Hash: commit.Hash,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to checkout tag '%s': %w", t, err)
@@ -369,6 +761,43 @@ func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *g
 	return buildCommitWithRef(cc, ref)
 }
 
+// constraintHasPrerelease returns if any of the versions in the given SemVer
+// constraint has a pre-release, for example '>= 1.4.0-rc.1'.
+func constraintHasPrerelease(constraint string) bool {
+	fields := strings.FieldsFunc(constraint, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == '|'
+	})
+	for _, f := range fields {
+		v, err := semver.NewVersion(strings.TrimLeft(f, "=<>!~^"))
+		if err == nil && v.Prerelease() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// maxTagPeelDepth is the maximum number of tag objects which are followed
+// while peeling a tag, to guard against pathological tag chains.
+const maxTagPeelDepth = 10
+
+// peelToCommit returns the commit the object with the given hash points to,
+// following annotated tags which point to other annotated tags.
+func peelToCommit(repo *extgogit.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	for depth := 0; ; depth++ {
+		t, err := repo.TagObject(hash)
+		if err == plumbing.ErrObjectNotFound {
+			return repo.CommitObject(hash)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if depth == maxTagPeelDepth {
+			return nil, fmt.Errorf("tag chain exceeds the maximum depth of %d", maxTagPeelDepth)
+		}
+		hash = t.Target
+	}
+}
+
 func buildCommitWithRef(c *object.Commit, ref plumbing.ReferenceName) (*git.Commit, error) {
 	if c == nil {
 		return nil, errors.New("failed to construct commit: no object")
@@ -395,9 +824,21 @@ func buildCommitWithRef(c *object.Commit, ref plumbing.ReferenceName) (*git.Comm
 		Signature: c.PGPSignature,
 		Encoded:   b,
 		Message:   c.Message,
+		Parents:   buildParents(c),
+		EmptyTree: c.TreeHash.String() == git.EmptyTreeHash,
 	}, nil
 }
 
+// buildParents returns the hashes of the parents of the given commit, which
+// is an empty slice for a root commit.
+func buildParents(c *object.Commit) []git.Hash {
+	parents := make([]git.Hash, 0, len(c.ParentHashes))
+	for _, h := range c.ParentHashes {
+		parents = append(parents, git.Hash(h.String()))
+	}
+	return parents
+}
+
 func buildSignature(s object.Signature) git.Signature {
 	return git.Signature{
 		Name:  s.Name,
@@ -406,6 +847,15 @@ func buildSignature(s object.Signature) git.Signature {
 	}
 }
 
+// cloneDepth returns the depth to clone with for the given depth, which
+// defaults to a single commit.
+func cloneDepth(depth int) int {
+	if depth > 0 {
+		return depth
+	}
+	return 1
+}
+
 func recurseSubmodules(recurse bool) extgogit.SubmoduleRescursivity {
 	if recurse {
 		return extgogit.DefaultSubmoduleRecursionDepth
@@ -20,6 +20,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -36,7 +38,10 @@ import (
 	extgogit "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	. "github.com/onsi/gomega"
 
@@ -125,6 +130,7 @@ func TestCheckoutBranch_Checkout(t *testing.T) {
 			g.Expect(err).ToNot(HaveOccurred())
 			g.Expect(cc.String()).To(Equal(tt.branch + "/" + tt.expectedCommit))
 			g.Expect(git.IsConcreteCommit(*cc)).To(Equal(tt.expectedConcreteCommit))
+			g.Expect(cc.Partial).To(Equal(!tt.expectedConcreteCommit))
 
 			if tt.expectedConcreteCommit {
 				for k, v := range tt.filesCreated {
@@ -136,6 +142,124 @@ func TestCheckoutBranch_Checkout(t *testing.T) {
 	}
 }
 
+func TestCheckoutBranch_Depth(t *testing.T) {
+	repo, path, err := initRepo(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"init", "second", "third"} {
+		if _, err = commitFile(repo, "branch", content, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		depth       int
+		wantCommits int
+	}{
+		{
+			name:        "default depth",
+			wantCommits: 1,
+		},
+		{
+			name:        "depth limited history",
+			depth:       2,
+			wantCommits: 2,
+		},
+		{
+			name:        "depth exceeding history",
+			depth:       10,
+			wantCommits: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			branch := CheckoutBranch{
+				Branch: "master",
+				Depth:  tt.depth,
+			}
+			tmpDir := t.TempDir()
+			cc, err := branch.Checkout(context.TODO(), tmpDir, path, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(git.IsConcreteCommit(*cc)).To(BeTrue())
+			g.Expect(cc.Partial).To(BeFalse())
+
+			clone, err := extgogit.PlainOpen(tmpDir)
+			g.Expect(err).ToNot(HaveOccurred())
+			iter, err := clone.Log(&extgogit.LogOptions{})
+			g.Expect(err).ToNot(HaveOccurred())
+			var count int
+			_ = iter.ForEach(func(*object.Commit) error {
+				count++
+				return nil
+			})
+			g.Expect(count).To(Equal(tt.wantCommits))
+		})
+	}
+}
+
+func TestCheckout_ShallowNotSupported(t *testing.T) {
+	hash := plumbing.NewHash("0eb1e08a4d0f8e2d8a5b5b5a0d2e1d7e0f4c1a2b")
+
+	tests := []struct {
+		name         string
+		strategy     git.CheckoutStrategy
+		capabilities []capability.Capability
+		wantShallow  bool
+	}{
+		{
+			name:        "branch without shallow capability",
+			strategy:    &CheckoutBranch{Branch: "master", Depth: 1},
+			wantShallow: true,
+		},
+		{
+			name:        "tag without shallow capability",
+			strategy:    &CheckoutTag{Tag: "v1.0.0", Depth: 1},
+			wantShallow: true,
+		},
+		{
+			name:         "branch with shallow capability",
+			strategy:     &CheckoutBranch{Branch: "master", Depth: 1},
+			capabilities: []capability.Capability{capability.Shallow},
+		},
+		{
+			name:     "branch without depth",
+			strategy: &CheckoutBranch{Branch: "master"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			// The server advertises the references, and fails every fetch.
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				if r.Method != nethttp.MethodGet {
+					w.WriteHeader(nethttp.StatusInternalServerError)
+					return
+				}
+				ar := packp.NewAdvRefs()
+				ar.Prefix = [][]byte{[]byte("# service=git-upload-pack"), pktline.Flush}
+				for _, c := range append([]capability.Capability{capability.OFSDelta}, tt.capabilities...) {
+					g.Expect(ar.Capabilities.Add(c)).To(Succeed())
+				}
+				ar.Head = &hash
+				ar.References["refs/heads/master"] = hash
+				ar.References["refs/tags/v1.0.0"] = hash
+				w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+				g.Expect(ar.Encode(w)).To(Succeed())
+			}))
+			defer server.Close()
+
+			_, err := tt.strategy.Checkout(context.TODO(), t.TempDir(), server.URL+"/repo.git", nil)
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(errors.Is(err, git.ErrShallowNotSupported)).To(Equal(tt.wantShallow))
+		})
+	}
+}
+
 func TestCheckoutTag_Checkout(t *testing.T) {
 	type testTag struct {
 		name      string
@@ -233,10 +357,26 @@ func TestCheckoutTag_Checkout(t *testing.T) {
 
 			// Check successful checkout results.
 			g.Expect(git.IsConcreteCommit(*cc)).To(Equal(tt.expectConcreteCommit))
+			g.Expect(cc.Partial).To(Equal(!tt.expectConcreteCommit))
 			targetTagHash := tagCommits[tt.checkoutTag]
 			g.Expect(err).ToNot(HaveOccurred())
 			g.Expect(cc.String()).To(Equal(tt.checkoutTag + "/" + targetTagHash))
 
+			annotated := false
+			for _, tr := range tt.tagsInRepo {
+				if tr.name == tt.checkoutTag {
+					annotated = tr.annotated
+				}
+			}
+			if annotated && tt.expectConcreteCommit {
+				g.Expect(cc.Tag).ToNot(BeNil())
+				g.Expect(cc.Tag.Name).To(Equal(tt.checkoutTag))
+				g.Expect(cc.Tag.Message).To(ContainSubstring("Annotated tag for: " + tt.checkoutTag))
+				g.Expect(cc.Tag.Tagger.Name).To(Equal("Jane Doe"))
+			} else {
+				g.Expect(cc.Tag).To(BeNil())
+			}
+
 			// Check file content only when there's an actual checkout.
 			if tt.lastRevTag != tt.checkoutTag {
 				g.Expect(filepath.Join(tmpDir, "tag")).To(BeARegularFile())
@@ -265,25 +405,28 @@ func TestCheckoutCommit_Checkout(t *testing.T) {
 	}
 
 	tests := []struct {
-		name         string
-		commit       string
-		branch       string
-		expectCommit string
-		expectFile   string
-		expectError  string
+		name          string
+		commit        string
+		branch        string
+		expectCommit  string
+		expectFile    string
+		expectParents []git.Hash
+		expectError   string
 	}{
 		{
-			name:         "Commit",
-			commit:       firstCommit.String(),
-			expectCommit: "HEAD/" + firstCommit.String(),
-			expectFile:   "init",
+			name:          "Commit",
+			commit:        firstCommit.String(),
+			expectCommit:  "HEAD/" + firstCommit.String(),
+			expectFile:    "init",
+			expectParents: []git.Hash{},
 		},
 		{
-			name:         "Commit in specific branch",
-			commit:       secondCommit.String(),
-			branch:       "other-branch",
-			expectCommit: "other-branch/" + secondCommit.String(),
-			expectFile:   "second",
+			name:          "Commit in specific branch",
+			commit:        secondCommit.String(),
+			branch:        "other-branch",
+			expectCommit:  "other-branch/" + secondCommit.String(),
+			expectFile:    "second",
+			expectParents: []git.Hash{git.Hash(firstCommit.String())},
 		},
 		{
 			name:        "Non existing commit",
@@ -319,12 +462,101 @@ func TestCheckoutCommit_Checkout(t *testing.T) {
 			g.Expect(err).ToNot(HaveOccurred())
 			g.Expect(cc).ToNot(BeNil())
 			g.Expect(cc.String()).To(Equal(tt.expectCommit))
+			g.Expect(cc.Parents).To(Equal(tt.expectParents))
 			g.Expect(filepath.Join(tmpDir, "commit")).To(BeARegularFile())
 			g.Expect(os.ReadFile(filepath.Join(tmpDir, "commit"))).To(BeEquivalentTo(tt.expectFile))
 		})
 	}
 }
 
+func TestCheckoutRef_Checkout(t *testing.T) {
+	g := NewWithT(t)
+
+	repo, path, err := initRepo(t)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Create a commit only reachable through a pull request reference.
+	headID, err := commitFile(repo, "pull", "head", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(repo.Storer.SetReference(plumbing.NewHashReference("refs/pull/1/head", headID))).To(Succeed())
+	_, err = commitFile(repo, "pull", "main", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name         string
+		ref          string
+		wantCommit   string
+		wantErr      string
+		wantNotFound bool
+	}{
+		{
+			name:       "pull request reference",
+			ref:        "refs/pull/1/head",
+			wantCommit: headID.String(),
+		},
+		{
+			name:         "non existing reference",
+			ref:          "refs/pull/2/head",
+			wantErr:      "unable to fetch reference 'refs/pull/2/head'",
+			wantNotFound: true,
+		},
+		{
+			name:    "not fully qualified reference",
+			ref:     "pull/1/head",
+			wantErr: "reference 'pull/1/head' is not fully qualified",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ref := CheckoutRef{Name: tt.ref}
+			tmpDir := t.TempDir()
+			cc, err := ref.Checkout(context.TODO(), tmpDir, path, nil)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				var notFoundErr *git.RefNotFoundError
+				g.Expect(errors.As(err, &notFoundErr)).To(Equal(tt.wantNotFound))
+				g.Expect(cc).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.Hash.String()).To(Equal(tt.wantCommit))
+			g.Expect(cc.Reference).To(Equal(tt.ref))
+			g.Expect(os.ReadFile(filepath.Join(tmpDir, "pull"))).To(BeEquivalentTo("head"))
+		})
+	}
+}
+
+func TestCheckout_EmptyTree(t *testing.T) {
+	g := NewWithT(t)
+
+	repo, path, err := initRepo(t)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wt, err := repo.Worktree()
+	g.Expect(err).ToNot(HaveOccurred())
+	emptyCommit, err := wt.Commit("Initial empty commit", &extgogit.CommitOptions{
+		Author:    mockSignature(time.Now()),
+		Committer: mockSignature(time.Now()),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	branch := CheckoutBranch{Branch: "master"}
+	tmpDir := t.TempDir()
+	cc, err := branch.Checkout(context.TODO(), tmpDir, path, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.Hash.String()).To(Equal(emptyCommit.String()))
+	g.Expect(cc.EmptyTree).To(BeTrue())
+
+	_, err = commitFile(repo, "branch", "init", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	cc, err = branch.Checkout(context.TODO(), t.TempDir(), path, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.EmptyTree).To(BeFalse())
+}
+
 func TestCheckoutTagSemVer_Checkout(t *testing.T) {
 	now := time.Now()
 
@@ -343,7 +575,7 @@ func TestCheckoutTagSemVer_Checkout(t *testing.T) {
 			tag:        "v0.1.0+build-1",
 			annotated:  true,
 			commitTime: now.Add(10 * time.Minute),
-			tagTime:    now.Add(2 * time.Hour), // This should be ignored during TS comparisons
+			tagTime:    now.Add(2 * time.Hour), // Tagged after the commit, takes precedence over the commit time
 		},
 		{
 			tag:        "v0.1.0+build-2",
@@ -354,7 +586,7 @@ func TestCheckoutTagSemVer_Checkout(t *testing.T) {
 			tag:        "v0.1.0+build-3",
 			annotated:  true,
 			commitTime: now.Add(1 * time.Hour),
-			tagTime:    now.Add(1 * time.Hour), // This should be ignored during TS comparisons
+			tagTime:    now.Add(1 * time.Hour),
 		},
 		{
 			tag:        "0.2.0",
@@ -362,10 +594,21 @@ func TestCheckoutTagSemVer_Checkout(t *testing.T) {
 			commitTime: now,
 			tagTime:    now,
 		},
+		{
+			tag:        "v0.0.2+ci-b",
+			annotated:  false,
+			commitTime: now.Add(-time.Hour),
+		},
+		{
+			tag:        "v0.0.2+ci-a",
+			annotated:  false,
+			commitTime: now.Add(-time.Hour),
+		},
 	}
 	tests := []struct {
 		name       string
 		constraint string
+		tagFilter  string
 		expectErr  error
 		expectTag  string
 	}{
@@ -375,14 +618,31 @@ func TestCheckoutTagSemVer_Checkout(t *testing.T) {
 			expectTag:  "0.2.0",
 		},
 		{
-			name:       "Orders by SemVer and timestamp",
+			name:       "Orders by SemVer and tagger timestamp",
 			constraint: "<0.2.0",
-			expectTag:  "v0.1.0+build-3",
+			expectTag:  "v0.1.0+build-1",
+		},
+		{
+			name:       "Orders by SemVer, timestamp and tag name",
+			constraint: "0.0.2",
+			expectTag:  "v0.0.2+ci-b",
 		},
 		{
 			name:       "Errors without match",
 			constraint: ">=1.0.0",
-			expectErr:  errors.New("no match found for semver: >=1.0.0"),
+			expectErr:  &git.RefNotFoundError{Ref: ">=1.0.0", Err: errors.New("no match found for semver: >=1.0.0")},
+		},
+		{
+			name:       "Filters by tag filter",
+			constraint: "<0.2.0",
+			tagFilter:  "v0.1.0+build-[23]",
+			expectTag:  "v0.1.0+build-3",
+		},
+		{
+			name:       "Errors with invalid tag filter",
+			constraint: ">=0.0.1",
+			tagFilter:  "v0.[",
+			expectErr:  fmt.Errorf("invalid tag filter 'v0.[': %w", filepath.ErrBadPattern),
 		},
 	}
 
@@ -409,7 +669,8 @@ func TestCheckoutTagSemVer_Checkout(t *testing.T) {
 			g := NewWithT(t)
 
 			semVer := CheckoutSemVer{
-				SemVer: tt.constraint,
+				SemVer:    tt.constraint,
+				TagFilter: tt.tagFilter,
 			}
 			tmpDir := t.TempDir()
 
@@ -428,6 +689,307 @@ func TestCheckoutTagSemVer_Checkout(t *testing.T) {
 	}
 }
 
+func TestCheckoutSemVer_NestedTag(t *testing.T) {
+	g := NewWithT(t)
+
+	repo, path, err := initRepo(t)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := commitFile(repo, "tag", "nested", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	inner, err := tag(repo, c, true, "inner", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = tag(repo, inner.Hash(), true, "v1.0.0", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tmpDir := t.TempDir()
+	cc, err := (&CheckoutSemVer{SemVer: ">=1.0.0"}).Checkout(context.TODO(), tmpDir, path, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.String()).To(Equal("v1.0.0/" + c.String()))
+	g.Expect(cc.Reference).To(Equal("refs/tags/v1.0.0"))
+	g.Expect(os.ReadFile(filepath.Join(tmpDir, "tag"))).To(BeEquivalentTo("nested"))
+}
+
+func TestCheckoutSemVer_TagPrefix(t *testing.T) {
+	g := NewWithT(t)
+
+	repo, path, err := initRepo(t)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	now := time.Now()
+	for i, tt := range []string{"api/v1.2.3", "web/v2.0.0", "v3.0.0", "api/nested/v9.0.0"} {
+		c, err := commitFile(repo, "tag", tt, now.Add(time.Duration(i)*time.Minute))
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = tag(repo, c, false, tt, now.Add(time.Duration(i)*time.Minute))
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	tests := []struct {
+		name    string
+		opts    git.CheckoutOptions
+		wantRef string
+	}{
+		{name: "semver", opts: git.CheckoutOptions{SemVer: ">=1.0.0", TagPrefix: "api/"}, wantRef: "refs/tags/api/v1.2.3"},
+		{name: "nested prefix", opts: git.CheckoutOptions{SemVer: ">=1.0.0", TagPrefix: "api/nested/"}, wantRef: "refs/tags/api/nested/v9.0.0"},
+		{name: "latest tag", opts: git.CheckoutOptions{LatestTag: true, TagPrefix: "web/"}, wantRef: "refs/tags/web/v2.0.0"},
+		{name: "without prefix", opts: git.CheckoutOptions{SemVer: ">=1.0.0"}, wantRef: "refs/tags/v3.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tmpDir := t.TempDir()
+			cc, err := CheckoutStrategyForOptions(context.TODO(), tt.opts).Checkout(context.TODO(), tmpDir, path, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.Reference).To(Equal(tt.wantRef))
+			g.Expect(os.ReadFile(filepath.Join(tmpDir, "tag"))).To(BeEquivalentTo(strings.TrimPrefix(tt.wantRef, "refs/tags/")))
+		})
+	}
+}
+
+func TestCheckoutSemVer_IgnorePrerelease(t *testing.T) {
+	g := NewWithT(t)
+
+	repo, path, err := initRepo(t)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	now := time.Now()
+	for i, tt := range []string{"v1.3.0", "v1.4.0-rc.1"} {
+		c, err := commitFile(repo, "tag", tt, now.Add(time.Duration(i)*time.Minute))
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = tag(repo, c, false, tt, now.Add(time.Duration(i)*time.Minute))
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	tests := []struct {
+		name    string
+		opts    git.CheckoutOptions
+		wantRef string
+	}{
+		{name: "semver includes pre-release by default", opts: git.CheckoutOptions{SemVer: ">=1.3.0-0"}, wantRef: "refs/tags/v1.4.0-rc.1"},
+		{name: "semver ignores pre-release", opts: git.CheckoutOptions{SemVer: ">=1.3.0", IgnorePrerelease: true}, wantRef: "refs/tags/v1.3.0"},
+		{name: "semver constraint with pre-release", opts: git.CheckoutOptions{SemVer: ">=1.4.0-rc.0", IgnorePrerelease: true}, wantRef: "refs/tags/v1.4.0-rc.1"},
+		{name: "latest tag includes pre-release by default", opts: git.CheckoutOptions{LatestTag: true}, wantRef: "refs/tags/v1.4.0-rc.1"},
+		{name: "latest tag ignores pre-release", opts: git.CheckoutOptions{LatestTag: true, IgnorePrerelease: true}, wantRef: "refs/tags/v1.3.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tmpDir := t.TempDir()
+			cc, err := CheckoutStrategyForOptions(context.TODO(), tt.opts).Checkout(context.TODO(), tmpDir, path, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.Reference).To(Equal(tt.wantRef))
+		})
+	}
+}
+
+func Test_constraintHasPrerelease(t *testing.T) {
+	tests := []struct {
+		constraint string
+		want       bool
+	}{
+		{constraint: ">=1.3.0", want: false},
+		{constraint: "*", want: false},
+		{constraint: ">=1.4.0-rc.1", want: true},
+		{constraint: ">= 1.4.0-0, < 2.0.0", want: true},
+		{constraint: "1.2.0 || ^v1.4.0-beta", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(constraintHasPrerelease(tt.constraint)).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestCheckoutLatestTag_Checkout(t *testing.T) {
+	g := NewWithT(t)
+
+	repo, path, err := initRepo(t)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	now := time.Now()
+	_, err = commitFile(repo, "tag", "untagged", now.Add(-time.Minute))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tmpDir := t.TempDir()
+	_, err = (&CheckoutLatestTag{}).Checkout(context.TODO(), tmpDir, path, nil)
+	var notFoundErr *git.RefNotFoundError
+	g.Expect(errors.As(err, &notFoundErr)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring("no version tags found"))
+
+	for i, tt := range []struct {
+		tag     string
+		content string
+	}{
+		{tag: "v1.0.0", content: "v1.0.0"},
+		{tag: "v2.0.0", content: "v2.0.0"},
+		{tag: "v1.5.0", content: "v1.5.0"},
+		{tag: "latest", content: "latest"},
+	} {
+		c, err := commitFile(repo, "tag", tt.content, now.Add(time.Duration(i)*time.Minute))
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = tag(repo, c, true, tt.tag, now.Add(time.Duration(i)*time.Minute))
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	tmpDir = t.TempDir()
+	cc, err := CheckoutStrategyForOptions(context.TODO(), git.CheckoutOptions{LatestTag: true}).Checkout(context.TODO(), tmpDir, path, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.Reference).To(Equal("refs/tags/v2.0.0"))
+	g.Expect(os.ReadFile(filepath.Join(tmpDir, "tag"))).To(BeEquivalentTo("v2.0.0"))
+}
+
+func TestCheckout_MaxFileSize(t *testing.T) {
+	g := NewWithT(t)
+
+	repo, path, err := initRepo(t)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	small, err := commitFile(repo, "small", "small", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = tag(repo, small, false, "v1.0.0", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	large, err := commitFile(repo, "large", strings.Repeat("x", 64), time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name    string
+		opts    git.CheckoutOptions
+		wantErr bool
+	}{
+		{name: "branch exceeding limit", opts: git.CheckoutOptions{Branch: "master", MaxFileSize: 32}, wantErr: true},
+		{name: "commit exceeding limit", opts: git.CheckoutOptions{Commit: large.String(), MaxFileSize: 32}, wantErr: true},
+		{name: "tag within limit", opts: git.CheckoutOptions{Tag: "v1.0.0", MaxFileSize: 32}},
+		{name: "branch within limit", opts: git.CheckoutOptions{Branch: "master", MaxFileSize: 64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tmpDir := t.TempDir()
+			cc, err := CheckoutStrategyForOptions(context.TODO(), tt.opts).Checkout(context.TODO(), tmpDir, path, nil)
+			if tt.wantErr {
+				g.Expect(errors.Is(err, git.ErrFileTooLarge)).To(BeTrue())
+				g.Expect(err.Error()).To(ContainSubstring("'large'"))
+				g.Expect(cc).To(BeNil())
+				g.Expect(filepath.Join(tmpDir, "small")).ToNot(BeAnExistingFile())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(filepath.Join(tmpDir, "small")).To(BeARegularFile())
+		})
+	}
+}
+
+func TestCheckout_ResolveOnly(t *testing.T) {
+	g := NewWithT(t)
+
+	repo, path, err := initRepo(t)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := commitFile(repo, "resolve", "init", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = tag(repo, c, true, "v1.0.0", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name     string
+		strategy git.CheckoutStrategy
+		wantRef  string
+	}{
+		{name: "branch", strategy: &CheckoutBranch{Branch: "master", ResolveOnly: true}, wantRef: "refs/heads/master"},
+		{name: "tag", strategy: &CheckoutTag{Tag: "v1.0.0", ResolveOnly: true}, wantRef: "refs/tags/v1.0.0"},
+		{name: "commit", strategy: &CheckoutCommit{Commit: c.String(), ResolveOnly: true}, wantRef: "HEAD"},
+		{name: "semver", strategy: &CheckoutSemVer{SemVer: ">=1.0.0", ResolveOnly: true}, wantRef: "refs/tags/v1.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tmpDir := t.TempDir()
+			cc, err := tt.strategy.Checkout(context.TODO(), tmpDir, path, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.Hash.String()).To(Equal(c.String()))
+			g.Expect(cc.Reference).To(Equal(tt.wantRef))
+			g.Expect(cc.Message).To(Equal("Adding: resolve"))
+			g.Expect(cc.Partial).To(BeFalse())
+			g.Expect(filepath.Join(tmpDir, "resolve")).ToNot(BeAnExistingFile())
+		})
+	}
+}
+
+func TestCheckoutStrategyForOptions_Unsupported(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       git.CheckoutOptions
+		wantOption string
+	}{
+		{name: "public key ring", opts: git.CheckoutOptions{PublicKeyRing: "keyring"}, wantOption: "PublicKeyRing"},
+		{name: "ssh public keys", opts: git.CheckoutOptions{SSHPublicKeys: "ssh-ed25519 AAAA"}, wantOption: "SSHPublicKeys"},
+		{name: "allowed signers", opts: git.CheckoutOptions{AllowedSignersPath: ".github/allowed_signers"}, wantOption: "AllowedSignersPath"},
+		{name: "require fast-forward", opts: git.CheckoutOptions{RequireFastForward: true}, wantOption: "RequireFastForward"},
+		{name: "rollback commit", opts: git.CheckoutOptions{RollbackCommit: "commit"}, wantOption: "RollbackCommit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tmpDir := t.TempDir()
+			cc, err := CheckoutStrategyForOptions(context.TODO(), tt.opts).Checkout(context.TODO(), tmpDir, "https://example.com/repo", nil)
+			g.Expect(cc).To(BeNil())
+
+			var unsupportedErr *git.UnsupportedOptionError
+			g.Expect(errors.As(err, &unsupportedErr)).To(BeTrue())
+			g.Expect(unsupportedErr.Option).To(Equal(tt.wantOption))
+			g.Expect(unsupportedErr.Implementation).To(Equal(Implementation))
+		})
+	}
+}
+
+func Test_ignoredOptionMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		opts git.CheckoutOptions
+		want []string
+	}{
+		{
+			name: "no ignored options",
+			opts: git.CheckoutOptions{Branch: "main", CheckoutMode: git.CheckoutModeForce},
+		},
+		{
+			name: "ignored option with fallback",
+			opts: git.CheckoutOptions{Filter: "blob:none"},
+			want: []string{"git partial clone not supported by implementation 'go-git', falling back to full fetch"},
+		},
+		{
+			name: "ignored option without fallback",
+			opts: git.CheckoutOptions{NotesRef: "refs/notes/commits"},
+			want: []string{"git notes not supported by implementation 'go-git'"},
+		},
+		{
+			name: "multiple ignored options in order",
+			opts: git.CheckoutOptions{
+				ShallowSince: time.Now(),
+				CheckoutMode: git.CheckoutModeSafe,
+				FetchRetries: 3,
+			},
+			want: []string{
+				"git shallow-since fetch not supported by implementation 'go-git', falling back to depth-based fetch",
+				"git fetch retries not supported by implementation 'go-git', falling back to a single attempt",
+				"git checkout mode not supported by implementation 'go-git', falling back to force",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(ignoredOptionMessages(tt.opts)).To(Equal(tt.want))
+		})
+	}
+}
+
 // Test_KeyTypes assures support for the different types of keys
 // for SSH Authentication supported by Flux.
 func Test_KeyTypes(t *testing.T) {
@@ -17,9 +17,17 @@ limitations under the License.
 package gogit
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	nethttp "net/http"
+	"strings"
 
+	extgogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 
@@ -109,3 +117,51 @@ func (a *CustomPublicKeys) ClientConfig() (*gossh.ClientConfig, error) {
 
 	return config, nil
 }
+
+// remoteError returns the given error of cloning the remote at the given URL
+// as a git.RefNotFoundError for ref when the reference was not found, or as a
+// git.AuthenticationError, git.TooManyRequestsError or git.TransportError.
+// Errors of the context being done are returned as is.
+func remoteError(url, ref string, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	case errors.Is(err, extgogit.NoMatchingRefSpecError{}), errors.Is(err, plumbing.ErrReferenceNotFound):
+		return &git.RefNotFoundError{Ref: ref, Err: err}
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod), strings.Contains(err.Error(), "unable to authenticate"):
+		return &git.AuthenticationError{URL: url, Err: err}
+	}
+	var unexpectedErr *plumbing.UnexpectedError
+	if errors.As(err, &unexpectedErr) {
+		if httpErr, ok := unexpectedErr.Err.(*http.Err); ok && httpErr.Response.StatusCode == nethttp.StatusTooManyRequests {
+			return git.NewTooManyRequestsError(url, httpErr.Response.Header.Get("Retry-After"), err)
+		}
+	}
+	return &git.TransportError{URL: url, Err: err}
+}
+
+// rejectsShallow returns if the remote at the given URL does not advertise
+// the shallow capability, which a clone limited by a depth requires. It
+// returns false when the capabilities can not be retrieved.
+func rejectsShallow(ctx context.Context, url string, authMethod transport.AuthMethod, caBundle []byte) bool {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return false
+	}
+	ep.CaBundle = caBundle
+	c, err := client.NewClient(ep)
+	if err != nil {
+		return false
+	}
+	s, err := c.NewUploadPackSession(ep, authMethod)
+	if err != nil {
+		return false
+	}
+	defer s.Close()
+	ar, err := s.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return false
+	}
+	return !ar.Capabilities.Supports(capability.Shallow)
+}
@@ -17,9 +17,16 @@ limitations under the License.
 package gogit
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
 	"testing"
+	"time"
 
+	extgogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	. "github.com/onsi/gomega"
@@ -247,3 +254,74 @@ func Test_caBundle(t *testing.T) {
 	g.Expect(caBundle(&git.AuthOptions{CAFile: []byte("foo")})).To(BeEquivalentTo("foo"))
 	g.Expect(caBundle(nil)).To(BeNil())
 }
+
+func Test_remoteError(t *testing.T) {
+	const repoURL = "https://example.com/repo"
+	reqURL, _ := url.Parse(repoURL + "/info/refs")
+	rateLimited := &nethttp.Response{
+		StatusCode: nethttp.StatusTooManyRequests,
+		Header:     nethttp.Header{"Retry-After": []string{"60"}},
+		Request:    &nethttp.Request{URL: reqURL},
+	}
+
+	tests := []struct {
+		name       string
+		err        error
+		assertFunc func(g *WithT, err error)
+	}{
+		{
+			name: "missing reference",
+			err:  extgogit.NoMatchingRefSpecError{},
+			assertFunc: func(g *WithT, err error) {
+				var refErr *git.RefNotFoundError
+				g.Expect(errors.As(err, &refErr)).To(BeTrue())
+				g.Expect(refErr.Ref).To(Equal("refs/heads/main"))
+			},
+		},
+		{
+			name: "authentication required",
+			err:  transport.ErrAuthenticationRequired,
+			assertFunc: func(g *WithT, err error) {
+				var authErr *git.AuthenticationError
+				g.Expect(errors.As(err, &authErr)).To(BeTrue())
+				g.Expect(authErr.URL).To(Equal(repoURL))
+			},
+		},
+		{
+			name: "rate limited",
+			err:  plumbing.NewUnexpectedError(&http.Err{Response: rateLimited}),
+			assertFunc: func(g *WithT, err error) {
+				var rateErr *git.TooManyRequestsError
+				g.Expect(errors.As(err, &rateErr)).To(BeTrue())
+				g.Expect(rateErr.RetryAfter).To(Equal(time.Minute))
+				var transportErr *git.TransportError
+				g.Expect(errors.As(err, &transportErr)).To(BeTrue())
+			},
+		},
+		{
+			name: "network error",
+			err:  errors.New("dial tcp: connection refused"),
+			assertFunc: func(g *WithT, err error) {
+				var transportErr *git.TransportError
+				g.Expect(errors.As(err, &transportErr)).To(BeTrue())
+			},
+		},
+		{
+			name: "context done",
+			err:  context.DeadlineExceeded,
+			assertFunc: func(g *WithT, err error) {
+				var transportErr *git.TransportError
+				g.Expect(errors.As(err, &transportErr)).To(BeFalse())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := remoteError(repoURL, "refs/heads/main", fmt.Errorf("unable to clone '%s': %w", repoURL, tt.err))
+			tt.assertFunc(g, err)
+			g.Expect(err.Error()).To(HavePrefix("unable to clone"))
+		})
+	}
+}
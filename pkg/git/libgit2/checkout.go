@@ -20,15 +20,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/go-logr/logr"
 	git2go "github.com/libgit2/git2go/v33"
 
-	"github.com/fluxcd/pkg/gitutil"
+	"github.com/fluxcd/pkg/runtime/logger"
 	"github.com/fluxcd/pkg/version"
 
 	"github.com/fluxcd/source-controller/pkg/git"
@@ -40,45 +44,445 @@ const defaultRemoteName = "origin"
 // CheckoutStrategyForOptions returns the git.CheckoutStrategy for the given
 // git.CheckoutOptions.
 func CheckoutStrategyForOptions(ctx context.Context, opt git.CheckoutOptions) git.CheckoutStrategy {
-	if opt.RecurseSubmodules {
-		logr.FromContextOrDiscard(ctx).Info(fmt.Sprintf("git submodule recursion not supported by implementation '%s'", Implementation))
+	if opt.Metrics != nil {
+		recorder := opt.Metrics
+		opt.Metrics = nil
+		return &CheckoutWithMetrics{
+			Strategy: CheckoutStrategyForOptions(ctx, opt),
+			Recorder: recorder,
+		}
+	}
+	if opt.RemoveGitMetadata {
+		opt.RemoveGitMetadata = false
+		return &CheckoutWithoutGitMetadata{
+			Strategy: CheckoutStrategyForOptions(ctx, opt),
+		}
+	}
+	if len(opt.MirrorURLs) > 0 {
+		mirrors := opt.MirrorURLs
+		opt.MirrorURLs = nil
+		return &CheckoutWithMirrors{
+			Strategy:   CheckoutStrategyForOptions(ctx, opt),
+			MirrorURLs: mirrors,
+		}
+	}
+	var warnings []string
+	if !opt.ShallowSince.IsZero() {
+		msg := fmt.Sprintf("git shallow-since fetch not supported by implementation '%s', falling back to full fetch", Implementation)
+		logr.FromContextOrDiscard(ctx).Info(msg)
+		warnings = append(warnings, git.NewWarning(git.WarningShallowFetchUnsupported, msg))
+	}
+	if opt.Depth > 0 {
+		msg := fmt.Sprintf("git depth-limited fetch not supported by implementation '%s', falling back to full fetch", Implementation)
+		logr.FromContextOrDiscard(ctx).Info(msg)
+		warnings = append(warnings, git.NewWarning(git.WarningShallowFetchUnsupported, msg))
+	}
+	if opt.Filter != "" {
+		msg := fmt.Sprintf("git partial clone not supported by implementation '%s', falling back to full fetch", Implementation)
+		logr.FromContextOrDiscard(ctx).Info(msg)
+		warnings = append(warnings, git.NewWarning(git.WarningPartialCloneUnsupported, msg))
+	}
+	co := checkoutOptions{
+		MaxFileSize:         opt.MaxFileSize,
+		NormalizeTimestamps: opt.NormalizeTimestamps,
+		AllowedSignersPath:  opt.AllowedSignersPath,
+		AutoCRLF:            opt.AutoCRLF,
+		DisableFilters:      opt.DisableFilters,
+		IndexPath:           opt.IndexPath,
+		RecurseSubmodules:   opt.RecurseSubmodules,
+		PublicKeyRing:       opt.PublicKeyRing,
+		SSHPublicKeys:       opt.SSHPublicKeys,
+		FetchRetries:        opt.FetchRetries,
+		FetchRetryDelay:     opt.FetchRetryDelay,
+		FetchTimeout:        opt.FetchTimeout,
+		NotesRef:            opt.NotesRef,
+		VerifyWorktree:      opt.VerifyWorktree,
+		CollectStats:        opt.CollectStats,
+		CheckoutMode:        opt.CheckoutMode,
+		SparsePaths:         opt.SparsePaths,
+		ReuseRepository:     opt.ReuseRepository,
+		MaxSize:             opt.MaxSize,
+		ResolveOnly:         opt.ResolveOnly,
+		warnings:            warnings,
+	}
+	if opt.Progress != nil {
+		co.progress = newTransferProgress(opt.Progress)
 	}
 	switch {
+	case opt.Name != "":
+		return &CheckoutRef{Name: opt.Name, checkoutOptions: co}
+	case opt.RollbackCommit != "":
+		branch := opt.Branch
+		if branch == "" {
+			branch = git.DefaultBranch
+		}
+		return &CheckoutRollback{
+			Branch:          branch,
+			Commit:          opt.RollbackCommit,
+			checkoutOptions: co,
+		}
 	case opt.Commit != "":
-		return &CheckoutCommit{Commit: opt.Commit}
+		return &CheckoutCommit{
+			Commit:          opt.Commit,
+			Branch:          opt.Branch,
+			LastRevision:    opt.LastRevision,
+			ResolveBranches: opt.ResolveBranches,
+			checkoutOptions: co,
+		}
 	case opt.SemVer != "":
-		return &CheckoutSemVer{SemVer: opt.SemVer}
+		return &CheckoutSemVer{
+			SemVer:           opt.SemVer,
+			LastRevision:     opt.LastRevision,
+			TagPrefix:        opt.TagPrefix,
+			TagFilter:        opt.TagFilter,
+			IgnorePrerelease: opt.IgnorePrerelease,
+			checkoutOptions:  co,
+		}
+	case opt.LatestTag:
+		return &CheckoutLatestTag{
+			LastRevision:     opt.LastRevision,
+			TagPrefix:        opt.TagPrefix,
+			TagFilter:        opt.TagFilter,
+			IgnorePrerelease: opt.IgnorePrerelease,
+			checkoutOptions:  co,
+		}
 	case opt.Tag != "":
 		return &CheckoutTag{
-			Tag:          opt.Tag,
-			LastRevision: opt.LastRevision,
+			Tag:             opt.Tag,
+			LastRevision:    opt.LastRevision,
+			checkoutOptions: co,
 		}
 	default:
-		branch := opt.Branch
-		if branch == "" {
-			branch = git.DefaultBranch
-		}
 		return &CheckoutBranch{
-			Branch:       branch,
-			LastRevision: opt.LastRevision,
+			Branch:             opt.Branch,
+			LastRevision:       opt.LastRevision,
+			RequireFastForward: opt.RequireFastForward,
+			checkoutOptions:    co,
+		}
+	}
+}
+
+// checkoutOptions holds the options which apply to all checkout strategies,
+// regardless of the reference being checked out.
+type checkoutOptions struct {
+	// MaxFileSize is the maximum size in bytes of any single file in the
+	// tree being checked out. Zero means no limit.
+	MaxFileSize int64
+	// NormalizeTimestamps converts the signature timestamps of the returned
+	// commit to UTC.
+	NormalizeTimestamps bool
+	// AllowedSignersPath is the path of the allowed_signers file in the tree
+	// of the checked out commit to verify its SSH signature against.
+	AllowedSignersPath string
+	// AutoCRLF is the 'core.autocrlf' value to configure in the repository
+	// before writing the working tree. Empty means the host configuration
+	// applies.
+	AutoCRLF string
+	// DisableFilters skips line ending conversion and other filters when
+	// writing the working tree.
+	DisableFilters bool
+	// IndexPath is the path of the file to write the index to, instead of
+	// the repository. Empty means the index of the repository is used.
+	IndexPath string
+	// RecurseSubmodules checks out the submodules of the repository after
+	// writing the working tree, up to maxSubmoduleDepth levels deep.
+	RecurseSubmodules bool
+	// PublicKeyRing is the armored PGP key ring to verify the signature of
+	// the checked out commit against.
+	PublicKeyRing string
+	// SSHPublicKeys are the SSH public keys in the authorized_keys format
+	// to verify the SSH signature of the checked out commit against.
+	SSHPublicKeys string
+	// FetchRetries is the number of times a fetch is retried after a
+	// transient network failure.
+	FetchRetries int
+	// FetchRetryDelay is the delay before the first retry of a fetch, which
+	// is doubled for every next retry.
+	FetchRetryDelay time.Duration
+	// FetchTimeout is the maximum duration of a single fetch, after which
+	// it is aborted. Zero means no timeout.
+	FetchTimeout time.Duration
+	// NotesRef is the git notes reference fetched along with the commit,
+	// to return the note attached to it.
+	NotesRef string
+	// VerifyWorktree compares the working tree to the tree of the commit
+	// after it has been written.
+	VerifyWorktree bool
+	// CollectStats gathers the statistics of the repository when building
+	// the commit.
+	CollectStats bool
+	// CheckoutMode defines how files in the working tree are treated when
+	// it is written.
+	CheckoutMode git.CheckoutMode
+	// SparsePaths limits the files written to the working tree to the given
+	// path prefixes.
+	SparsePaths []string
+	// ReuseRepository fetches incrementally into a repository at the
+	// checkout path which was checked out from the same URL before, instead
+	// of cloning it again.
+	ReuseRepository bool
+	// MaxSize is the maximum number of bytes received while fetching, after
+	// which the fetch is aborted. Zero means no limit.
+	MaxSize int64
+	// ResolveOnly resolves the commit to return without writing the
+	// working tree, the index or HEAD.
+	ResolveOnly bool
+
+	// warnings holds the warnings to record on the returned commit.
+	warnings []string
+	// progress records the transfer progress of fetches, and reports it to
+	// the configured git.ProgressFunc. Nil if no progress is reported.
+	progress *transferProgress
+}
+
+// configureRepository applies the options which affect the way the working
+// tree is written to the configuration of the given repository.
+func (o checkoutOptions) configureRepository(repo *git2go.Repository) error {
+	switch o.AutoCRLF {
+	case "":
+		return nil
+	case "true", "false", "input":
+	default:
+		return fmt.Errorf("invalid autocrlf value '%s', must be one of 'true', 'false' or 'input'", o.AutoCRLF)
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("unable to open repository config: %w", libGit2Error(err))
+	}
+	defer cfg.Free()
+	// The repository configuration takes precedence over the global and
+	// system configuration of the host.
+	if err = cfg.SetString("core.autocrlf", o.AutoCRLF); err != nil {
+		return fmt.Errorf("unable to set core.autocrlf: %w", libGit2Error(err))
+	}
+	return nil
+}
+
+// checkoutStrategyOptions returns the git2go.CheckoutOptions to write the
+// working tree with.
+func (o checkoutOptions) checkoutStrategyOptions() *git2go.CheckoutOptions {
+	var strategy git2go.CheckoutStrategy
+	switch o.CheckoutMode {
+	case git.CheckoutModeSafe:
+		strategy = git2go.CheckoutSafe
+	case git.CheckoutModeRecreateMissing:
+		strategy = git2go.CheckoutSafe | git2go.CheckoutRecreateMissing
+	default:
+		strategy = git2go.CheckoutForce
+	}
+	if o.ReuseRepository {
+		// Ensure files left behind by a previous checkout do not end up in
+		// the working tree.
+		strategy |= git2go.CheckoutRemoveUntracked
+	}
+	if o.IndexPath != "" {
+		// The index is written to IndexPath by writeIndex instead.
+		strategy |= git2go.CheckoutDontUpdateIndex
+	}
+	return &git2go.CheckoutOptions{
+		Strategy:       strategy,
+		DisableFilters: o.DisableFilters,
+	}
+}
+
+// writeWorktree writes the given tree to the working tree of the repository,
+// according to the CheckoutMode. Modified files preventing the checkout are
+// returned as git.ErrCheckoutConflict. HEAD is the baseline modifications are
+// detected against, and must be moved once the tree has been written.
+func (o checkoutOptions) writeWorktree(repo *git2go.Repository, tree *git2go.Tree) error {
+	switch o.CheckoutMode {
+	case "", git.CheckoutModeForce, git.CheckoutModeSafe, git.CheckoutModeRecreateMissing:
+	default:
+		return fmt.Errorf("invalid checkout mode '%s', must be one of '%s', '%s' or '%s'", o.CheckoutMode,
+			git.CheckoutModeForce, git.CheckoutModeSafe, git.CheckoutModeRecreateMissing)
+	}
+	var conflicts []string
+	opts := o.checkoutStrategyOptions()
+	opts.Paths = o.sparsePathspec()
+	if opts.Strategy&git2go.CheckoutSafe != 0 && isEmptyWorktree(repo.Workdir()) {
+		// The files of a fresh clone would appear to be deleted from the
+		// working tree, while there can not be any modifications.
+		opts.Strategy |= git2go.CheckoutRecreateMissing
+	}
+	opts.NotifyFlags |= git2go.CheckoutNotifyConflict
+	opts.NotifyCallback = func(_ git2go.CheckoutNotifyType, path string, _, _, _ git2go.DiffFile) error {
+		conflicts = append(conflicts, path)
+		return nil
+	}
+	if err := repo.CheckoutTree(tree, opts); err != nil {
+		if len(conflicts) > 0 {
+			return fmt.Errorf("%w: %s", git.ErrCheckoutConflict, listPaths(conflicts, len(conflicts)))
+		}
+		return err
+	}
+	return nil
+}
+
+// isEmptyWorktree returns if the working tree at the given path does not
+// contain anything but the .git directory.
+func isEmptyWorktree(path string) bool {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.Name() != ".git" {
+			return false
+		}
+	}
+	return true
+}
+
+// prepareIndex ensures the directory of IndexPath is writable, and returns a
+// function to remove the index written to it once the checkout completes.
+func (o checkoutOptions) prepareIndex() (func(), error) {
+	if o.IndexPath == "" {
+		return func() {}, nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(o.IndexPath), ".index-")
+	if err != nil {
+		return nil, fmt.Errorf("index path '%s' is not writable: %w", o.IndexPath, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return func() {
+		os.Remove(o.IndexPath)
+	}, nil
+}
+
+// writeIndex writes the index for the given tree to IndexPath, if set.
+func (o checkoutOptions) writeIndex(tree *git2go.Tree) error {
+	if o.IndexPath == "" {
+		return nil
+	}
+	index, err := git2go.OpenIndex(o.IndexPath)
+	if err != nil {
+		return fmt.Errorf("unable to open index '%s': %w", o.IndexPath, libGit2Error(err))
+	}
+	defer index.Free()
+	if err = index.ReadTree(tree); err != nil {
+		return fmt.Errorf("unable to read tree into index '%s': %w", o.IndexPath, libGit2Error(err))
+	}
+	if err = index.Write(); err != nil {
+		return fmt.Errorf("unable to write index '%s': %w", o.IndexPath, libGit2Error(err))
+	}
+	return nil
+}
+
+// validateTree ensures the given tree satisfies the configured limits before
+// it is written to the working directory.
+func (o checkoutOptions) validateTree(repo *git2go.Repository, tree *git2go.Tree) error {
+	if o.MaxFileSize <= 0 {
+		return nil
+	}
+	odb, err := repo.Odb()
+	if err != nil {
+		return fmt.Errorf("unable to open object database: %w", libGit2Error(err))
+	}
+	defer odb.Free()
+	return tree.Walk(func(dir string, entry *git2go.TreeEntry) error {
+		if entry.Type != git2go.ObjectBlob {
+			return nil
+		}
+		size, _, err := odb.ReadHeader(entry.Id)
+		if err != nil {
+			return fmt.Errorf("unable to read object header for '%s': %w", entry.Id.String(), libGit2Error(err))
+		}
+		if int64(size) > o.MaxFileSize {
+			return fmt.Errorf("%w: '%s' is %d bytes, limit is %d bytes",
+				git.ErrFileTooLarge, path.Join(dir, entry.Name), size, o.MaxFileSize)
+		}
+		return nil
+	})
+}
+
+// maxListedPaths is the maximum number of paths listed in an error.
+const maxListedPaths = 10
+
+// listPaths joins the given paths, out of a total number of paths, for
+// inclusion in an error. At most maxListedPaths paths are listed.
+func listPaths(paths []string, total int) string {
+	if len(paths) > maxListedPaths {
+		paths = paths[:maxListedPaths]
+	}
+	list := strings.Join(paths, ", ")
+	if total > len(paths) {
+		list += fmt.Sprintf(" and %d more", total-len(paths))
+	}
+	return list
+}
+
+// verifyWorktree ensures the working tree matches the given tree, if
+// VerifyWorktree is set. Untracked files, submodules and files outside the
+// SparsePaths are not considered.
+func (o checkoutOptions) verifyWorktree(repo *git2go.Repository, tree *git2go.Tree) error {
+	if !o.VerifyWorktree {
+		return nil
+	}
+	diffOpts, err := git2go.DefaultDiffOptions()
+	if err != nil {
+		return fmt.Errorf("unable to initialize diff options: %w", libGit2Error(err))
+	}
+	diffOpts.Flags |= git2go.DiffIgnoreSubmodules
+	diffOpts.Pathspec = o.sparsePathspec()
+	diff, err := repo.DiffTreeToWorkdir(tree, &diffOpts)
+	if err != nil {
+		return fmt.Errorf("unable to diff tree '%s' to working tree: %w", tree.Id().String(), libGit2Error(err))
+	}
+	defer diff.Free()
+	n, err := diff.NumDeltas()
+	if err != nil {
+		return fmt.Errorf("unable to count differences to working tree: %w", libGit2Error(err))
+	}
+	if n == 0 {
+		return nil
+	}
+	var paths []string
+	for i := 0; i < n && i < maxListedPaths; i++ {
+		delta, err := diff.Delta(i)
+		if err != nil {
+			return fmt.Errorf("unable to read difference to working tree: %w", libGit2Error(err))
 		}
+		paths = append(paths, delta.OldFile.Path)
 	}
+	return fmt.Errorf("%w: %s", git.ErrWorktreeMismatch, listPaths(paths, n))
 }
 
 type CheckoutBranch struct {
+	// Branch is the name of the branch to check out. When empty, the branch
+	// the remote HEAD points to is checked out, or git.DefaultBranch if the
+	// remote does not advertise it. A 'refs/heads/' prefix is trimmed.
 	Branch       string
 	LastRevision string
+	// RequireFastForward rejects the checkout with git.ErrNonFastForward
+	// when the commit of the Branch is not a descendant of the commit of
+	// the LastRevision.
+	RequireFastForward bool
+
+	checkoutOptions
 }
 
 func (c *CheckoutBranch) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (_ *git.Commit, err error) {
+	defer cleanupOnError(path)(&err)
 	defer recoverPanic(&err)
 
-	err = registerManagedTransportOptions(ctx, url, opts)
+	cleanupIndex, err := c.prepareIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupIndex()
+
+	release, err := acquireHostSlot(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	opts, err = registerManagedTransportOptions(ctx, url, withCredentialsCache(opts))
 	if err != nil {
 		return nil, err
 	}
 	transportOptsURL := opts.TransportOptionsURL
-	remoteCallBacks := managed.RemoteCallbacks()
 	defer managed.RemoveTransportOptions(transportOptsURL)
 
 	repo, remote, err := initializeRepoWithRemote(ctx, path, url, opts)
@@ -86,11 +490,14 @@ func (c *CheckoutBranch) Checkout(ctx context.Context, path, url string, opts *g
 		return nil, err
 	}
 	// Open remote connection.
-	err = remote.ConnectFetch(&remoteCallBacks, nil, nil)
+	err = c.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		callbacks := c.fetchCallbacks(ctx)
+		return libGit2Error(remote.ConnectFetch(&callbacks, nil, nil))
+	})
 	if err != nil {
 		remote.Free()
 		repo.Free()
-		return nil, fmt.Errorf("unable to fetch-connect to remote '%s': %w", url, gitutil.LibGit2Error(err))
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch-connect to remote '%s': %w", url, err))
 	}
 	defer func() {
 		remote.Disconnect()
@@ -98,82 +505,111 @@ func (c *CheckoutBranch) Checkout(ctx context.Context, path, url string, opts *g
 		repo.Free()
 	}()
 
+	// Without a Branch, check out the branch the remote HEAD points to.
+	branchName := strings.TrimPrefix(c.Branch, "refs/heads/")
+	if branchName == "" {
+		branchName = remoteDefaultBranch(remote)
+	}
+	if ok, err := git2go.ReferenceNameIsValid("refs/heads/" + branchName); err != nil || !ok {
+		return nil, fmt.Errorf("invalid branch name '%s'", branchName)
+	}
+
 	// When the last observed revision is set, check whether it is still the
 	// same at the remote branch. If so, short-circuit the clone operation here.
 	if c.LastRevision != "" {
-		heads, err := remote.Ls(c.Branch)
+		hash, err := lsRemoteBranch(remote, branchName)
 		if err != nil {
-			return nil, fmt.Errorf("unable to remote ls for '%s': %w", url, gitutil.LibGit2Error(err))
+			return nil, contextError(ctx, url, fmt.Errorf("unable to remote ls for '%s': %w", url, err))
 		}
-		if len(heads) > 0 {
-			hash := heads[0].Id.String()
-			currentRevision := fmt.Sprintf("%s/%s", c.Branch, hash)
+		if hash != "" {
+			currentRevision := fmt.Sprintf("%s/%s", branchName, hash)
 			if currentRevision == c.LastRevision {
 				// Construct a partial commit with the existing information.
-				c := &git.Commit{
-					Hash:      git.Hash(hash),
-					Reference: "refs/heads/" + c.Branch,
-				}
-				return c, nil
+				return c.partialCommit(hash, "refs/heads/"+branchName), nil
 			}
 		}
 	}
 
 	// Limit the fetch operation to the specific branch, to decrease network usage.
-	err = remote.Fetch([]string{c.Branch},
-		&git2go.FetchOptions{
+	err = c.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		return fetchOrDisconnect(ctx, remote, c.fetchRefspecs(branchName), &git2go.FetchOptions{
 			DownloadTags:    git2go.DownloadTagsNone,
-			RemoteCallbacks: remoteCallBacks,
-		},
-		"")
+			RemoteCallbacks: c.fetchCallbacks(ctx),
+		})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to fetch remote '%s': %w", url, gitutil.LibGit2Error(err))
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch remote '%s': %w", url, err))
 	}
 
-	branch, err := repo.References.Lookup(fmt.Sprintf("refs/remotes/origin/%s", c.Branch))
+	branch, err := resolveRemoteBranch(repo, branchName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to lookup branch '%s' for '%s': %w", c.Branch, url, gitutil.LibGit2Error(err))
+		return nil, refNotFoundError("refs/heads/"+branchName,
+			fmt.Errorf("unable to lookup branch '%s' for '%s': %w", branchName, url, libGit2Error(err)))
 	}
 	defer branch.Free()
 
 	upstreamCommit, err := repo.LookupCommit(branch.Target())
 	if err != nil {
-		return nil, fmt.Errorf("unable to lookup commit '%s' for '%s': %w", c.Branch, url, gitutil.LibGit2Error(err))
+		return nil, fmt.Errorf("unable to lookup commit '%s' for '%s': %w", branchName, url, libGit2Error(err))
 	}
 	defer upstreamCommit.Free()
 
+	if c.RequireFastForward {
+		if err = verifyFastForward(repo, branchName, c.LastRevision, upstreamCommit.Id()); err != nil {
+			return nil, err
+		}
+	}
+	if c.ResolveOnly {
+		return c.buildCommit(repo, upstreamCommit, "refs/heads/"+branchName)
+	}
+
 	// We try to lookup the branch (and create it if it doesn't exist), so that we can
 	// switch the repo to the specified branch. This is done so that users of this api
 	// can expect the repo to be at the desired branch, when cloned.
-	localBranch, err := repo.LookupBranch(c.Branch, git2go.BranchLocal)
+	localBranch, err := repo.LookupBranch(branchName, git2go.BranchLocal)
 	if git2go.IsErrorCode(err, git2go.ErrorCodeNotFound) {
-		localBranch, err = repo.CreateBranch(c.Branch, upstreamCommit, false)
+		localBranch, err = repo.CreateBranch(branchName, upstreamCommit, false)
 		if err != nil {
-			return nil, fmt.Errorf("unable to create local branch '%s': %w", c.Branch, err)
+			return nil, fmt.Errorf("unable to create local branch '%s': %w", branchName, err)
 		}
 	} else if err != nil {
-		return nil, fmt.Errorf("unable to lookup branch '%s': %w", c.Branch, err)
+		return nil, fmt.Errorf("unable to lookup branch '%s': %w", branchName, err)
 	}
 	defer localBranch.Free()
 
 	tree, err := repo.LookupTree(upstreamCommit.TreeId())
 	if err != nil {
-		return nil, fmt.Errorf("unable to lookup tree for branch '%s': %w", c.Branch, err)
+		return nil, fmt.Errorf("unable to lookup tree for branch '%s': %w", branchName, err)
 	}
 	defer tree.Free()
 
-	err = repo.CheckoutTree(tree, &git2go.CheckoutOpts{
-		// the remote branch should take precedence if it exists at this point in time.
-		Strategy: git2go.CheckoutForce,
-	})
+	if err = c.validateTree(repo, tree); err != nil {
+		return nil, err
+	}
+	if err = c.configureRepository(repo); err != nil {
+		return nil, err
+	}
+
+	// The forced checkout makes the remote branch take precedence if it
+	// exists at this point in time.
+	err = c.writeWorktree(repo, tree)
 	if err != nil {
-		return nil, fmt.Errorf("unable to checkout tree for branch '%s': %w", c.Branch, err)
+		return nil, fmt.Errorf("unable to checkout tree for branch '%s': %w", branchName, err)
+	}
+	if err = c.verifyWorktree(repo, tree); err != nil {
+		return nil, err
+	}
+	if err = c.writeIndex(tree); err != nil {
+		return nil, err
+	}
+	if err = c.updateSubmodules(ctx, repo, url, opts); err != nil {
+		return nil, err
 	}
 
 	// Set the current head to point to the requested branch.
-	err = repo.SetHead("refs/heads/" + c.Branch)
+	err = repo.SetHead("refs/heads/" + branchName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to set HEAD to branch '%s':%w", c.Branch, err)
+		return nil, fmt.Errorf("unable to set HEAD to branch '%s':%w", branchName, err)
 	}
 
 	// Use the current worktree's head as reference for the commit to be returned.
@@ -185,27 +621,70 @@ func (c *CheckoutBranch) Checkout(ctx context.Context, path, url string, opts *g
 
 	cc, err := repo.LookupCommit(head.Target())
 	if err != nil {
-		return nil, fmt.Errorf("unable to lookup HEAD commit '%s' for branch '%s': %w", head.Target(), c.Branch, err)
+		return nil, fmt.Errorf("unable to lookup HEAD commit '%s' for branch '%s': %w", head.Target(), branchName, err)
 	}
 	defer cc.Free()
 
-	return buildCommit(cc, "refs/heads/"+c.Branch), nil
+	return c.buildCommit(repo, cc, "refs/heads/"+branchName)
+}
+
+// verifyFastForward returns git.ErrNonFastForward when the given commit is
+// not a descendant of the commit of the last observed revision of the branch.
+// It returns nil when no revision of the branch was observed before.
+func verifyFastForward(repo *git2go.Repository, branch, lastRevision string, commit *git2go.Oid) error {
+	prefix := branch + "/"
+	if !strings.HasPrefix(lastRevision, prefix) {
+		return nil
+	}
+	last, err := git2go.NewOid(strings.TrimPrefix(lastRevision, prefix))
+	if err != nil {
+		return fmt.Errorf("could not create oid for last revision '%s': %w", lastRevision, err)
+	}
+	if commit.Equal(last) {
+		return nil
+	}
+	// The last observed commit is not fetched when the history of the branch
+	// no longer contains it.
+	descendant, err := repo.DescendantOf(commit, last)
+	if err != nil && !git2go.IsErrorCode(err, git2go.ErrorCodeNotFound) {
+		return fmt.Errorf("unable to verify commit '%s' descends from last revision '%s': %w",
+			commit, lastRevision, libGit2Error(err))
+	}
+	if !descendant {
+		return fmt.Errorf("%w: commit '%s' of branch '%s' is not a descendant of last revision '%s'",
+			git.ErrNonFastForward, commit, branch, lastRevision)
+	}
+	return nil
 }
 
 type CheckoutTag struct {
 	Tag          string
 	LastRevision string
+
+	checkoutOptions
 }
 
 func (c *CheckoutTag) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (_ *git.Commit, err error) {
+	defer cleanupOnError(path)(&err)
 	defer recoverPanic(&err)
 
-	err = registerManagedTransportOptions(ctx, url, opts)
+	cleanupIndex, err := c.prepareIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupIndex()
+
+	release, err := acquireHostSlot(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	opts, err = registerManagedTransportOptions(ctx, url, withCredentialsCache(opts))
 	if err != nil {
 		return nil, err
 	}
 	transportOptsURL := opts.TransportOptionsURL
-	remoteCallBacks := managed.RemoteCallbacks()
 	defer managed.RemoveTransportOptions(transportOptsURL)
 
 	repo, remote, err := initializeRepoWithRemote(ctx, path, url, opts)
@@ -213,11 +692,14 @@ func (c *CheckoutTag) Checkout(ctx context.Context, path, url string, opts *git.
 		return nil, err
 	}
 	// Open remote connection.
-	err = remote.ConnectFetch(&remoteCallBacks, nil, nil)
+	err = c.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		callbacks := c.fetchCallbacks(ctx)
+		return libGit2Error(remote.ConnectFetch(&callbacks, nil, nil))
+	})
 	if err != nil {
 		remote.Free()
 		repo.Free()
-		return nil, fmt.Errorf("unable to fetch-connect to remote '%s': %w", url, gitutil.LibGit2Error(err))
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch-connect to remote '%s': %w", url, err))
 	}
 	defer func() {
 		remote.Disconnect()
@@ -230,7 +712,7 @@ func (c *CheckoutTag) Checkout(ctx context.Context, path, url string, opts *git.
 	if c.LastRevision != "" {
 		heads, err := remote.Ls(c.Tag)
 		if err != nil {
-			return nil, fmt.Errorf("unable to remote ls for '%s': %w", url, gitutil.LibGit2Error(err))
+			return nil, contextError(ctx, url, fmt.Errorf("unable to remote ls for '%s': %w", url, libGit2Error(err)))
 		}
 		if len(heads) > 0 {
 			hash := heads[0].Id.String()
@@ -247,216 +729,969 @@ func (c *CheckoutTag) Checkout(ctx context.Context, path, url string, opts *git.
 			}
 			if same {
 				// Construct a partial commit with the existing information.
-				c := &git.Commit{
-					Hash:      git.Hash(hash),
-					Reference: "refs/tags/" + c.Tag,
-				}
-				return c, nil
+				return c.partialCommit(hash, "refs/tags/"+c.Tag), nil
 			}
 		}
 	}
 
-	err = remote.Fetch([]string{c.Tag},
-		&git2go.FetchOptions{
+	err = c.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		return fetchOrDisconnect(ctx, remote, c.fetchRefspecs(c.Tag), &git2go.FetchOptions{
 			DownloadTags:    git2go.DownloadTagsAuto,
-			RemoteCallbacks: remoteCallBacks,
-		},
-		"")
-
+			RemoteCallbacks: c.fetchCallbacks(ctx),
+		})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to fetch remote '%s': %w", url, gitutil.LibGit2Error(err))
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch remote '%s': %w", url, err))
 	}
 
-	cc, err := checkoutDetachedDwim(repo, c.Tag)
+	cc, err := c.checkoutDetachedDwim(repo, c.Tag)
 	if err != nil {
-		return nil, err
+		return nil, refNotFoundError("refs/tags/"+c.Tag, err)
 	}
 	defer cc.Free()
-	return buildCommit(cc, "refs/tags/"+c.Tag), nil
+	if err = c.updateSubmodules(ctx, repo, url, opts); err != nil {
+		return nil, err
+	}
+	return c.buildTagCommit(repo, cc, "refs/tags/"+c.Tag)
 }
 
+// CheckoutCommit checks out the given commit in detached HEAD mode. When a
+// Branch is given, only that branch is fetched from the remote. Otherwise,
+// only the commit is fetched when the server allows requests for
+// unadvertised objects, with a fallback to cloning the repository.
 type CheckoutCommit struct {
-	Commit string
+	Commit       string
+	Branch       string
+	LastRevision string
+	// ResolveBranches resolves the branches containing the Commit when no
+	// Branch is set, for which all branches are fetched.
+	ResolveBranches bool
+
+	checkoutOptions
 }
 
 func (c *CheckoutCommit) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (_ *git.Commit, err error) {
+	defer cleanupOnError(path)(&err)
 	defer recoverPanic(&err)
 
-	err = registerManagedTransportOptions(ctx, url, opts)
-	if err != nil {
-		return nil, err
+	// The Branch is only a hint to fetch the commit from, and does not set
+	// the reference of the returned commit.
+	var ref string
+
+	// A commit can not change, when the last observed revision is the same
+	// commit, short-circuit the clone operation here without contacting the
+	// remote.
+	if c.LastRevision != "" {
+		// Construct a partial commit with the existing information.
+		cc := c.partialCommit(c.Commit, ref)
+		if c.LastRevision == c.Commit || c.LastRevision == cc.String() {
+			return cc, nil
+		}
 	}
-	transportOptsURL := opts.TransportOptionsURL
-	defer managed.RemoveTransportOptions(transportOptsURL)
 
-	repo, err := git2go.Clone(transportOptsURL, path, &git2go.CloneOptions{
-		FetchOptions: git2go.FetchOptions{
-			DownloadTags:    git2go.DownloadTagsNone,
-			RemoteCallbacks: managed.RemoteCallbacks(),
-		},
-	})
+	cleanupIndex, err := c.prepareIndex()
 	if err != nil {
-		return nil, fmt.Errorf("unable to clone '%s': %w", url, gitutil.LibGit2Error(err))
+		return nil, err
 	}
-	defer repo.Free()
+	defer cleanupIndex()
+
 	oid, err := git2go.NewOid(c.Commit)
 	if err != nil {
 		return nil, fmt.Errorf("could not create oid for '%s': %w", c.Commit, err)
 	}
-	cc, err := checkoutDetachedHEAD(repo, oid)
+
+	release, err := acquireHostSlot(ctx, url)
 	if err != nil {
-		return nil, fmt.Errorf("git checkout error: %w", err)
+		return nil, err
 	}
-	return buildCommit(cc, ""), nil
-}
+	defer release()
 
-type CheckoutSemVer struct {
-	SemVer string
-}
-
-func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (_ *git.Commit, err error) {
-	defer recoverPanic(&err)
-
-	err = registerManagedTransportOptions(ctx, url, opts)
+	opts, err = registerManagedTransportOptions(ctx, url, withCredentialsCache(opts))
 	if err != nil {
 		return nil, err
 	}
 	transportOptsURL := opts.TransportOptionsURL
 	defer managed.RemoveTransportOptions(transportOptsURL)
 
-	verConstraint, err := semver.NewConstraint(c.SemVer)
-	if err != nil {
-		return nil, fmt.Errorf("semver parse error: %w", err)
-	}
-
-	repo, err := git2go.Clone(transportOptsURL, path, &git2go.CloneOptions{
-		FetchOptions: git2go.FetchOptions{
-			DownloadTags:    git2go.DownloadTagsAll,
-			RemoteCallbacks: managed.RemoteCallbacks(),
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("unable to clone '%s': %w", url, gitutil.LibGit2Error(err))
-	}
-	defer repo.Free()
-
-	tags := make(map[string]string)
-	tagTimestamps := make(map[string]time.Time)
-	if err := repo.Tags.Foreach(func(name string, id *git2go.Oid) error {
-		cleanName := strings.TrimPrefix(name, "refs/tags/")
-		// The given ID can refer to both a commit and a tag, as annotated tags contain additional metadata.
-		// Due to this, first attempt to resolve it as a simple tag (commit), but fallback to attempting to
-		// resolve it as an annotated tag in case this results in an error.
-		if c, err := repo.LookupCommit(id); err == nil {
-			defer c.Free()
-			// Use the commit metadata as the decisive timestamp.
-			tagTimestamps[cleanName] = c.Committer().When
-			tags[cleanName] = name
-			return nil
-		}
-		t, err := repo.LookupTag(id)
+	var repo *git2go.Repository
+	if c.Branch != "" {
+		repo, err = c.fetchBranch(ctx, path, url, opts)
 		if err != nil {
-			return fmt.Errorf("could not lookup '%s' as simple or annotated tag: %w", cleanName, err)
+			return nil, err
 		}
-		defer t.Free()
-		commit, err := t.Peel(git2go.ObjectCommit)
+		defer repo.Free()
+		fetched, err := repo.LookupCommit(oid)
 		if err != nil {
-			return fmt.Errorf("could not get commit for tag '%s': %w", t.Name(), err)
+			return nil, refNotFoundError(c.Commit, fmt.Errorf("git commit '%s' is unreachable from branch '%s' at '%s': %w",
+				c.Commit, c.Branch, url, libGit2Error(err)))
 		}
-		defer commit.Free()
-		c, err := commit.AsCommit()
+		fetched.Free()
+	} else {
+		repo, err = c.reuseRepository(ctx, path, url, opts, git2go.DownloadTagsNone)
 		if err != nil {
-			return fmt.Errorf("could not get commit object for tag '%s': %w", t.Name(), err)
+			return nil, err
 		}
-		defer c.Free()
-		tagTimestamps[t.Name()] = c.Committer().When
-		tags[t.Name()] = name
-		return nil
-	}); err != nil {
-		return nil, err
-	}
-
-	var matchedVersions semver.Collection
-	for tag := range tags {
-		v, err := version.ParseVersion(tag)
-		if err != nil {
-			continue
+		if repo == nil && c.ResolveBranches {
+			repo, err = c.clone(ctx, path, url, transportOptsURL, git2go.DownloadTagsNone)
+			if err != nil {
+				return nil, err
+			}
 		}
-		if !verConstraint.Check(v) {
-			continue
+		if repo == nil {
+			repo, err = fetchOrClone(ctx, path, func() (*git2go.Repository, error) {
+				return c.fetchCommit(ctx, path, url, opts, oid)
+			}, func() (*git2go.Repository, error) {
+				return c.clone(ctx, path, url, transportOptsURL, git2go.DownloadTagsNone)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		defer repo.Free()
+		if err = c.recordTargetURL(repo, url); err != nil {
+			return nil, err
 		}
-		matchedVersions = append(matchedVersions, v)
-	}
-	if len(matchedVersions) == 0 {
-		return nil, fmt.Errorf("no match found for semver: %s", c.SemVer)
 	}
 
-	// Sort versions
-	sort.SliceStable(matchedVersions, func(i, j int) bool {
-		left := matchedVersions[i]
-		right := matchedVersions[j]
+	cc, err := c.checkoutDetachedHEAD(repo, oid)
+	if err != nil {
+		return nil, refNotFoundError(c.Commit, fmt.Errorf("git checkout error: %w", err))
+	}
+	defer cc.Free()
+	if err = c.updateSubmodules(ctx, repo, url, opts); err != nil {
+		return nil, err
+	}
 
-		if !left.Equal(right) {
-			return left.LessThan(right)
+	var branches []string
+	if c.Branch == "" && c.ResolveBranches {
+		if branches, err = branchesContaining(repo, oid); err != nil {
+			return nil, err
 		}
-
-		// Having tag target timestamps at our disposal, we further try to sort
-		// versions into a chronological order. This is especially important for
-		// versions that differ only by build metadata, because it is not considered
-		// a part of the comparable version in Semver
-		return tagTimestamps[left.Original()].Before(tagTimestamps[right.Original()])
-	})
-	v := matchedVersions[len(matchedVersions)-1]
-	t := v.Original()
-
-	cc, err := checkoutDetachedDwim(repo, t)
+		if len(branches) == 1 {
+			ref = branches[0]
+		}
+	}
+	commit, err := c.buildCommit(repo, cc, ref)
 	if err != nil {
 		return nil, err
 	}
-	defer cc.Free()
-	return buildCommit(cc, "refs/tags/"+t), nil
+	commit.Branches = branches
+	return commit, nil
 }
 
-// checkoutDetachedDwim attempts to perform a detached HEAD checkout by first DWIMing the short name
-// to get a concrete reference, and then calling checkoutDetachedHEAD.
-func checkoutDetachedDwim(repo *git2go.Repository, name string) (*git2go.Commit, error) {
-	ref, err := repo.References.Dwim(name)
+// branchesContaining returns the full names of the branches of the remote
+// which have the given commit as their tip or one of its ancestors, in
+// lexical order.
+func branchesContaining(repo *git2go.Repository, oid *git2go.Oid) ([]string, error) {
+	iter, err := repo.NewBranchIterator(git2go.BranchRemote)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list branches: %w", libGit2Error(err))
+	}
+	defer iter.Free()
+
+	var branches []string
+	err = iter.ForEach(func(b *git2go.Branch, _ git2go.BranchType) error {
+		// The symbolic HEAD of the remote points to one of its branches.
+		if b.Type() != git2go.ReferenceOid {
+			return nil
+		}
+		if !b.Target().Equal(oid) {
+			ok, err := repo.DescendantOf(b.Target(), oid)
+			if err != nil {
+				return fmt.Errorf("unable to determine if commit '%s' is an ancestor of '%s': %w", oid, b.Reference.Name(), libGit2Error(err))
+			}
+			if !ok {
+				return nil
+			}
+		}
+		name := strings.TrimPrefix(b.Reference.Name(), "refs/remotes/"+defaultRemoteName+"/")
+		branches = append(branches, "refs/heads/"+name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// fetchBranch initializes a repository at the given path, and fetches only
+// the Branch from the remote into it.
+func (c *CheckoutCommit) fetchBranch(ctx context.Context, path, url string, opts *git.AuthOptions) (*git2go.Repository, error) {
+	repo, remote, err := initializeRepoWithRemote(ctx, path, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer remote.Free()
+
+	// Limit the fetch operation to the specific branch, to decrease network usage.
+	err = c.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		return fetchOrDisconnect(ctx, remote, c.fetchRefspecs(c.Branch), &git2go.FetchOptions{
+			DownloadTags:    git2go.DownloadTagsNone,
+			RemoteCallbacks: c.fetchCallbacks(ctx),
+		})
+	})
+	if err != nil {
+		repo.Free()
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch branch '%s' from '%s': %w", c.Branch, url, err))
+	}
+	return repo, nil
+}
+
+// clone clones the remote into the given path, without writing the working
+// tree, which is written by the detached HEAD checkout once the repository
+// has been configured.
+func (o checkoutOptions) clone(ctx context.Context, path, url, transportOptsURL string, downloadTags git2go.DownloadTags) (*git2go.Repository, error) {
+	var repo *git2go.Repository
+	err := o.withFetchRetries(ctx, url, func(ctx context.Context) (cErr error) {
+		// The transport is created by the clone, and is not reused by
+		// the next attempt.
+		defer managed.SetTransportOptionsContext(ctx, transportOptsURL)()
+		repo, cErr = git2go.Clone(transportOptsURL, path, &git2go.CloneOptions{
+			CheckoutOptions: git2go.CheckoutOptions{Strategy: git2go.CheckoutNone},
+			FetchOptions: git2go.FetchOptions{
+				DownloadTags:    downloadTags,
+				RemoteCallbacks: o.fetchCallbacks(ctx),
+			},
+			RemoteCreateCallback: o.createRemote,
+		})
+		return libGit2Error(cErr)
+	})
+	if err != nil {
+		return nil, contextError(ctx, url, fmt.Errorf("unable to clone '%s': %w", url, err))
+	}
+	return repo, nil
+}
+
+// fetchCommit initializes a repository at the given path, and fetches only
+// the commit with the given ID from the remote into it. This requires the
+// server to allow requests for unadvertised objects, for example with
+// 'uploadpack.allowReachableSHA1InWant'.
+func (c *CheckoutCommit) fetchCommit(ctx context.Context, path, url string, opts *git.AuthOptions, oid *git2go.Oid) (*git2go.Repository, error) {
+	repo, remote, err := initializeRepoWithRemote(ctx, path, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer remote.Free()
+
+	err = c.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		return fetchOrDisconnect(ctx, remote, c.fetchRefspecs(oid.String()), &git2go.FetchOptions{
+			DownloadTags:    git2go.DownloadTagsNone,
+			RemoteCallbacks: c.fetchCallbacks(ctx),
+		})
+	})
+	if err != nil {
+		repo.Free()
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch commit '%s' from '%s': %w", oid, url, err))
+	}
+	// Versions of libgit2 which only negotiate advertised references do not
+	// request the commit at all, instead of failing the fetch.
+	fetched, err := repo.LookupCommit(oid)
+	if err != nil {
+		repo.Free()
+		return nil, fmt.Errorf("unable to fetch commit '%s' from '%s': %w", oid, url, errUnadvertisedObject)
+	}
+	fetched.Free()
+	return repo, nil
+}
+
+// errUnadvertisedObject is returned by fetchCommit when the commit was not
+// fetched, as the server does not allow requests for unadvertised objects.
+var errUnadvertisedObject = errors.New("server does not allow request for unadvertised object")
+
+// unadvertisedObjectMessages are the messages of errors servers respond
+// with when they reject a request for an unadvertised object.
+var unadvertisedObjectMessages = []string{
+	errUnadvertisedObject.Error(),
+	"not our ref",
+}
+
+// fetchOrClone returns the repository fetched by fetch, or the repository
+// cloned by clone when the server rejects fetching an unadvertised object.
+// Anything fetch left behind at the given path is removed before cloning.
+func fetchOrClone(ctx context.Context, path string, fetch, clone func() (*git2go.Repository, error)) (*git2go.Repository, error) {
+	repo, err := fetch()
+	if err == nil {
+		return repo, nil
+	}
+	if ctx.Err() != nil || !isUnadvertisedObjectError(err) {
+		return nil, err
+	}
+	logr.FromContextOrDiscard(ctx).V(logger.DebugLevel).Info("falling back to full clone", "reason", err.Error())
+	if err := git.RemoveDirContents(path); err != nil {
+		return nil, fmt.Errorf("failed to clean up checkout path before clone: %w", err)
+	}
+	return clone()
+}
+
+// isUnadvertisedObjectError returns if the given error is caused by the
+// server rejecting a request for an unadvertised object.
+func isUnadvertisedObjectError(err error) bool {
+	if errors.Is(err, errUnadvertisedObject) {
+		return true
+	}
+	for _, msg := range unadvertisedObjectMessages {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckoutRef checks out the commit a fully qualified reference points to,
+// for example 'refs/pull/1/head', in detached HEAD mode.
+type CheckoutRef struct {
+	Name string
+
+	checkoutOptions
+}
+
+func (c *CheckoutRef) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (_ *git.Commit, err error) {
+	defer cleanupOnError(path)(&err)
+	defer recoverPanic(&err)
+
+	if !strings.HasPrefix(c.Name, "refs/") {
+		return nil, fmt.Errorf("reference '%s' is not fully qualified, it must start with 'refs/'", c.Name)
+	}
+
+	cleanupIndex, err := c.prepareIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupIndex()
+
+	release, err := acquireHostSlot(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	opts, err = registerManagedTransportOptions(ctx, url, withCredentialsCache(opts))
+	if err != nil {
+		return nil, err
+	}
+	transportOptsURL := opts.TransportOptionsURL
+	defer managed.RemoveTransportOptions(transportOptsURL)
+
+	repo, remote, err := initializeRepoWithRemote(ctx, path, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Open remote connection.
+	err = c.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		callbacks := c.fetchCallbacks(ctx)
+		return libGit2Error(remote.ConnectFetch(&callbacks, nil, nil))
+	})
+	if err != nil {
+		remote.Free()
+		repo.Free()
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch-connect to remote '%s': %w", url, err))
+	}
+	defer func() {
+		remote.Disconnect()
+		remote.Free()
+		repo.Free()
+	}()
+
+	exists, hash, err := lsRemoteRef(remote, c.Name)
+	if err != nil {
+		return nil, contextError(ctx, url, fmt.Errorf("unable to remote ls for '%s': %w", url, err))
+	}
+	if !exists {
+		return nil, &git.RefNotFoundError{Ref: c.Name, Err: fmt.Errorf("reference '%s' not found at remote '%s'", c.Name, url)}
+	}
+
+	// Fetch the exact reference, without updating any local references.
+	err = c.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		return fetchOrDisconnect(ctx, remote, c.fetchRefspecs(c.Name), &git2go.FetchOptions{
+			DownloadTags:    git2go.DownloadTagsNone,
+			RemoteCallbacks: c.fetchCallbacks(ctx),
+		})
+	})
+	if err != nil {
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch reference '%s' from '%s': %w", c.Name, url, err))
+	}
+
+	oid, err := git2go.NewOid(hash)
+	if err != nil {
+		return nil, fmt.Errorf("could not create oid for '%s': %w", hash, err)
+	}
+	cc, err := c.checkoutDetachedHEAD(repo, oid)
+	if err != nil {
+		return nil, fmt.Errorf("unable to checkout reference '%s': %w", c.Name, err)
+	}
+	defer cc.Free()
+	if err = c.updateSubmodules(ctx, repo, url, opts); err != nil {
+		return nil, err
+	}
+	return c.buildCommit(repo, cc, c.Name)
+}
+
+// CheckoutRollback checks out a previously observed commit of a branch, for
+// example one recorded by an external ledger to roll back to. Unlike
+// CheckoutCommit, it verifies the commit is still part of the history of the
+// branch at the remote before checking it out.
+type CheckoutRollback struct {
+	Branch string
+	Commit string
+
+	checkoutOptions
+}
+
+func (c *CheckoutRollback) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (_ *git.Commit, err error) {
+	defer cleanupOnError(path)(&err)
+	defer recoverPanic(&err)
+
+	cleanupIndex, err := c.prepareIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupIndex()
+
+	oid, err := git2go.NewOid(c.Commit)
+	if err != nil {
+		return nil, fmt.Errorf("could not create oid for '%s': %w", c.Commit, err)
+	}
+
+	release, err := acquireHostSlot(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	opts, err = registerManagedTransportOptions(ctx, url, withCredentialsCache(opts))
+	if err != nil {
+		return nil, err
+	}
+	transportOptsURL := opts.TransportOptionsURL
+	defer managed.RemoveTransportOptions(transportOptsURL)
+
+	repo, remote, err := initializeRepoWithRemote(ctx, path, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		remote.Free()
+		repo.Free()
+	}()
+
+	err = c.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		return fetchOrDisconnect(ctx, remote, c.fetchRefspecs(c.Branch), &git2go.FetchOptions{
+			DownloadTags:    git2go.DownloadTagsNone,
+			RemoteCallbacks: c.fetchCallbacks(ctx),
+		})
+	})
+	if err != nil {
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch remote '%s': %w", url, err))
+	}
+
+	branch, err := resolveRemoteBranch(repo, c.Branch)
+	if err != nil {
+		return nil, refNotFoundError("refs/heads/"+c.Branch,
+			fmt.Errorf("unable to lookup branch '%s' for '%s': %w", c.Branch, url, libGit2Error(err)))
+	}
+	defer branch.Free()
+
+	// The commit must either be the tip of the branch, or one of its ancestors.
+	// A commit which can not be found after fetching the branch has been
+	// removed from its history, e.g. by a force push.
+	if !branch.Target().Equal(oid) {
+		notPresentErr := &git.RefNotFoundError{Ref: c.Commit,
+			Err: fmt.Errorf("commit '%s' is no longer present in the history of branch '%s' at '%s'", c.Commit, c.Branch, url)}
+		if _, err := repo.LookupCommit(oid); err != nil {
+			return nil, notPresentErr
+		}
+		ok, err := repo.DescendantOf(branch.Target(), oid)
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine if commit '%s' is an ancestor of branch '%s': %w", c.Commit, c.Branch, libGit2Error(err))
+		}
+		if !ok {
+			return nil, notPresentErr
+		}
+	}
+
+	cc, err := c.checkoutDetachedHEAD(repo, oid)
+	if err != nil {
+		return nil, refNotFoundError(c.Commit, fmt.Errorf("git checkout error: %w", err))
+	}
+	defer cc.Free()
+	if err = c.updateSubmodules(ctx, repo, url, opts); err != nil {
+		return nil, err
+	}
+	return c.buildCommit(repo, cc, "refs/heads/"+c.Branch)
+}
+
+// CheckoutSemVer checks out the tag with the highest version matching the
+// SemVer constraint. Of equal versions, the tag pointing to the most recent
+// commit is checked out, and when their commits have the same timestamp, the
+// tag which sorts last in lexical order (e.g. 'v1.0.0+b' over 'v1.0.0+a').
+type CheckoutSemVer struct {
+	SemVer       string
+	LastRevision string
+	// TagPrefix limits the checkout to tags with the prefix, which is
+	// stripped before the remainder of the tag is parsed as a version.
+	TagPrefix string
+	// TagFilter limits the checkout to tags with a name matching the glob
+	// pattern, which are the only tags resolved to a commit.
+	TagFilter string
+	// IgnorePrerelease excludes pre-release versions, unless the SemVer
+	// constraint contains a pre-release version.
+	IgnorePrerelease bool
+
+	checkoutOptions
+}
+
+func (c *CheckoutSemVer) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (_ *git.Commit, err error) {
+	defer cleanupOnError(path)(&err)
+	defer recoverPanic(&err)
+
+	cleanupIndex, err := c.prepareIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupIndex()
+
+	verConstraint, err := semver.NewConstraint(c.SemVer)
+	if err != nil {
+		return nil, fmt.Errorf("semver parse error: %w", err)
+	}
+	ignorePrerelease := c.IgnorePrerelease && !constraintHasPrerelease(c.SemVer)
+	return c.checkoutLatestVersion(ctx, path, url, opts, verConstraint, c.TagPrefix, c.TagFilter, ignorePrerelease, c.LastRevision,
+		&git.RefNotFoundError{Ref: c.SemVer, Err: fmt.Errorf("no match found for semver: %s", c.SemVer)})
+}
+
+// CheckoutLatestTag checks out the tag with the highest version, ordering
+// tags which only differ by build metadata by their tagger or commit date.
+type CheckoutLatestTag struct {
+	LastRevision string
+	// TagPrefix limits the checkout to tags with the prefix, which is
+	// stripped before the remainder of the tag is parsed as a version.
+	TagPrefix string
+	// TagFilter limits the checkout to tags with a name matching the glob
+	// pattern, which are the only tags resolved to a commit.
+	TagFilter string
+	// IgnorePrerelease excludes pre-release versions.
+	IgnorePrerelease bool
+
+	checkoutOptions
+}
+
+func (c *CheckoutLatestTag) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (_ *git.Commit, err error) {
+	defer cleanupOnError(path)(&err)
+	defer recoverPanic(&err)
+
+	cleanupIndex, err := c.prepareIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupIndex()
+
+	return c.checkoutLatestVersion(ctx, path, url, opts, nil, c.TagPrefix, c.TagFilter, c.IgnorePrerelease, c.LastRevision,
+		&git.RefNotFoundError{Ref: "refs/tags/" + c.TagPrefix, Err: fmt.Errorf("no version tags found at '%s'", url)})
+}
+
+// checkoutLatestVersion clones the repository, and checks out the tag with
+// the latest version matching the constraint, or any version if the
+// constraint is nil. Only tags with the given prefix are considered, which is
+// stripped before parsing the version, and pre-release versions are excluded
+// if ignorePrerelease is set. Tags not matching the glob pattern of tagFilter
+// are skipped before they are resolved. It returns noMatch if there is no
+// such tag.
+func (o checkoutOptions) checkoutLatestVersion(ctx context.Context, path, url string, opts *git.AuthOptions,
+	constraint *semver.Constraints, tagPrefix, tagFilter string, ignorePrerelease bool, lastRevision string, noMatch error) (*git.Commit, error) {
+	// Share the credentials between listing the tags and fetching.
+	opts = withCredentialsCache(opts)
+
+	// Tags are matched on their name without the 'refs/tags/' prefix.
+	tagPrefix = strings.TrimPrefix(tagPrefix, "refs/tags/")
+	if _, err := filepath.Match(tagFilter, ""); err != nil {
+		return nil, fmt.Errorf("invalid tag filter '%s': %w", tagFilter, err)
+	}
+
+	release, err := acquireHostSlot(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// When the last observed revision is set, check whether the constraint
+	// still matches the same tag and commit at the remote. If so,
+	// short-circuit the clone operation here.
+	if lastRevision != "" {
+		remote, closeRemote, err := connectRemote(ctx, url, opts)
+		if err != nil {
+			return nil, err
+		}
+		tag, hash, err := lsRemoteSemVer(remote, constraint, tagPrefix, tagFilter, ignorePrerelease)
+		closeRemote()
+		if err != nil {
+			return nil, contextError(ctx, url, fmt.Errorf("unable to remote ls for '%s': %w", url, err))
+		}
+		if tag != "" && fmt.Sprintf("%s/%s", tag, hash) == lastRevision {
+			// Construct a partial commit with the existing information.
+			return o.partialCommit(hash, "refs/tags/"+tag), nil
+		}
+	}
+
+	opts, err = registerManagedTransportOptions(ctx, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	transportOptsURL := opts.TransportOptionsURL
+	defer managed.RemoveTransportOptions(transportOptsURL)
+
+	repo, err := o.reuseRepository(ctx, path, url, opts, git2go.DownloadTagsAll)
+	if err != nil {
+		return nil, err
+	}
+	if repo == nil {
+		if repo, err = o.clone(ctx, path, url, transportOptsURL, git2go.DownloadTagsAll); err != nil {
+			return nil, err
+		}
+	}
+	defer repo.Free()
+	if err = o.recordTargetURL(repo, url); err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	tagTimestamps := make(map[string]time.Time)
+	if err := repo.Tags.Foreach(func(name string, id *git2go.Oid) error {
+		cleanName := strings.TrimPrefix(name, "refs/tags/")
+		if !filterTag(cleanName, tagPrefix, tagFilter) {
+			return nil
+		}
+		// The given ID can refer to both a commit and a tag, as annotated tags contain additional metadata.
+		// Due to this, first attempt to resolve it as a simple tag (commit), but fallback to attempting to
+		// resolve it as an annotated tag in case this results in an error.
+		if c, err := repo.LookupCommit(id); err == nil {
+			defer c.Free()
+			// Use the commit metadata as the decisive timestamp.
+			tagTimestamps[cleanName] = c.Committer().When
+			tags[cleanName] = name
+			return nil
+		}
+		t, err := repo.LookupTag(id)
+		if err != nil {
+			return fmt.Errorf("could not lookup '%s' as simple or annotated tag: %w", cleanName, err)
+		}
+		defer t.Free()
+		c, _, err := peelToCommit(&t.Object)
+		if err != nil {
+			return fmt.Errorf("could not get commit for tag '%s': %w", t.Name(), err)
+		}
+		defer c.Free()
+		// Prefer the tagger date, which is later than the commit date when
+		// an older commit is tagged. Like the version, it is taken from the
+		// outermost tag, which is the one the reference name belongs to; the
+		// name recorded in the tag object may differ from it.
+		tagTimestamps[cleanName] = c.Committer().When
+		if tagger := t.Tagger(); tagger != nil {
+			tagTimestamps[cleanName] = tagger.When
+		}
+		tags[cleanName] = name
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	matchedVersions := matchVersions(tags, constraint, tagPrefix, ignorePrerelease)
+	if len(matchedVersions) == 0 {
+		return nil, noMatch
+	}
+
+	sortVersions(matchedVersions, tagTimestamps, tagPrefix)
+	v := matchedVersions[len(matchedVersions)-1]
+	t := tagPrefix + v.Original()
+
+	cc, err := o.checkoutDetachedDwim(repo, t)
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Free()
+	if err = o.updateSubmodules(ctx, repo, url, opts); err != nil {
+		return nil, err
+	}
+	return o.buildTagCommit(repo, cc, "refs/tags/"+t)
+}
+
+// lsRemoteSemVer resolves the given constraint against the tags with the
+// given prefix and matching the filter advertised by the connected remote, or any version if the
+// constraint is nil, and returns the name of the latest matching tag and the
+// hash of the commit it points to, with annotated tags peeled to their
+// target. It returns an empty tag if there is no match, or if the latest
+// match can not be determined without the commit timestamps of the tags.
+func lsRemoteSemVer(remote *git2go.Remote, constraint *semver.Constraints, tagPrefix, tagFilter string, ignorePrerelease bool) (string, string, error) {
+	heads, err := remote.Ls()
+	if err != nil {
+		return "", "", libGit2Error(err)
+	}
+
+	// Annotated tags are advertised twice, once for the tag object and once
+	// for the commit it peels to, which takes precedence.
+	tags := make(map[string]string)
+	peeled := make(map[string]string)
+	for _, h := range heads {
+		if !strings.HasPrefix(h.Name, "refs/tags/") {
+			continue
+		}
+		name := strings.TrimPrefix(h.Name, "refs/tags/")
+		if !filterTag(strings.TrimSuffix(name, "^{}"), tagPrefix, tagFilter) {
+			continue
+		}
+		if strings.HasSuffix(name, "^{}") {
+			peeled[strings.TrimSuffix(name, "^{}")] = h.Id.String()
+			continue
+		}
+		tags[name] = h.Id.String()
+	}
+	for name, hash := range peeled {
+		tags[name] = hash
+	}
+
+	matchedVersions := matchVersions(tags, constraint, tagPrefix, ignorePrerelease)
+	if len(matchedVersions) == 0 {
+		return "", "", nil
+	}
+	sort.Sort(matchedVersions)
+	latest := matchedVersions[len(matchedVersions)-1]
+	// Versions which only differ by build metadata are ordered by the
+	// timestamps of their commits, which are not advertised.
+	if len(matchedVersions) > 1 && matchedVersions[len(matchedVersions)-2].Equal(latest) {
+		return "", "", nil
+	}
+	tag := tagPrefix + latest.Original()
+	return tag, tags[tag], nil
+}
+
+// sortVersions sorts the given versions in ascending order. Equal versions,
+// for example versions which only differ by build metadata, are sorted by the
+// timestamps of the commits their tags point to, and then by their tags in
+// lexical order. This makes the last version deterministic, even when the
+// tags of equal versions point to commits with the same timestamp.
+func sortVersions(versions semver.Collection, tagTimestamps map[string]time.Time, tagPrefix string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		left := versions[i]
+		right := versions[j]
+
+		if !left.Equal(right) {
+			return left.LessThan(right)
+		}
+
+		// Having tag target timestamps at our disposal, we further try to sort
+		// versions into a chronological order. This is especially important for
+		// versions that differ only by build metadata, because it is not considered
+		// a part of the comparable version in Semver
+		leftTime, rightTime := tagTimestamps[tagPrefix+left.Original()], tagTimestamps[tagPrefix+right.Original()]
+		if !leftTime.Equal(rightTime) {
+			return leftTime.Before(rightTime)
+		}
+		return left.Original() < right.Original()
+	})
+}
+
+// filterTag returns if the tag with the given name, without the 'refs/tags/'
+// prefix, has the prefix and matches the glob pattern of the filter, if any.
+// The filter is assumed to be a valid pattern.
+func filterTag(name, tagPrefix, tagFilter string) bool {
+	if !strings.HasPrefix(name, tagPrefix) {
+		return false
+	}
+	if tagFilter == "" {
+		return true
+	}
+	ok, _ := filepath.Match(tagFilter, name)
+	return ok
+}
+
+// matchVersions returns the versions of the given tags with the prefix which
+// match the constraint, or all versions if the constraint is nil. The prefix
+// is stripped from the tags before they are parsed, and the original of each
+// returned version equals the tag without the prefix. Pre-release versions
+// are excluded if ignorePrerelease is set.
+func matchVersions(tags map[string]string, constraint *semver.Constraints, tagPrefix string, ignorePrerelease bool) semver.Collection {
+	var matched semver.Collection
+	for tag := range tags {
+		if !strings.HasPrefix(tag, tagPrefix) {
+			continue
+		}
+		v, err := version.ParseVersion(strings.TrimPrefix(tag, tagPrefix))
+		if err != nil {
+			continue
+		}
+		if ignorePrerelease && v.Prerelease() != "" {
+			continue
+		}
+		if constraint != nil && !constraint.Check(v) {
+			continue
+		}
+		matched = append(matched, v)
+	}
+	return matched
+}
+
+// constraintHasPrerelease returns if any of the versions in the given SemVer
+// constraint has a pre-release, for example '>= 1.4.0-rc.1'.
+func constraintHasPrerelease(constraint string) bool {
+	fields := strings.FieldsFunc(constraint, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == '|'
+	})
+	for _, f := range fields {
+		v, err := semver.NewVersion(strings.TrimLeft(f, "=<>!~^"))
+		if err == nil && v.Prerelease() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkoutDetachedDwim attempts to perform a detached HEAD checkout by first DWIMing the short name
+// to get a concrete reference, and then calling checkoutDetachedHEAD.
+func (o checkoutOptions) checkoutDetachedDwim(repo *git2go.Repository, name string) (*git2go.Commit, error) {
+	ref, err := repo.References.Dwim(name)
 	if err != nil {
 		return nil, fmt.Errorf("unable to find '%s': %w", name, err)
 	}
 	defer ref.Free()
-	c, err := ref.Peel(git2go.ObjectCommit)
+	resolved, err := ref.Resolve()
 	if err != nil {
-		return nil, fmt.Errorf("could not get commit for ref '%s': %w", ref.Name(), err)
+		return nil, fmt.Errorf("could not resolve ref '%s': %w", ref.Name(), err)
 	}
-	defer c.Free()
-	cc, err := c.AsCommit()
+	defer resolved.Free()
+	obj, err := repo.Lookup(resolved.Target())
 	if err != nil {
-		return nil, fmt.Errorf("could not get commit object for ref '%s': %w", ref.Name(), err)
+		return nil, fmt.Errorf("could not get object for ref '%s': %w", ref.Name(), err)
+	}
+	defer obj.Free()
+	cc, _, err := peelToCommit(obj)
+	if err != nil {
+		return nil, fmt.Errorf("could not get commit for ref '%s': %w", ref.Name(), err)
 	}
 	defer cc.Free()
-	return checkoutDetachedHEAD(repo, cc.Id())
+	return o.checkoutDetachedHEAD(repo, cc.Id())
 }
 
 // checkoutDetachedHEAD attempts to perform a detached HEAD checkout for the given commit.
-func checkoutDetachedHEAD(repo *git2go.Repository, oid *git2go.Oid) (*git2go.Commit, error) {
+// With ResolveOnly, the commit is only looked up.
+func (o checkoutOptions) checkoutDetachedHEAD(repo *git2go.Repository, oid *git2go.Oid) (*git2go.Commit, error) {
 	cc, err := repo.LookupCommit(oid)
 	if err != nil {
 		return nil, fmt.Errorf("git commit '%s' not found: %w", oid.String(), err)
 	}
+	if o.ResolveOnly {
+		return cc, nil
+	}
+	tree, err := cc.Tree()
+	if err != nil {
+		cc.Free()
+		return nil, fmt.Errorf("unable to lookup tree for commit '%s': %w", oid.String(), err)
+	}
+	defer tree.Free()
+	if err = o.validateTree(repo, tree); err != nil {
+		cc.Free()
+		return nil, err
+	}
+	if err = o.configureRepository(repo); err != nil {
+		cc.Free()
+		return nil, err
+	}
+	if err = o.writeWorktree(repo, tree); err != nil {
+		cc.Free()
+		return nil, fmt.Errorf("git checkout error: %w", err)
+	}
 	if err = repo.SetHeadDetached(cc.Id()); err != nil {
 		cc.Free()
 		return nil, fmt.Errorf("could not detach HEAD at '%s': %w", oid.String(), err)
 	}
-	if err = repo.CheckoutHead(&git2go.CheckoutOptions{
-		Strategy: git2go.CheckoutForce,
-	}); err != nil {
+	if err = o.verifyWorktree(repo, tree); err != nil {
 		cc.Free()
-		return nil, fmt.Errorf("git checkout error: %w", err)
+		return nil, err
+	}
+	if err = o.writeIndex(tree); err != nil {
+		cc.Free()
+		return nil, err
 	}
 	return cc, nil
 }
 
+// maxSymbolicRefDepth is the maximum number of symbolic references followed
+// while resolving a reference, which equals the limit of git.
+const maxSymbolicRefDepth = 5
+
+// resolveRemoteBranch looks up the remote-tracking reference of the branch
+// with the given name, following it to the reference it points to when it
+// is a symbolic reference. A symbolic reference to another branch is
+// followed to the remote-tracking reference of that branch. Loops of
+// symbolic references result in an error.
+func resolveRemoteBranch(repo *git2go.Repository, branch string) (*git2go.Reference, error) {
+	name := "refs/remotes/origin/" + branch
+	var followed []string
+	for {
+		ref, err := repo.References.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		if ref.Type() != git2go.ReferenceSymbolic {
+			return ref, nil
+		}
+		followed = append(followed, name)
+		name = ref.SymbolicTarget()
+		ref.Free()
+		if strings.HasPrefix(name, "refs/heads/") {
+			name = "refs/remotes/origin/" + strings.TrimPrefix(name, "refs/heads/")
+		}
+		for _, f := range followed {
+			if f == name {
+				return nil, fmt.Errorf("symbolic reference loop detected: %s -> %s", strings.Join(followed, " -> "), name)
+			}
+		}
+		if len(followed) == maxSymbolicRefDepth {
+			return nil, fmt.Errorf("symbolic reference '%s' exceeds the maximum depth of %d", followed[0], maxSymbolicRefDepth)
+		}
+	}
+}
+
+// maxTagPeelDepth is the maximum number of nested annotated tags followed
+// while peeling a tag, to guard against pathological tag chains.
+const maxTagPeelDepth = 10
+
+// peelToCommit peels the given object to the commit it points to, following
+// annotated tags which point to other annotated tags. It returns the commit,
+// and the number of tag objects which were followed to reach it.
+func peelToCommit(obj *git2go.Object) (*git2go.Commit, int, error) {
+	var depth int
+	current := obj
+	defer func() {
+		if current != obj {
+			current.Free()
+		}
+	}()
+	for current.Type() == git2go.ObjectTag {
+		if depth == maxTagPeelDepth {
+			return nil, depth, fmt.Errorf("tag chain exceeds the maximum depth of %d", maxTagPeelDepth)
+		}
+		t, err := current.AsTag()
+		if err != nil {
+			return nil, depth, err
+		}
+		target := t.Target()
+		t.Free()
+		if target == nil {
+			return nil, depth, fmt.Errorf("unable to lookup target of tag '%s'", current.Id().String())
+		}
+		if current != obj {
+			current.Free()
+		}
+		current = target
+		depth++
+	}
+	c, err := current.AsCommit()
+	if err != nil {
+		return nil, depth, fmt.Errorf("tag does not point to a commit: %w", err)
+	}
+	return c, depth, nil
+}
+
 // headCommit returns the current HEAD of the repository, or an error.
 func headCommit(repo *git2go.Repository) (*git2go.Commit, error) {
 	head, err := repo.Head()
@@ -471,17 +1706,97 @@ func headCommit(repo *git2go.Repository) (*git2go.Commit, error) {
 	return c, nil
 }
 
-func buildCommit(c *git2go.Commit, ref string) *git.Commit {
-	sig, msg, _ := c.ExtractSignature()
+// partialCommit returns a commit with only the given hash and reference,
+// for a checkout which is short-circuited as the remote did not change
+// since the last observed revision.
+func (o checkoutOptions) partialCommit(hash, ref string) *git.Commit {
 	return &git.Commit{
+		Hash:      git.Hash(hash),
+		Reference: ref,
+		Partial:   true,
+		Warnings:  o.warnings,
+	}
+}
+
+// buildCommit returns the git.Commit for the given commit, after verifying
+// it against the configured public key ring and allowed signers.
+func (o checkoutOptions) buildCommit(repo *git2go.Repository, c *git2go.Commit, ref string) (*git.Commit, error) {
+	sig, msg, _ := c.ExtractSignature()
+	author, committer := buildSignature(c.Author()), buildSignature(c.Committer())
+	if o.NormalizeTimestamps {
+		author.When = author.When.UTC()
+		committer.When = committer.When.UTC()
+	}
+	commit := &git.Commit{
 		Hash:      []byte(c.Id().String()),
 		Reference: ref,
-		Author:    buildSignature(c.Author()),
-		Committer: buildSignature(c.Committer()),
+		Author:    author,
+		Committer: committer,
 		Signature: sig,
 		Encoded:   []byte(msg),
 		Message:   c.Message(),
+		Parents:   buildParents(c),
+		EmptyTree: c.TreeId().String() == git.EmptyTreeHash,
+		Warnings:  o.warnings,
+		Transfer:  o.progress.result(),
+	}
+	note, err := o.readNote(repo, c.Id())
+	if err != nil {
+		return nil, err
+	}
+	commit.Notes = note
+	if commit.Stats, err = o.repositoryStats(repo); err != nil {
+		return nil, err
+	}
+	if err := o.verifySignature(commit); err != nil {
+		return nil, err
+	}
+	if err := o.verifyAllowedSigners(repo, c, commit); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// verifySignature verifies the PGP or SSH signature of the given commit
+// against the PublicKeyRing or SSHPublicKeys, depending on its format.
+func (o checkoutOptions) verifySignature(commit *git.Commit) error {
+	if o.PublicKeyRing == "" && o.SSHPublicKeys == "" {
+		return nil
+	}
+	if commit.Signature == "" {
+		return fmt.Errorf("%w: commit '%s' is not signed", git.ErrCommitSignatureInvalid, commit.Hash)
+	}
+	if _, err := git.VerifySignature(commit.Signature, commit.Encoded, o.PublicKeyRing, o.SSHPublicKeys); err != nil {
+		return fmt.Errorf("%w: commit '%s': %s", git.ErrCommitSignatureInvalid, commit.Hash, err)
+	}
+	return nil
+}
+
+// verifyAllowedSigners verifies the SSH signature of the given commit against
+// the allowed_signers file at AllowedSignersPath in the tree of the commit.
+func (o checkoutOptions) verifyAllowedSigners(repo *git2go.Repository, c *git2go.Commit, commit *git.Commit) error {
+	if o.AllowedSignersPath == "" {
+		return nil
 	}
+	tree, err := c.Tree()
+	if err != nil {
+		return fmt.Errorf("unable to lookup tree for commit '%s': %w", c.Id().String(), libGit2Error(err))
+	}
+	defer tree.Free()
+	entry, err := tree.EntryByPath(o.AllowedSignersPath)
+	if err != nil {
+		return fmt.Errorf("unable to find allowed signers file '%s' in commit '%s': %w",
+			o.AllowedSignersPath, c.Id().String(), libGit2Error(err))
+	}
+	blob, err := repo.LookupBlob(entry.Id)
+	if err != nil {
+		return fmt.Errorf("unable to read allowed signers file '%s': %w", o.AllowedSignersPath, libGit2Error(err))
+	}
+	defer blob.Free()
+	if _, err = commit.VerifySSHAllowedSigners(blob.Contents()); err != nil {
+		return fmt.Errorf("signature verification of commit '%s' failed: %w", c.Id().String(), err)
+	}
+	return nil
 }
 
 func buildSignature(s *git2go.Signature) git.Signature {
@@ -492,6 +1807,68 @@ func buildSignature(s *git2go.Signature) git.Signature {
 	}
 }
 
+// buildTagCommit builds the commit checked out through the given tag
+// reference, with the annotated tag it points to.
+func (o checkoutOptions) buildTagCommit(repo *git2go.Repository, c *git2go.Commit, ref string) (*git.Commit, error) {
+	commit, err := o.buildCommit(repo, c, ref)
+	if err != nil {
+		return nil, err
+	}
+	if commit.Tag, err = o.lookupAnnotatedTag(repo, ref); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// lookupAnnotatedTag returns the annotated tag the given tag reference points
+// to, or nil if it does not exist or is a lightweight tag.
+func (o checkoutOptions) lookupAnnotatedTag(repo *git2go.Repository, ref string) (*git.Tag, error) {
+	r, err := repo.References.Lookup(ref)
+	if err != nil {
+		if git2go.IsErrorCode(err, git2go.ErrorCodeNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to lookup tag '%s': %w", ref, libGit2Error(err))
+	}
+	defer r.Free()
+	if r.Target() == nil {
+		return nil, nil
+	}
+	// Lightweight tags point directly to a commit, which can not be looked
+	// up as a tag.
+	t, err := repo.LookupTag(r.Target())
+	if err != nil {
+		if git2go.IsErrorCode(err, git2go.ErrorCodeNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to lookup tag '%s': %w", ref, libGit2Error(err))
+	}
+	defer t.Free()
+
+	tag := &git.Tag{
+		Hash:    git.Hash(t.Id().String()),
+		Name:    t.Name(),
+		Message: t.Message(),
+	}
+	if tagger := t.Tagger(); tagger != nil {
+		tag.Tagger = buildSignature(tagger)
+		if o.NormalizeTimestamps {
+			tag.Tagger.When = tag.Tagger.When.UTC()
+		}
+	}
+	return tag, nil
+}
+
+// buildParents returns the hashes of the parents of the given commit, which
+// is an empty slice for a root commit.
+func buildParents(c *git2go.Commit) []git.Hash {
+	parents := make([]git.Hash, 0, c.ParentCount())
+	for i := uint(0); i < c.ParentCount(); i++ {
+		parents = append(parents, git.Hash(c.ParentId(i).String()))
+	}
+	return parents
+}
+
 // initializeRepoWithRemote initializes or opens a repository at the given path
 // and configures it with the given transport opts URL (as a placeholder for the
 // actual target url). If a remote already exists with a different URL, it overwrites
@@ -499,7 +1876,7 @@ func buildSignature(s *git2go.Signature) git.Signature {
 func initializeRepoWithRemote(ctx context.Context, path, url string, opts *git.AuthOptions) (*git2go.Repository, *git2go.Remote, error) {
 	repo, err := git2go.InitRepository(path, false)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to init repository for '%s': %w", url, gitutil.LibGit2Error(err))
+		return nil, nil, fmt.Errorf("unable to init repository for '%s': %w", url, libGit2Error(err))
 	}
 
 	transportOptsURL := opts.TransportOptionsURL
@@ -513,7 +1890,13 @@ func initializeRepoWithRemote(ctx context.Context, path, url string, opts *git.A
 				return nil, nil, fmt.Errorf("unable to create or lookup remote '%s'", defaultRemoteName)
 			}
 
-			if remote.Url() != transportOptsURL {
+			// The remote URL is a placeholder for the actual target URL.
+			// When it only differs by user information, e.g. rotated
+			// credentials, it is made to resolve to the transport options
+			// instead of reconfiguring it.
+			if equalIgnoringUserInfo(remote.Url(), transportOptsURL) {
+				managed.AliasTransportOptions(remote.Url(), transportOptsURL)
+			} else {
 				err = repo.Remotes.SetUrl("origin", transportOptsURL)
 				if err != nil {
 					repo.Free()
@@ -522,6 +1905,7 @@ func initializeRepoWithRemote(ctx context.Context, path, url string, opts *git.A
 				}
 
 				// refresh the remote
+				remote.Free()
 				remote, err = repo.Remotes.Lookup(defaultRemoteName)
 				if err != nil {
 					repo.Free()
@@ -530,33 +1914,59 @@ func initializeRepoWithRemote(ctx context.Context, path, url string, opts *git.A
 			}
 		} else {
 			repo.Free()
-			return nil, nil, fmt.Errorf("unable to create remote for '%s': %w", url, gitutil.LibGit2Error(err))
+			return nil, nil, fmt.Errorf("unable to create remote for '%s': %w", url, libGit2Error(err))
 		}
 	}
 	return repo, remote, nil
 }
 
 // registerManagedTransportOptions registers the given url and it's transport options.
-// Callers must make sure to call `managed.RemoveTransportOptions()` to avoid increase in
-// memory consumption.
+// Callers must defer `managed.RemoveTransportOptions()` immediately after it succeeds,
+// before anything else which could fail or panic, to avoid increase in memory consumption.
 // We store the target URL, auth options, etc. mapped to TransporOptsURL because managed transports
 // don't provide a way for any kind of dependency injection.
 // This lets us have a way of doing interop between application level code and transport level code
 // which enables us to fetch the required credentials, context, etc. at the transport level.
-func registerManagedTransportOptions(ctx context.Context, url string, authOpts *git.AuthOptions) error {
+// When authOpts has no TransportOptionsURL, for example to anonymously check
+// out a public repository, a copy of authOpts with a unique one is returned.
+// Otherwise, authOpts is returned as is.
+func registerManagedTransportOptions(ctx context.Context, url string, authOpts *git.AuthOptions) (*git.AuthOptions, error) {
 	if authOpts == nil {
-		return errors.New("can't checkout using libgit2 with an empty set of auth options")
+		return nil, errors.New("can't checkout using libgit2 with an empty set of auth options")
 	}
 	if authOpts.TransportOptionsURL == "" {
-		return errors.New("can't checkout using libgit2 without a valid transport auth id")
+		transportOptsURL, err := anonymousTransportOptionsURL(url)
+		if err != nil {
+			return nil, err
+		}
+		anonymousOpts := *authOpts
+		anonymousOpts.TransportOptionsURL = transportOptsURL
+		authOpts = &anonymousOpts
+	}
+	proxyOpts, err := proxyOptions(authOpts.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if len(authOpts.CAFile) > 0 {
+		warnings, err := validateCABundle(authOpts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range warnings {
+			logr.FromContextOrDiscard(ctx).Info(w)
+		}
 	}
 	managed.AddTransportOptions(authOpts.TransportOptionsURL, managed.TransportOptions{
-		TargetURL:    url,
-		AuthOpts:     authOpts,
-		ProxyOptions: &git2go.ProxyOptions{Type: git2go.ProxyTypeAuto},
-		Context:      ctx,
+		TargetURL:          url,
+		AuthOpts:           authOpts,
+		ProxyOptions:       proxyOpts,
+		Context:            ctx,
+		PackChecksumHeader: authOpts.PackChecksumHeader,
+		HostKeyAlgos:       authOpts.HostKeyAlgos,
+		HTTPTransport:      authOpts.HTTPTransport,
+		URLRewrite:         authOpts.URLRewrite,
 	})
-	return nil
+	return authOpts, nil
 }
 
 func recoverPanic(err *error) {
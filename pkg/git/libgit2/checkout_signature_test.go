@@ -0,0 +1,382 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/fluxcd/pkg/gittestserver"
+	git2go "github.com/libgit2/git2go/v33"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func TestCheckout_AllowedSigners(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+
+	repo, err := git2go.OpenRepository(filepath.Join(server.Root(), repoPath))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+
+	signer := newSSHSigner(g)
+
+	allowedSigners := "author@example.com " + string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	unsigned, err := commitFile(repo, ".github/allowed_signers", allowedSigners, time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	signed, err := commitSigned(repo, signer, "Signed commit", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repoURL := server.HTTPAddress() + "/" + repoPath
+
+	tests := []struct {
+		name     string
+		strategy git.CheckoutStrategy
+		wantErr  string
+	}{
+		{
+			name:     "signed by allowed signer",
+			strategy: &CheckoutBranch{Branch: git.DefaultBranch, checkoutOptions: checkoutOptions{AllowedSignersPath: ".github/allowed_signers"}},
+		},
+		{
+			name:     "signed commit without verification",
+			strategy: &CheckoutCommit{Commit: signed.String()},
+		},
+		{
+			name:     "unsigned commit",
+			strategy: &CheckoutCommit{Commit: unsigned.String(), checkoutOptions: checkoutOptions{AllowedSignersPath: ".github/allowed_signers"}},
+			wantErr:  "commit does not have a SSH signature",
+		},
+		{
+			name:     "allowed signers file does not exist",
+			strategy: &CheckoutBranch{Branch: git.DefaultBranch, checkoutOptions: checkoutOptions{AllowedSignersPath: "allowed_signers"}},
+			wantErr:  "unable to find allowed signers file 'allowed_signers'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			authOpts := git.AuthOptions{
+				TransportOptionsURL: getTransportOptionsURL(git.HTTP),
+			}
+			cc, err := tt.strategy.Checkout(context.TODO(), t.TempDir(), repoURL, &authOpts)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				g.Expect(cc).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.Hash.String()).To(Equal(signed.String()))
+		})
+	}
+}
+
+func TestCheckout_PublicKeyRing(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+
+	repo, err := git2go.OpenRepository(filepath.Join(server.Root(), repoPath))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+
+	trusted, err := openpgp.NewEntity("Jane Doe", "", "author@example.com", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	untrusted, err := openpgp.NewEntity("John Doe", "", "other@example.com", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var keyRing bytes.Buffer
+	w, err := armor.Encode(&keyRing, openpgp.PublicKeyType, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(trusted.Serialize(w)).To(Succeed())
+	g.Expect(w.Close()).To(Succeed())
+
+	unsigned, err := commitFile(repo, "file", "unsigned", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	untrustedSigned, err := commitPGPSigned(repo, untrusted, "Signed by untrusted key", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	signed, err := commitPGPSigned(repo, trusted, "Signed by trusted key", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repoURL := server.HTTPAddress() + "/" + repoPath
+
+	tests := []struct {
+		name     string
+		opts     git.CheckoutOptions
+		wantHash string
+		wantErr  bool
+	}{
+		{
+			name:     "branch signed by trusted key",
+			opts:     git.CheckoutOptions{Branch: git.DefaultBranch, PublicKeyRing: keyRing.String()},
+			wantHash: signed.String(),
+		},
+		{
+			name:     "commit signed by trusted key",
+			opts:     git.CheckoutOptions{Commit: signed.String(), PublicKeyRing: keyRing.String()},
+			wantHash: signed.String(),
+		},
+		{
+			name:    "commit signed by untrusted key",
+			opts:    git.CheckoutOptions{Commit: untrustedSigned.String(), PublicKeyRing: keyRing.String()},
+			wantErr: true,
+		},
+		{
+			name:    "unsigned commit",
+			opts:    git.CheckoutOptions{Commit: unsigned.String(), PublicKeyRing: keyRing.String()},
+			wantErr: true,
+		},
+		{
+			name:     "unsigned commit without verification",
+			opts:     git.CheckoutOptions{Commit: unsigned.String()},
+			wantHash: unsigned.String(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			authOpts := git.AuthOptions{
+				TransportOptionsURL: getTransportOptionsURL(git.HTTP),
+			}
+			cc, err := CheckoutStrategyForOptions(context.TODO(), tt.opts).Checkout(context.TODO(), t.TempDir(), repoURL, &authOpts)
+			if tt.wantErr {
+				g.Expect(errors.Is(err, git.ErrCommitSignatureInvalid)).To(BeTrue())
+				g.Expect(cc).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.Hash.String()).To(Equal(tt.wantHash))
+		})
+	}
+}
+
+func TestCheckout_SSHPublicKeys(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+
+	repo, err := git2go.OpenRepository(filepath.Join(server.Root(), repoPath))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+
+	trusted := newSSHSigner(g)
+	untrusted := newSSHSigner(g)
+	pgpEntity, err := openpgp.NewEntity("Jane Doe", "", "author@example.com", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var keyRing bytes.Buffer
+	w, err := armor.Encode(&keyRing, openpgp.PublicKeyType, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pgpEntity.Serialize(w)).To(Succeed())
+	g.Expect(w.Close()).To(Succeed())
+	publicKeys := string(ssh.MarshalAuthorizedKey(trusted.PublicKey()))
+
+	untrustedSigned, err := commitSigned(repo, untrusted, "Signed by untrusted key", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	pgpSigned, err := commitPGPSigned(repo, pgpEntity, "Signed by PGP key", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	signed, err := commitSigned(repo, trusted, "Signed by trusted key", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repoURL := server.HTTPAddress() + "/" + repoPath
+
+	tests := []struct {
+		name     string
+		opts     git.CheckoutOptions
+		wantHash string
+		wantErr  bool
+	}{
+		{
+			name:     "branch signed by trusted key",
+			opts:     git.CheckoutOptions{Branch: git.DefaultBranch, SSHPublicKeys: publicKeys},
+			wantHash: signed.String(),
+		},
+		{
+			name:    "commit signed by untrusted key",
+			opts:    git.CheckoutOptions{Commit: untrustedSigned.String(), SSHPublicKeys: publicKeys},
+			wantErr: true,
+		},
+		{
+			name:     "PGP signed commit with key ring",
+			opts:     git.CheckoutOptions{Commit: pgpSigned.String(), PublicKeyRing: keyRing.String(), SSHPublicKeys: publicKeys},
+			wantHash: pgpSigned.String(),
+		},
+		{
+			name:    "PGP signed commit without key ring",
+			opts:    git.CheckoutOptions{Commit: pgpSigned.String(), SSHPublicKeys: publicKeys},
+			wantErr: true,
+		},
+		{
+			name:    "SSH signed commit with key ring only",
+			opts:    git.CheckoutOptions{Commit: signed.String(), PublicKeyRing: keyRing.String()},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			authOpts := git.AuthOptions{
+				TransportOptionsURL: getTransportOptionsURL(git.HTTP),
+			}
+			cc, err := CheckoutStrategyForOptions(context.TODO(), tt.opts).Checkout(context.TODO(), t.TempDir(), repoURL, &authOpts)
+			if tt.wantErr {
+				g.Expect(errors.Is(err, git.ErrCommitSignatureInvalid)).To(BeTrue())
+				g.Expect(cc).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.Hash.String()).To(Equal(tt.wantHash))
+		})
+	}
+}
+
+func newSSHSigner(g *WithT) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	signer, err := ssh.NewSignerFromKey(priv)
+	g.Expect(err).ToNot(HaveOccurred())
+	return signer
+}
+
+// commitSigned creates a commit on top of HEAD with the same tree, signed
+// with the given SSH signer as done by 'git commit -S' with 'gpg.format=ssh'.
+func commitSigned(repo *git2go.Repository, signer ssh.Signer, message string, time time.Time) (*git2go.Oid, error) {
+	return commitWithSignature(repo, message, time, func(buf []byte) (string, error) {
+		h := sha512.Sum512(buf)
+		signed := ssh.Marshal(struct {
+			Namespace     string
+			Reserved      string
+			HashAlgorithm string
+			Hash          []byte
+		}{Namespace: "git", HashAlgorithm: "sha512", Hash: h[:]})
+		sig, err := signer.Sign(rand.Reader, append([]byte("SSHSIG"), signed...))
+		if err != nil {
+			return "", err
+		}
+		blob := ssh.Marshal(struct {
+			Version       uint32
+			PublicKey     []byte
+			Namespace     string
+			Reserved      string
+			HashAlgorithm string
+			Signature     []byte
+		}{
+			Version:       1,
+			PublicKey:     signer.PublicKey().Marshal(),
+			Namespace:     "git",
+			HashAlgorithm: "sha512",
+			Signature:     ssh.Marshal(sig),
+		})
+		return fmt.Sprintf("-----BEGIN SSH SIGNATURE-----\n%s\n-----END SSH SIGNATURE-----",
+			base64.StdEncoding.EncodeToString(append([]byte("SSHSIG"), blob...))), nil
+	})
+}
+
+// commitPGPSigned creates a commit on top of HEAD with the same tree, signed
+// with the given PGP entity.
+func commitPGPSigned(repo *git2go.Repository, entity *openpgp.Entity, message string, time time.Time) (*git2go.Oid, error) {
+	return commitWithSignature(repo, message, time, func(buf []byte) (string, error) {
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, entity, bytes.NewReader(buf), nil); err != nil {
+			return "", err
+		}
+		return sig.String(), nil
+	})
+}
+
+// commitWithSignature creates a commit on top of HEAD with the same tree,
+// signed with the signature returned by sign for the commit buffer, and
+// points the default branch to it.
+func commitWithSignature(repo *git2go.Repository, message string, time time.Time, sign func([]byte) (string, error)) (*git2go.Oid, error) {
+	head, err := headCommit(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer head.Free()
+	tree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	defer tree.Free()
+
+	buf, err := repo.CreateCommitBuffer(mockSignature(time), mockSignature(time), git2go.MessageEncodingUTF8, message, tree, head)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	oid, err := repo.CreateCommitWithSignature(string(buf), sig, "gpgsig")
+	if err != nil {
+		return nil, err
+	}
+	ref, err := repo.References.Create("refs/heads/"+git.DefaultBranch, oid, true, "")
+	if err != nil {
+		return nil, err
+	}
+	defer ref.Free()
+	return oid, nil
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"fmt"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+// cleanupOnError returns a function which removes everything written to the
+// given path by a checkout, if the error it is given points to a non-nil
+// error once it is called. The path itself is removed when it did not exist
+// yet, while a path which was not empty is left alone, as its contents were
+// not written by the checkout. Checkout strategies defer the function before
+// recoverPanic, so that it observes recovered panics and runs after all
+// handles of the repository have been freed.
+func cleanupOnError(path string) func(err *error) {
+	state := git.RecordPathState(path)
+	return func(err *error) {
+		if *err == nil || !state.Owned() {
+			return
+		}
+		if cErr := state.Restore(); cErr != nil {
+			*err = fmt.Errorf("%w (failed to clean up checkout path: %s)", *err, cErr)
+		}
+	}
+}
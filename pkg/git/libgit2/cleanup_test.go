@@ -0,0 +1,97 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_cleanupOnError(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(path string) error
+		err        error
+		wantExists bool
+		wantFiles  []string
+	}{
+		{
+			name:  "removes path it created",
+			err:   errors.New("fetch failed"),
+			setup: func(string) error { return nil },
+		},
+		{
+			name:       "empties path which was empty",
+			err:        errors.New("fetch failed"),
+			setup:      func(path string) error { return os.Mkdir(path, 0o700) },
+			wantExists: true,
+		},
+		{
+			name: "leaves path which was not empty",
+			err:  errors.New("fetch failed"),
+			setup: func(path string) error {
+				if err := os.Mkdir(path, 0o700); err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join(path, "keep"), nil, 0o600)
+			},
+			wantExists: true,
+			wantFiles:  []string{"keep", "partial"},
+		},
+		{
+			name:       "leaves path without error",
+			setup:      func(string) error { return nil },
+			wantExists: true,
+			wantFiles:  []string{"partial"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			path := filepath.Join(t.TempDir(), "checkout")
+			g.Expect(tt.setup(path)).To(Succeed())
+
+			cleanup := cleanupOnError(path)
+			g.Expect(os.MkdirAll(path, 0o700)).To(Succeed())
+			g.Expect(os.WriteFile(filepath.Join(path, "partial"), nil, 0o600)).To(Succeed())
+			err := tt.err
+			cleanup(&err)
+			if tt.err == nil {
+				g.Expect(err).ToNot(HaveOccurred())
+			} else {
+				g.Expect(err).To(BeIdenticalTo(tt.err))
+			}
+
+			entries, rErr := os.ReadDir(path)
+			if !tt.wantExists {
+				g.Expect(os.IsNotExist(rErr)).To(BeTrue())
+				return
+			}
+			g.Expect(rErr).ToNot(HaveOccurred())
+			var files []string
+			for _, e := range entries {
+				files = append(files, e.Name())
+			}
+			g.Expect(files).To(Equal(tt.wantFiles))
+		})
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+var (
+	// hostSlots maps the host of a URL to the semaphore limiting the
+	// checkouts from it, when maxCheckoutsPerHost is set.
+	hostSlots = make(map[string]chan struct{})
+	// maxCheckoutsPerHost is the maximum number of concurrent checkouts
+	// from the same host, zero means unlimited.
+	maxCheckoutsPerHost int
+	hostSlotsMu         sync.Mutex
+)
+
+// SetMaxCheckoutsPerHost limits the number of checkouts connecting to the
+// same host at the same time to n, to avoid overwhelming a Git server which
+// is the target of many checkouts. Any further checkout waits for a slot
+// until its context is done. Zero or less means unlimited, which is the
+// default. A change applies to checkouts started after it is made.
+func SetMaxCheckoutsPerHost(n int) {
+	if n < 0 {
+		n = 0
+	}
+	hostSlotsMu.Lock()
+	defer hostSlotsMu.Unlock()
+	if n != maxCheckoutsPerHost {
+		maxCheckoutsPerHost = n
+		hostSlots = make(map[string]chan struct{})
+	}
+}
+
+// acquireHostSlot waits until a checkout from the host of the given URL is
+// allowed by SetMaxCheckoutsPerHost, or the context is done. It returns a
+// function releasing the slot, which must be called once the checkout
+// completes.
+func acquireHostSlot(ctx context.Context, url string) (func(), error) {
+	host := urlHost(url)
+	hostSlotsMu.Lock()
+	if maxCheckoutsPerHost == 0 {
+		hostSlotsMu.Unlock()
+		return func() {}, nil
+	}
+	slots, ok := hostSlots[host]
+	if !ok {
+		slots = make(chan struct{}, maxCheckoutsPerHost)
+		hostSlots[host] = slots
+	}
+	hostSlotsMu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a checkout slot for host '%s' aborted: %w", host, ctx.Err())
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_acquireHostSlot(t *testing.T) {
+	g := NewWithT(t)
+
+	SetMaxCheckoutsPerHost(1)
+	t.Cleanup(func() { SetMaxCheckoutsPerHost(0) })
+
+	release, err := acquireHostSlot(context.TODO(), "https://example.com/org/repo")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// A checkout from another host is not limited by the slot in use.
+	releaseOther, err := acquireHostSlot(context.TODO(), "ssh://git@example.org/org/repo")
+	g.Expect(err).ToNot(HaveOccurred())
+	releaseOther()
+
+	// A checkout from the same host waits until its context is done.
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	_, err = acquireHostSlot(ctx, "https://example.com/org/other")
+	g.Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring("host 'example.com'"))
+
+	// Or until the slot is released.
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		release, err := acquireHostSlot(context.TODO(), "https://example.com/org/other")
+		if err == nil {
+			release()
+		}
+	}()
+	release()
+	g.Eventually(acquired).Should(BeClosed())
+
+	// Without a limit, no slots are needed.
+	SetMaxCheckoutsPerHost(0)
+	for i := 0; i < 3; i++ {
+		_, err = acquireHostSlot(context.TODO(), "https://example.com/org/repo")
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+}
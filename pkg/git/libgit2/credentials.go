@@ -0,0 +1,77 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+// credentialsCache caches the Credentials returned by a CredentialsProvider
+// per URL, so that the connections made during a single checkout, for
+// example to list the references of the remote and then fetch from it, do
+// not request new credentials every time.
+type credentialsCache struct {
+	provider git.CredentialsProvider
+	now      func() time.Time
+
+	mu    sync.Mutex
+	creds map[string]git.Credentials
+}
+
+// withCredentialsCache returns a copy of the given AuthOptions with their
+// CredentialsProvider wrapped by a new credentialsCache. It must be called
+// once per checkout, as the cache lives as long as the returned options.
+func withCredentialsCache(opts *git.AuthOptions) *git.AuthOptions {
+	if opts == nil || opts.CredentialsProvider == nil {
+		return opts
+	}
+	cache := &credentialsCache{
+		provider: opts.CredentialsProvider,
+		now:      time.Now,
+		creds:    make(map[string]git.Credentials),
+	}
+	cachedOpts := *opts
+	cachedOpts.CredentialsProvider = cache.credentials
+	return &cachedOpts
+}
+
+// credentials returns the cached Credentials for the URL of the request if
+// they have not expired, or requests them from the provider. Credentials
+// are always requested again when the remote rejected them.
+func (c *credentialsCache) credentials(ctx context.Context, req git.CredentialsRequest) (git.Credentials, error) {
+	// The lock is held while calling the provider, so that concurrent
+	// connections to the same remote wait for the same credentials.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if creds, ok := c.creds[req.URL]; ok && !req.Reauthenticate {
+		if creds.ExpiresAt.IsZero() || c.now().Before(creds.ExpiresAt) {
+			return creds, nil
+		}
+	}
+	creds, err := c.provider(ctx, req)
+	if err != nil {
+		delete(c.creds, req.URL)
+		return git.Credentials{}, err
+	}
+	c.creds[req.URL] = creds
+	return creds, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func Test_withCredentialsCache(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(withCredentialsCache(nil)).To(BeNil())
+	opts := &git.AuthOptions{Username: "user"}
+	g.Expect(withCredentialsCache(opts)).To(BeIdenticalTo(opts))
+
+	var calls int
+	opts.CredentialsProvider = func(context.Context, git.CredentialsRequest) (git.Credentials, error) {
+		calls++
+		return git.Credentials{Password: fmt.Sprintf("token-%d", calls)}, nil
+	}
+	cached := withCredentialsCache(opts)
+	g.Expect(cached).ToNot(BeIdenticalTo(opts))
+	g.Expect(cached.Username).To(Equal(opts.Username))
+
+	req := git.CredentialsRequest{URL: "https://example.com/repo.git"}
+	for i := 0; i < 2; i++ {
+		creds, err := cached.CredentialsProvider(context.TODO(), req)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(creds.Password).To(Equal("token-1"))
+	}
+	g.Expect(calls).To(Equal(1))
+
+	// Another checkout does not share the cache.
+	creds, err := withCredentialsCache(opts).CredentialsProvider(context.TODO(), req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(creds.Password).To(Equal("token-2"))
+}
+
+func Test_credentialsCache_credentials(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	url := "https://example.com/repo.git"
+
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		elapsed   time.Duration
+		second    git.CredentialsRequest
+		errs      []error
+		wantCalls int
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:      "reuses credentials for the same URL",
+			second:    git.CredentialsRequest{URL: url},
+			wantCalls: 1,
+			wantToken: "token-1",
+		},
+		{
+			name:      "reuses credentials which have not expired",
+			expiresIn: time.Minute,
+			elapsed:   30 * time.Second,
+			second:    git.CredentialsRequest{URL: url},
+			wantCalls: 1,
+			wantToken: "token-1",
+		},
+		{
+			name:      "refreshes expired credentials",
+			expiresIn: time.Minute,
+			elapsed:   time.Minute,
+			second:    git.CredentialsRequest{URL: url},
+			wantCalls: 2,
+			wantToken: "token-2",
+		},
+		{
+			name:      "refreshes rejected credentials",
+			second:    git.CredentialsRequest{URL: url, Reauthenticate: true},
+			wantCalls: 2,
+			wantToken: "token-2",
+		},
+		{
+			name:      "requests credentials per URL",
+			second:    git.CredentialsRequest{URL: "https://example.com/submodule.git"},
+			wantCalls: 2,
+			wantToken: "token-2",
+		},
+		{
+			name:      "does not cache errors",
+			second:    git.CredentialsRequest{URL: url},
+			errs:      []error{errors.New("token endpoint unavailable")},
+			wantCalls: 2,
+			wantToken: "token-2",
+		},
+		{
+			name:      "forgets credentials when refreshing fails",
+			second:    git.CredentialsRequest{URL: url, Reauthenticate: true},
+			errs:      []error{nil, errors.New("token endpoint unavailable")},
+			wantCalls: 2,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var calls int
+			cache := &credentialsCache{
+				provider: func(_ context.Context, req git.CredentialsRequest) (git.Credentials, error) {
+					calls++
+					if calls <= len(tt.errs) && tt.errs[calls-1] != nil {
+						return git.Credentials{}, tt.errs[calls-1]
+					}
+					creds := git.Credentials{Username: "x-access-token", Password: fmt.Sprintf("token-%d", calls)}
+					if tt.expiresIn > 0 {
+						creds.ExpiresAt = now.Add(tt.expiresIn)
+					}
+					return creds, nil
+				},
+				now:   func() time.Time { return now },
+				creds: make(map[string]git.Credentials),
+			}
+
+			_, _ = cache.credentials(context.TODO(), git.CredentialsRequest{URL: url})
+			now = now.Add(tt.elapsed)
+			creds, err := cache.credentials(context.TODO(), tt.second)
+			g.Expect(calls).To(Equal(tt.wantCalls))
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(cache.creds).ToNot(HaveKey(url))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(creds.Password).To(Equal(tt.wantToken))
+		})
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"fmt"
+
+	git2go "github.com/libgit2/git2go/v33"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+	"github.com/fluxcd/source-controller/pkg/git/libgit2/managed"
+)
+
+// AheadBehind opens the repository at the given path, and returns the number
+// of commits branch is ahead and behind of base. Both branches are resolved
+// as local or remote-tracking branches of the default remote, and fetched
+// from the remote at the given URL using the given AuthOptions if they do not
+// exist. It returns git.ErrNoMergeBase if the branches do not share a common
+// ancestor.
+func AheadBehind(ctx context.Context, path, url string, opts *git.AuthOptions, branch, base string) (ahead, behind int, err error) {
+	defer recoverPanic(&err)
+
+	repo, err := git2go.OpenRepository(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to open repository at '%s': %w", path, libGit2Error(err))
+	}
+	defer repo.Free()
+
+	var missing []string
+	for _, b := range []string{branch, base} {
+		oid, err := lookupBranchTarget(repo, b)
+		if err != nil {
+			return 0, 0, err
+		}
+		if oid == nil {
+			missing = append(missing, b)
+		}
+	}
+	if len(missing) > 0 {
+		if err = fetchBranches(ctx, path, url, opts, missing...); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	oids := make([]*git2go.Oid, 0, 2)
+	for _, b := range []string{branch, base} {
+		oid, err := lookupBranchTarget(repo, b)
+		if err != nil {
+			return 0, 0, err
+		}
+		if oid == nil {
+			return 0, 0, fmt.Errorf("unable to find branch '%s'", b)
+		}
+		oids = append(oids, oid)
+	}
+	local, upstream := oids[0], oids[1]
+
+	if _, err = repo.MergeBase(local, upstream); err != nil {
+		if git2go.IsErrorCode(err, git2go.ErrorCodeNotFound) {
+			return 0, 0, fmt.Errorf("unable to compare branch '%s' to '%s': %w", branch, base, git.ErrNoMergeBase)
+		}
+		return 0, 0, fmt.Errorf("unable to find merge base of '%s' and '%s': %w", branch, base, libGit2Error(err))
+	}
+
+	ahead, behind, err = repo.AheadBehind(local, upstream)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to compare branch '%s' to '%s': %w", branch, base, libGit2Error(err))
+	}
+	return ahead, behind, nil
+}
+
+// lookupBranchTarget returns the commit the local or remote-tracking branch
+// with the given name points to, in that order of preference. It returns nil
+// if neither exists.
+func lookupBranchTarget(repo *git2go.Repository, name string) (*git2go.Oid, error) {
+	for _, ref := range []string{"refs/heads/" + name, fmt.Sprintf("refs/remotes/%s/%s", defaultRemoteName, name)} {
+		r, err := repo.References.Lookup(ref)
+		if err != nil {
+			if git2go.IsErrorCode(err, git2go.ErrorCodeNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("unable to lookup branch '%s': %w", name, libGit2Error(err))
+		}
+		defer r.Free()
+
+		resolved, err := r.Resolve()
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve branch '%s': %w", name, libGit2Error(err))
+		}
+		defer resolved.Free()
+		return resolved.Target(), nil
+	}
+	return nil, nil
+}
+
+// fetchBranches fetches the given branches from the remote at the given URL
+// into the remote-tracking branches of the default remote of the repository
+// at the given path.
+func fetchBranches(ctx context.Context, path, url string, opts *git.AuthOptions, branches ...string) error {
+	opts, err := registerManagedTransportOptions(ctx, url, opts)
+	if err != nil {
+		return err
+	}
+	defer managed.RemoveTransportOptions(opts.TransportOptionsURL)
+
+	repo, remote, err := initializeRepoWithRemote(ctx, path, url, opts)
+	if err != nil {
+		return err
+	}
+	defer repo.Free()
+	defer remote.Free()
+
+	refspecs := make([]string, 0, len(branches))
+	for _, b := range branches {
+		refspecs = append(refspecs, fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", b, defaultRemoteName, b))
+	}
+	err = fetchOrDisconnect(ctx, remote, refspecs, &git2go.FetchOptions{
+		DownloadTags:    git2go.DownloadTagsNone,
+		RemoteCallbacks: remoteCallbacks(ctx),
+	})
+	if err != nil {
+		return contextError(ctx, url, fmt.Errorf("unable to fetch branches from '%s': %w", url, err))
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	git2go "github.com/libgit2/git2go/v33"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func TestAheadBehind(t *testing.T) {
+	g := NewWithT(t)
+
+	repo, err := initBareRepo(t)
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+
+	_, err = commitFile(repo, "base", "base", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(createBranch(repo, "feature", nil)).To(Succeed())
+
+	// Diverge feature from master.
+	g.Expect(repo.SetHead("refs/heads/feature")).To(Succeed())
+	for _, f := range []string{"feature-1", "feature-2"} {
+		_, err = commitFile(repo, f, f, time.Now())
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(repo.SetHead("refs/heads/master")).To(Succeed())
+	_, err = commitFile(repo, "master", "master", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Create a branch without history in common with master.
+	g.Expect(repo.SetHead("refs/heads/orphan")).To(Succeed())
+	_, err = commitFile(repo, "orphan", "orphan", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tests := []struct {
+		name       string
+		branch     string
+		base       string
+		wantAhead  int
+		wantBehind int
+		wantErr    error
+	}{
+		{
+			name:       "diverged branch",
+			branch:     "feature",
+			base:       "master",
+			wantAhead:  2,
+			wantBehind: 1,
+		},
+		{
+			name:       "reversed",
+			branch:     "master",
+			base:       "feature",
+			wantAhead:  1,
+			wantBehind: 2,
+		},
+		{
+			name:    "no common ancestor",
+			branch:  "orphan",
+			base:    "master",
+			wantErr: git.ErrNoMergeBase,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ahead, behind, err := AheadBehind(context.TODO(), repo.Path(), "", nil, tt.branch, tt.base)
+			if tt.wantErr != nil {
+				g.Expect(errors.Is(err, tt.wantErr)).To(BeTrue())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(ahead).To(Equal(tt.wantAhead))
+			g.Expect(behind).To(Equal(tt.wantBehind))
+		})
+	}
+}
+
+func TestAheadBehind_fetch(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	repo, err := git2go.InitRepository(filepath.Join(server.Root(), repoPath), true)
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+
+	_, err = commitFile(repo, "base", "base", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(createBranch(repo, "feature", nil)).To(Succeed())
+	g.Expect(repo.SetHead("refs/heads/feature")).To(Succeed())
+	for _, f := range []string{"feature-1", "feature-2", "feature-3"} {
+		_, err = commitFile(repo, f, f, time.Now())
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(repo.SetHead("refs/heads/master")).To(Succeed())
+
+	// Only the base branch is fetched by the checkout.
+	repoURL := server.HTTPAddress() + "/" + repoPath
+	tmpDir := t.TempDir()
+	authOpts := &git.AuthOptions{TransportOptionsURL: getTransportOptionsURL(git.HTTP)}
+	_, err = (&CheckoutBranch{Branch: "master"}).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	checkout, err := git2go.OpenRepository(tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	defer checkout.Free()
+	oid, err := lookupBranchTarget(checkout, "feature")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(oid).To(BeNil())
+
+	ahead, behind, err := AheadBehind(context.TODO(), tmpDir, repoURL, authOpts, "feature", "master")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ahead).To(Equal(3))
+	g.Expect(behind).To(Equal(0))
+
+	oid, err = lookupBranchTarget(checkout, "feature")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(oid).ToNot(BeNil())
+}
@@ -16,8 +16,52 @@ limitations under the License.
 
 package libgit2
 
-import "github.com/fluxcd/source-controller/pkg/git"
+import (
+	"errors"
+	"strings"
+
+	"github.com/fluxcd/pkg/gitutil"
+	git2go "github.com/libgit2/git2go/v33"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
 
 const (
 	Implementation git.Implementation = "libgit2"
 )
+
+// transportErrors are the errors returned by the managed transports which
+// callers may want to match on. As errors lose their identity when passed
+// through libgit2, they are matched on their message instead.
+var transportErrors = []error{
+	git.ErrPackChecksumMismatch,
+}
+
+// libGit2Error translates an error from the libgit2 library into a
+// git.GitError carrying the class and code of the error, or returns
+// `nil` if the argument is `nil`. Errors which do not originate from
+// libgit2 are translated by gitutil.LibGit2Error.
+// When the error originates from one of the transportErrors, the
+// git.GitError wraps it instead of the libgit2 error.
+func libGit2Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	var gitErr *git2go.GitError
+	if !errors.As(err, &gitErr) {
+		return gitutil.LibGit2Error(err)
+	}
+	wrapped := err
+	for _, tErr := range transportErrors {
+		if strings.Contains(gitErr.Message, tErr.Error()) {
+			wrapped = tErr
+			break
+		}
+	}
+	return &git.GitError{
+		Message: gitutil.LibGit2Error(err).Error(),
+		Class:   int(gitErr.Class),
+		Code:    int(gitErr.Code),
+		Err:     wrapped,
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	git2go "github.com/libgit2/git2go/v33"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func Test_libGit2Error(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(libGit2Error(nil)).To(BeNil())
+
+	plainErr := errors.New("plain error")
+	g.Expect(libGit2Error(plainErr)).To(Equal(plainErr))
+
+	underlying := &git2go.GitError{
+		Message: "remote:\nremote: authentication required\nremote:",
+		Class:   git2go.ErrorClassNet,
+		Code:    git2go.ErrorCodeAuth,
+	}
+	err := fmt.Errorf("unable to fetch: %w", libGit2Error(underlying))
+
+	var gitErr *git.GitError
+	g.Expect(errors.As(err, &gitErr)).To(BeTrue())
+	g.Expect(gitErr.Message).To(Equal("remote: authentication required"))
+	g.Expect(gitErr.Class).To(Equal(int(git2go.ErrorClassNet)))
+	g.Expect(gitErr.Code).To(Equal(int(git2go.ErrorCodeAuth)))
+	g.Expect(err.Error()).To(Equal("unable to fetch: remote: authentication required"))
+
+	var libErr *git2go.GitError
+	g.Expect(errors.As(err, &libErr)).To(BeTrue())
+	g.Expect(libErr).To(Equal(underlying))
+}
+
+func Test_libGit2Error_transportError(t *testing.T) {
+	g := NewWithT(t)
+
+	underlying := &git2go.GitError{
+		Message: "pack checksum mismatch: expected 'a', got 'b'",
+		Class:   git2go.ErrorClassNet,
+		Code:    git2go.ErrorCodeUser,
+	}
+	err := libGit2Error(underlying)
+	g.Expect(errors.Is(err, git.ErrPackChecksumMismatch)).To(BeTrue())
+	g.Expect(err.Error()).To(Equal(underlying.Message))
+
+	var gitErr *git.GitError
+	g.Expect(errors.As(err, &gitErr)).To(BeTrue())
+	g.Expect(gitErr.Code).To(Equal(int(git2go.ErrorCodeUser)))
+}
@@ -121,7 +121,10 @@ func (t *httpSmartSubtransport) Action(transportOptionsURL string, action git2go
 	if !found {
 		return nil, fmt.Errorf("failed to create client: could not find transport options for the object: %s", transportOptionsURL)
 	}
-	targetURL := opts.TargetURL
+	targetURL, err := opts.dialURL()
+	if err != nil {
+		return nil, err
+	}
 
 	if targetURL == "" {
 		return nil, fmt.Errorf("repository URL cannot be empty")
@@ -152,6 +155,13 @@ func (t *httpSmartSubtransport) Action(transportOptionsURL string, action git2go
 		t.httpTransport.Proxy = nil
 	}
 	t.httpTransport.DisableCompression = false
+	if httpOpts := opts.HTTPTransport; httpOpts != nil {
+		if httpOpts.IdleConnTimeout > 0 {
+			t.httpTransport.IdleConnTimeout = httpOpts.IdleConnTimeout
+		}
+		t.httpTransport.ResponseHeaderTimeout = httpOpts.ResponseHeaderTimeout
+		t.httpTransport.DisableKeepAlives = httpOpts.DisableKeepAlives
+	}
 
 	t.once.Do(func() {
 		if opts.Context != nil {
@@ -161,6 +171,12 @@ func (t *httpSmartSubtransport) Action(transportOptionsURL string, action git2go
 				"url", opts.TargetURL)
 		}
 	})
+	if targetURL != opts.TargetURL && (action == git2go.SmartServiceActionUploadpackLs ||
+		action == git2go.SmartServiceActionReceivepackLs) {
+		// show as info once per connection, as this should be visible
+		// regardless of the chosen log-level.
+		t.logger.Info("rewrote remote URL", "newUrl", targetURL)
+	}
 
 	client, req, err := createClientRequest(targetURL, action, t.httpTransport, opts.AuthOpts)
 	if err != nil {
@@ -168,6 +184,22 @@ func (t *httpSmartSubtransport) Action(transportOptionsURL string, action git2go
 	}
 
 	stream := newManagedHttpStream(t, req, client)
+	if opts.Context != nil {
+		stream.ctx = opts.Context
+	}
+	if action == git2go.SmartServiceActionUploadpack {
+		stream.packChecksumHeader = opts.PackChecksumHeader
+	}
+	if opts.AuthOpts != nil && opts.AuthOpts.CredentialsProvider != nil {
+		stream.credentialsProvider = opts.AuthOpts.CredentialsProvider
+		stream.credentialsRequest = git.CredentialsRequest{
+			URL:      opts.TargetURL,
+			Username: opts.AuthOpts.Username,
+		}
+		if err = stream.authenticate(false); err != nil {
+			return nil, err
+		}
+	}
 	if req.Method == "POST" {
 		stream.recvReply.Add(1)
 		stream.sendRequestBackground()
@@ -327,6 +359,18 @@ type httpSmartSubtransportStream struct {
 	recvReply   sync.WaitGroup
 	httpError   error
 	m           sync.RWMutex
+	// packChecksumHeader is the name of the response header holding the
+	// checksum of the packfile in the response body.
+	packChecksumHeader string
+	// credentialsProvider returns the credentials the request is
+	// authenticated with, overriding any static credentials.
+	credentialsProvider git.CredentialsProvider
+	// credentialsRequest describes the remote to the credentialsProvider.
+	credentialsRequest git.CredentialsRequest
+	// ctx bounds the requests of the stream. It is the Context of the
+	// transport options at the time of the action, which may differ from
+	// the one of the owner, for example while a fetch has a timeout.
+	ctx context.Context
 }
 
 func newManagedHttpStream(owner *httpSmartSubtransport, req *http.Request, client *http.Client) *httpSmartSubtransportStream {
@@ -337,6 +381,7 @@ func newManagedHttpStream(owner *httpSmartSubtransport, req *http.Request, clien
 		req:    req,
 		reader: r,
 		writer: w,
+		ctx:    owner.ctx,
 	}
 }
 
@@ -394,6 +439,23 @@ func (self *httpSmartSubtransportStream) Free() {
 	}
 }
 
+// authenticate sets the credentials returned by the credentialsProvider on
+// the request. When reauthenticate is true, the previous credentials were
+// rejected by the remote.
+func (self *httpSmartSubtransportStream) authenticate(reauthenticate bool) error {
+	credsReq := self.credentialsRequest
+	credsReq.Reauthenticate = reauthenticate
+	creds, err := self.credentialsProvider(self.ctx, credsReq)
+	if err != nil {
+		return fmt.Errorf("failed to get credentials for '%s': %w", credsReq.URL, err)
+	}
+	self.req.Header.Del("Authorization")
+	if creds.Username != "" || creds.Password != "" {
+		self.req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	return nil
+}
+
 func (self *httpSmartSubtransportStream) sendRequestBackground() {
 	go func() {
 		err := self.sendRequest()
@@ -412,6 +474,7 @@ func (self *httpSmartSubtransportStream) sendRequest() error {
 	var resp *http.Response
 	var err error
 	var content []byte
+	var reauthenticated bool
 
 	for {
 		req := &http.Request{
@@ -419,7 +482,7 @@ func (self *httpSmartSubtransportStream) sendRequest() error {
 			URL:    self.req.URL,
 			Header: self.req.Header,
 		}
-		req = req.WithContext(self.owner.ctx)
+		req = req.WithContext(self.ctx)
 
 		if req.Method == "POST" {
 			if len(content) == 0 {
@@ -459,8 +522,31 @@ func (self *httpSmartSubtransportStream) sendRequest() error {
 			continue
 		}
 
+		// The credentials may have expired since they were provided, ask
+		// for new ones once.
+		if resp.StatusCode == http.StatusUnauthorized && self.credentialsProvider != nil && !reauthenticated {
+			_, _ = io.Copy(io.Discard, resp.Body) // errors can be safely ignored
+			if err := resp.Body.Close(); err != nil {
+				return err
+			}
+
+			if err := self.authenticate(true); err != nil {
+				return err
+			}
+			reauthenticated = true
+			continue
+		}
+
 		// for HTTP 200, the response will be cleared up by Free()
 		if resp.StatusCode == http.StatusOK {
+			if checksum := resp.Header.Get(self.packChecksumHeader); self.packChecksumHeader != "" && checksum != "" {
+				body, err := newPackChecksumReader(resp.Body, checksum)
+				if err != nil {
+					_ = resp.Body.Close()
+					return err
+				}
+				resp.Body = body
+			}
 			break
 		}
 
@@ -471,6 +557,11 @@ func (self *httpSmartSubtransportStream) sendRequest() error {
 			return err
 		}
 
+		// The delay requested by a rate limiting server is included in the
+		// error, as its identity is lost when passed through libgit2.
+		if retryAfter := resp.Header.Get("Retry-After"); resp.StatusCode == http.StatusTooManyRequests && retryAfter != "" {
+			return fmt.Errorf("unhandled HTTP error %s (Retry-After: %s)", resp.Status, retryAfter)
+		}
 		return fmt.Errorf("unhandled HTTP error %s", resp.Status)
 	}
 
@@ -17,14 +17,21 @@ limitations under the License.
 package managed
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/fluxcd/pkg/gittestserver"
 	"github.com/fluxcd/source-controller/pkg/git"
+	"github.com/go-logr/logr"
 	. "github.com/onsi/gomega"
 
 	git2go "github.com/libgit2/git2go/v33"
@@ -290,3 +297,207 @@ func TestHTTP_HandleRedirect(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTP_CredentialsProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pwd, ok := r.BasicAuth(); !ok || user != "user" || pwd != "token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	newStream := func(provider git.CredentialsProvider) (*httpSmartSubtransportStream, error) {
+		owner := &httpSmartSubtransport{ctx: context.TODO(), logger: logr.Discard()}
+		authOpts := &git.AuthOptions{Username: "user", Password: "static", CredentialsProvider: provider}
+		client, req, err := createClientRequest(server.URL, git2go.SmartServiceActionUploadpackLs, &http.Transport{}, authOpts)
+		if err != nil {
+			return nil, err
+		}
+		stream := newManagedHttpStream(owner, req, client)
+		stream.credentialsProvider = provider
+		stream.credentialsRequest = git.CredentialsRequest{URL: server.URL, Username: authOpts.Username}
+		if err = stream.authenticate(false); err != nil {
+			return nil, err
+		}
+		stream.recvReply.Add(1)
+		return stream, stream.sendRequest()
+	}
+
+	t.Run("rotates rejected credentials", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var calls int32
+		var reqs []git.CredentialsRequest
+		stream, err := newStream(func(_ context.Context, req git.CredentialsRequest) (git.Credentials, error) {
+			reqs = append(reqs, req)
+			n := atomic.AddInt32(&calls, 1)
+			return git.Credentials{Username: req.Username, Password: fmt.Sprintf("token-%d", n)}, nil
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		defer stream.Free()
+		g.Expect(stream.resp.StatusCode).To(Equal(http.StatusOK))
+		g.Expect(reqs).To(Equal([]git.CredentialsRequest{
+			{URL: server.URL, Username: "user"},
+			{URL: server.URL, Username: "user", Reauthenticate: true},
+		}))
+	})
+
+	t.Run("reauthenticates once", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var calls int32
+		_, err := newStream(func(_ context.Context, req git.CredentialsRequest) (git.Credentials, error) {
+			atomic.AddInt32(&calls, 1)
+			return git.Credentials{Username: req.Username, Password: "expired"}, nil
+		})
+		g.Expect(err).To(MatchError(ContainSubstring("401 Unauthorized")))
+		g.Expect(calls).To(BeEquivalentTo(2))
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		g := NewWithT(t)
+
+		_, err := newStream(func(_ context.Context, _ git.CredentialsRequest) (git.Credentials, error) {
+			return git.Credentials{}, errors.New("token service unavailable")
+		})
+		g.Expect(err).To(MatchError(ContainSubstring("token service unavailable")))
+	})
+}
+
+func TestHTTPAction_HTTPTransport(t *testing.T) {
+	tests := []struct {
+		name                      string
+		httpTransport             *git.HTTPTransportOptions
+		wantIdleConnTimeout       time.Duration
+		wantResponseHeaderTimeout time.Duration
+		wantDisableKeepAlives     bool
+	}{
+		{
+			name:                "defaults",
+			wantIdleConnTimeout: 60 * time.Second,
+		},
+		{
+			name: "tuned",
+			httpTransport: &git.HTTPTransportOptions{
+				IdleConnTimeout:       5 * time.Second,
+				ResponseHeaderTimeout: 30 * time.Second,
+				DisableKeepAlives:     true,
+			},
+			wantIdleConnTimeout:       5 * time.Second,
+			wantResponseHeaderTimeout: 30 * time.Second,
+			wantDisableKeepAlives:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			id := "http://obj-id-http-transport"
+			AddTransportOptions(id, TransportOptions{
+				TargetURL:     "https://example.com/repo",
+				HTTPTransport: tt.httpTransport,
+			})
+			defer RemoveTransportOptions(id)
+
+			st, err := httpSmartSubtransportFactory(nil, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			sst := st.(*httpSmartSubtransport)
+			defer sst.Free()
+
+			_, err = sst.Action(id, git2go.SmartServiceActionUploadpackLs)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(sst.httpTransport.IdleConnTimeout).To(Equal(tt.wantIdleConnTimeout))
+			g.Expect(sst.httpTransport.ResponseHeaderTimeout).To(Equal(tt.wantResponseHeaderTimeout))
+			g.Expect(sst.httpTransport.DisableKeepAlives).To(Equal(tt.wantDisableKeepAlives))
+		})
+	}
+}
+
+func TestHTTPAction_URLRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite git.URLRewriteFunc
+		wantURL string
+		wantErr string
+	}{
+		{
+			name:    "without rewrite",
+			wantURL: "https://github.com/org/repo/info/refs?service=git-upload-pack",
+		},
+		{
+			name: "rewrites to mirror",
+			rewrite: func(url string) (string, error) {
+				return strings.Replace(url, "https://github.com/", "https://mirror.example.com/github/", 1), nil
+			},
+			wantURL: "https://mirror.example.com/github/org/repo/info/refs?service=git-upload-pack",
+		},
+		{
+			name: "rewrite error",
+			rewrite: func(url string) (string, error) {
+				return "", errors.New("no mirror configured")
+			},
+			wantErr: "unable to rewrite URL 'https://github.com/org/repo': no mirror configured",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			id := "http://obj-id-url-rewrite"
+			AddTransportOptions(id, TransportOptions{
+				TargetURL:  "https://github.com/org/repo",
+				URLRewrite: tt.rewrite,
+			})
+			defer RemoveTransportOptions(id)
+
+			st, err := httpSmartSubtransportFactory(nil, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			sst := st.(*httpSmartSubtransport)
+			defer sst.Free()
+
+			stream, err := sst.Action(id, git2go.SmartServiceActionUploadpackLs)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(stream.(*httpSmartSubtransportStream).req.URL.String()).To(Equal(tt.wantURL))
+
+			// The target URL identifies the remote, and is not rewritten.
+			opts, found := getTransportOptions(id)
+			g.Expect(found).To(BeTrue())
+			g.Expect(opts.TargetURL).To(Equal("https://github.com/org/repo"))
+		})
+	}
+}
+
+func TestHTTPAction_Context(t *testing.T) {
+	g := NewWithT(t)
+
+	id := "http://obj-id-context"
+	AddTransportOptions(id, TransportOptions{
+		TargetURL: "https://example.com/repo",
+		Context:   context.Background(),
+	})
+	defer RemoveTransportOptions(id)
+
+	st, err := httpSmartSubtransportFactory(nil, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	sst := st.(*httpSmartSubtransport)
+	defer sst.Free()
+
+	_, err = sst.Action(id, git2go.SmartServiceActionUploadpackLs)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Actions after the context was replaced, for example to bound a fetch
+	// by a timeout, are bound to the new context.
+	fetchCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	restore := SetTransportOptionsContext(fetchCtx, id)
+	stream, err := sst.Action(id, git2go.SmartServiceActionUploadpack)
+	restore()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(stream.(*httpSmartSubtransportStream).ctx).To(Equal(fetchCtx))
+}
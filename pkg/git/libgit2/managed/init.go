@@ -35,6 +35,16 @@ var (
 	// handshake, put/get).
 	fullHttpClientTimeOut time.Duration = 10 * time.Minute
 
+	// transportOptionsTTL defines the age after which registered
+	// transport options are considered stale by SweepTransportOptions.
+	// It exceeds the duration of any git operation, which is bounded by
+	// fullHttpClientTimeOut for HTTP.
+	transportOptionsTTL time.Duration = time.Hour
+
+	// transportOptionsSweepInterval defines the interval at which
+	// SweepTransportOptions removes stale transport options.
+	transportOptionsSweepInterval time.Duration = 10 * time.Minute
+
 	enabled bool
 )
 
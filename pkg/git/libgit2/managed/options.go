@@ -18,7 +18,11 @@ package managed
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
 
 	"github.com/fluxcd/source-controller/pkg/git"
 	git2go "github.com/libgit2/git2go/v33"
@@ -31,12 +35,44 @@ type TransportOptions struct {
 	AuthOpts     *git.AuthOptions
 	ProxyOptions *git2go.ProxyOptions
 	Context      context.Context
+	// PackChecksumHeader is the name of the HTTP response header holding the
+	// checksum to verify received packfiles against.
+	PackChecksumHeader string
+	// HostKeyAlgos are the host key algorithms accepted from the SSH
+	// server, overriding git.HostKeyAlgos when set.
+	HostKeyAlgos []string
+	// HTTPTransport tunes the connections of the HTTP transport. When nil,
+	// the defaults of the transport pool apply.
+	HTTPTransport *git.HTTPTransportOptions
+	// URLRewrite rewrites the TargetURL right before the transport connects
+	// to it. The TargetURL itself is kept, as the remote the credentials
+	// are requested for.
+	URLRewrite git.URLRewriteFunc
+}
+
+// dialURL returns the URL the transport connects to, which is the TargetURL
+// rewritten by URLRewrite when it is set.
+func (o *TransportOptions) dialURL() (string, error) {
+	if o.URLRewrite == nil {
+		return o.TargetURL, nil
+	}
+	u, err := o.URLRewrite(o.TargetURL)
+	if err != nil {
+		return "", fmt.Errorf("unable to rewrite URL '%s': %w", o.TargetURL, err)
+	}
+	return u, nil
 }
 
 var (
 	// transportOpts maps a unique URL to a set of transport options.
 	transportOpts = make(map[string]TransportOptions, 0)
-	m             sync.RWMutex
+	// transportOptsUsed maps the same URLs to the time their options were
+	// registered or last looked up at, for RemoveStaleTransportOptions.
+	transportOptsUsed = make(map[string]time.Time, 0)
+	// transportOptsAliases maps additional URLs to the URL of the transport
+	// options they resolve to, see AliasTransportOptions.
+	transportOptsAliases = make(map[string]string, 0)
+	m                    sync.RWMutex
 )
 
 // AddTransportOptions registers a TransportOptions object mapped to the
@@ -47,21 +83,134 @@ var (
 func AddTransportOptions(transportOptsURL string, opts TransportOptions) {
 	m.Lock()
 	transportOpts[transportOptsURL] = opts
+	transportOptsUsed[transportOptsURL] = time.Now()
 	m.Unlock()
 }
 
 // RemoveTransportOptions removes the registerd TransportOptions object
-// mapped to the provided id.
+// mapped to the provided id, and any aliases of it.
 func RemoveTransportOptions(transportOptsURL string) {
 	m.Lock()
-	delete(transportOpts, transportOptsURL)
+	removeTransportOptionsLocked(transportOptsURL)
+	m.Unlock()
+}
+
+// AliasTransportOptions makes the TransportOptions mapped to the provided
+// transportOptsURL available under the alias as well, until they are
+// removed. It allows a remote configured with a URL which only differs by
+// user information to use the options, without rewriting its configuration.
+func AliasTransportOptions(alias, transportOptsURL string) {
+	if alias == transportOptsURL {
+		return
+	}
+	m.Lock()
+	transportOptsAliases[alias] = transportOptsURL
 	m.Unlock()
 }
 
+func removeTransportOptionsLocked(transportOptsURL string) {
+	delete(transportOpts, transportOptsURL)
+	delete(transportOptsUsed, transportOptsURL)
+	delete(transportOptsAliases, transportOptsURL)
+	for alias, target := range transportOptsAliases {
+		if target == transportOptsURL {
+			delete(transportOptsAliases, alias)
+		}
+	}
+}
+
+// SetTransportOptionsContext replaces the Context of the TransportOptions
+// mapped to the provided transportOptsURL, and returns a function restoring
+// the previous Context. It bounds the transports created while it is set by
+// the given context, for example to time out a single operation.
+func SetTransportOptionsContext(ctx context.Context, transportOptsURL string) func() {
+	m.Lock()
+	key := resolveTransportOptionsURLLocked(transportOptsURL)
+	defer m.Unlock()
+	opts, found := transportOpts[key]
+	if !found {
+		return func() {}
+	}
+	previous := opts.Context
+	opts.Context = ctx
+	transportOpts[key] = opts
+	return func() {
+		m.Lock()
+		defer m.Unlock()
+		if opts, found := transportOpts[key]; found {
+			opts.Context = previous
+			transportOpts[key] = opts
+		}
+	}
+}
+
+// RemoveStaleTransportOptions removes all TransportOptions which have not
+// been used for longer than the given TTL, and returns the number of removed
+// entries. Options with a Context which is not done yet belong to an operation
+// still in progress, and are never removed. It is a safety net for options
+// which, for example due to a bug, were never removed with
+// RemoveTransportOptions.
+func RemoveStaleTransportOptions(ttl time.Duration) int {
+	cutoff := time.Now().Add(-ttl)
+	m.Lock()
+	defer m.Unlock()
+	var removed int
+	for key, used := range transportOptsUsed {
+		if used.After(cutoff) {
+			continue
+		}
+		if ctx := transportOpts[key].Context; ctx != nil && ctx.Err() == nil {
+			continue
+		}
+		removeTransportOptionsLocked(key)
+		removed++
+	}
+	return removed
+}
+
+// SweepTransportOptions periodically removes the TransportOptions which have
+// not been used for an hour, and do not belong to an operation in progress,
+// until the context is done. It can be added to a controller-runtime manager
+// as a manager.RunnableFunc.
+func SweepTransportOptions(ctx context.Context) error {
+	sweepTransportOptions(ctx, transportOptionsSweepInterval, transportOptionsTTL)
+	return nil
+}
+
+// sweepTransportOptions calls RemoveStaleTransportOptions with the given TTL
+// once every interval, until the context is done.
+func sweepTransportOptions(ctx context.Context, interval, ttl time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n := RemoveStaleTransportOptions(ttl); n > 0 {
+				logr.FromContextOrDiscard(ctx).Info("removed stale managed transport options", "count", n)
+			}
+		}
+	}
+}
+
+// resolveTransportOptionsURLLocked returns the URL the TransportOptions for
+// the provided transportOptsURL are mapped to, which differs for an alias.
+func resolveTransportOptionsURLLocked(transportOptsURL string) string {
+	if target, ok := transportOptsAliases[transportOptsURL]; ok {
+		return target
+	}
+	return transportOptsURL
+}
+
 func getTransportOptions(transportOptsURL string) (*TransportOptions, bool) {
-	m.RLock()
-	opts, found := transportOpts[transportOptsURL]
-	m.RUnlock()
+	m.Lock()
+	key := resolveTransportOptionsURLLocked(transportOptsURL)
+	opts, found := transportOpts[key]
+	if found {
+		transportOptsUsed[key] = time.Now()
+	}
+	m.Unlock()
 
 	if found {
 		return &opts, true
@@ -0,0 +1,178 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managed
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+const (
+	// sideBandPackData is the side-band channel carrying packfile data.
+	sideBandPackData = 1
+	// packTrailerSize is the size of the SHA-1 trailer of a packfile.
+	packTrailerSize = sha1.Size
+)
+
+// packChecksumReader passes through a git-upload-pack response, while
+// extracting the packfile it contains from the (side-band multiplexed)
+// pkt-lines. Once the response has been read in full, it verifies the
+// checksum of the packfile matches the expected checksum, returning
+// git.ErrPackChecksumMismatch instead of io.EOF if it does not.
+// As the error is returned before libgit2 has finalized the pack, a
+// mismatching pack is never indexed.
+type packChecksumReader struct {
+	io.ReadCloser
+
+	expected []byte
+	hash     hash.Hash
+	// trailer holds back the last bytes of pack data, as the trailer of the
+	// packfile is not part of its checksum.
+	trailer []byte
+	packLen int64
+
+	// raw is set when the packfile is sent without side-band.
+	raw bool
+	// header holds a partially read pkt-line length.
+	header []byte
+	// remaining is the number of payload bytes left in the current pkt-line.
+	remaining int
+	// band is the side-band channel of the current pkt-line, or -1 if the
+	// first payload byte has not been read yet.
+	band int
+}
+
+// newPackChecksumReader returns a packChecksumReader for the given response
+// body, verifying the packfile against the given hex encoded SHA-1 checksum.
+func newPackChecksumReader(body io.ReadCloser, checksum string) (*packChecksumReader, error) {
+	expected, err := hex.DecodeString(checksum)
+	if err != nil || len(expected) != sha1.Size {
+		return nil, fmt.Errorf("invalid pack checksum '%s': must be a hex encoded SHA-1", checksum)
+	}
+	return &packChecksumReader{
+		ReadCloser: body,
+		expected:   expected,
+		hash:       sha1.New(),
+	}, nil
+}
+
+func (r *packChecksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if perr := r.process(p[:n]); perr != nil {
+			return n, perr
+		}
+	}
+	if err == io.EOF && r.packLen > 0 {
+		return n, r.verify()
+	}
+	return n, err
+}
+
+// process parses the given pkt-line data, and writes any pack data to the
+// hash.
+func (r *packChecksumReader) process(p []byte) error {
+	for len(p) > 0 {
+		if r.raw {
+			r.write(p)
+			return nil
+		}
+
+		if r.remaining == 0 {
+			k := 4 - len(r.header)
+			if k > len(p) {
+				k = len(p)
+			}
+			r.header = append(r.header, p[:k]...)
+			p = p[k:]
+			if len(r.header) < 4 {
+				return nil
+			}
+			header := r.header
+			r.header = r.header[:0]
+
+			// Without side-band, the packfile follows the negotiation
+			// pkt-lines as is.
+			if string(header) == "PACK" {
+				r.raw = true
+				r.write(header)
+				continue
+			}
+			length, err := strconv.ParseUint(string(header), 16, 16)
+			if err != nil {
+				return fmt.Errorf("%w: malformed pkt-line length '%s'", git.ErrPackChecksumMismatch, header)
+			}
+			// Flush, delimiter and response-end packets carry no payload.
+			if length < 4 {
+				continue
+			}
+			r.remaining = int(length) - 4
+			r.band = -1
+			continue
+		}
+
+		k := r.remaining
+		if k > len(p) {
+			k = len(p)
+		}
+		payload := p[:k]
+		p = p[k:]
+		r.remaining -= k
+
+		if r.band == -1 {
+			r.band = int(payload[0])
+			payload = payload[1:]
+		}
+		if r.band == sideBandPackData {
+			r.write(payload)
+		}
+	}
+	return nil
+}
+
+// write adds the given pack data to the hash, holding back the last bytes
+// as the potential trailer of the packfile.
+func (r *packChecksumReader) write(p []byte) {
+	r.packLen += int64(len(p))
+	buf := append(r.trailer, p...)
+	if len(buf) > packTrailerSize {
+		r.hash.Write(buf[:len(buf)-packTrailerSize])
+		buf = buf[len(buf)-packTrailerSize:]
+	}
+	r.trailer = append(r.trailer[:0], buf...)
+}
+
+// verify returns io.EOF if the checksum of the received packfile matches
+// both the expected checksum and its trailer, or an error otherwise.
+func (r *packChecksumReader) verify() error {
+	sum := r.hash.Sum(nil)
+	if !bytes.Equal(sum, r.trailer) {
+		return fmt.Errorf("%w: packfile trailer '%x' does not match its contents '%x'",
+			git.ErrPackChecksumMismatch, r.trailer, sum)
+	}
+	if !bytes.Equal(sum, r.expected) {
+		return fmt.Errorf("%w: expected '%x', got '%x'", git.ErrPackChecksumMismatch, r.expected, sum)
+	}
+	return io.EOF
+}
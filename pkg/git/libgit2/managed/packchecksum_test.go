@@ -0,0 +1,117 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package managed
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func TestPackChecksumReader(t *testing.T) {
+	pack := "PACK\x00\x00\x00\x02\x00\x00\x00\x01some object data"
+	sum := sha1.Sum([]byte(pack))
+	pack += string(sum[:])
+	checksum := hex.EncodeToString(sum[:])
+
+	pktLine := func(s string) string {
+		return fmt.Sprintf("%04x%s", len(s)+4, s)
+	}
+	sideBand := pktLine("NAK\n") +
+		pktLine("\x02Counting objects: 1, done.\n") +
+		pktLine("\x01"+pack[:10]) +
+		pktLine("\x01"+pack[10:]) +
+		"0000"
+	raw := pktLine("NAK\n") + pack
+
+	tests := []struct {
+		name     string
+		response string
+		checksum string
+		wantErr  string
+	}{
+		{
+			name:     "side-band pack matches checksum",
+			response: sideBand,
+			checksum: checksum,
+		},
+		{
+			name:     "raw pack matches checksum",
+			response: raw,
+			checksum: checksum,
+		},
+		{
+			name:     "response without pack",
+			response: pktLine("NAK\n") + "0000",
+			checksum: checksum,
+		},
+		{
+			name:     "pack does not match checksum",
+			response: sideBand,
+			checksum: strings.Repeat("0", 40),
+			wantErr:  "pack checksum mismatch: expected '0000000000000000000000000000000000000000', got '" + checksum + "'",
+		},
+		{
+			name:     "pack does not match trailer",
+			response: strings.Replace(sideBand, "some object", "evil object", 1),
+			checksum: checksum,
+			wantErr:  "pack checksum mismatch: packfile trailer",
+		},
+		{
+			name:     "invalid checksum",
+			response: sideBand,
+			checksum: "invalid",
+			wantErr:  "invalid pack checksum 'invalid'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			// Read one byte at a time to cover pkt-lines split over reads.
+			body := io.NopCloser(iotest.OneByteReader(strings.NewReader(tt.response)))
+			r, err := newPackChecksumReader(body, tt.checksum)
+			if err == nil {
+				var got []byte
+				got, err = io.ReadAll(r)
+				if err == nil {
+					g.Expect(string(got)).To(Equal(tt.response))
+				}
+			}
+
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				if !strings.HasPrefix(tt.wantErr, "invalid") {
+					g.Expect(errors.Is(err, git.ErrPackChecksumMismatch)).To(BeTrue())
+				}
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
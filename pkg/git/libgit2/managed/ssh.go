@@ -114,6 +114,8 @@ type sshSmartSubtransport struct {
 
 	closedSessions *int32
 
+	// conn is the connection of the client, which deadlines are set on.
+	conn          net.Conn
 	client        *ssh.Client
 	session       *ssh.Session
 	currentStream *sshSmartSubtransportStream
@@ -129,7 +131,11 @@ func (t *sshSmartSubtransport) Action(transportOptionsURL string, action git2go.
 		return nil, fmt.Errorf("could not find transport options for object: %s", transportOptionsURL)
 	}
 
-	u, err := url.Parse(opts.TargetURL)
+	targetURL, err := opts.dialURL()
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(targetURL)
 	if err != nil {
 		return nil, err
 	}
@@ -153,6 +159,7 @@ func (t *sshSmartSubtransport) Action(transportOptionsURL string, action git2go.
 	case git2go.SmartServiceActionUploadpackLs, git2go.SmartServiceActionUploadpack:
 		if t.currentStream != nil {
 			if t.lastAction == git2go.SmartServiceActionUploadpackLs {
+				t.setDeadline(opts.Context)
 				return t.currentStream, nil
 			}
 		}
@@ -161,6 +168,7 @@ func (t *sshSmartSubtransport) Action(transportOptionsURL string, action git2go.
 	case git2go.SmartServiceActionReceivepackLs, git2go.SmartServiceActionReceivepack:
 		if t.currentStream != nil {
 			if t.lastAction == git2go.SmartServiceActionReceivepackLs {
+				t.setDeadline(opts.Context)
 				return t.currentStream, nil
 			}
 		}
@@ -184,11 +192,19 @@ func (t *sshSmartSubtransport) Action(transportOptionsURL string, action git2go.
 				"addr", addr)
 		}
 	})
+	if targetURL != opts.TargetURL {
+		// show as info, as this should be visible regardless of the
+		// chosen log-level.
+		t.logger.Info("rewrote remote URL", "url", opts.TargetURL, "newUrl", targetURL)
+	}
 
 	sshConfig, err := createClientConfig(opts.AuthOpts)
 	if err != nil {
 		return nil, err
 	}
+	if len(opts.HostKeyAlgos) > 0 {
+		sshConfig.HostKeyAlgorithms = opts.HostKeyAlgos
+	}
 
 	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 		keyHash := sha256.Sum256(key.Marshal())
@@ -203,8 +219,12 @@ func (t *sshSmartSubtransport) Action(transportOptionsURL string, action git2go.
 
 	err = t.createConn(addr, sshConfig)
 	if err != nil {
+		if len(opts.HostKeyAlgos) > 0 {
+			err = hostKeyAlgorithmsError(addr, opts.HostKeyAlgos, err)
+		}
 		return nil, err
 	}
+	t.setDeadline(opts.Context)
 
 	t.logger.V(logger.TraceLevel).Info("creating new ssh session")
 	if t.session, err = t.client.NewSession(); err != nil {
@@ -292,6 +312,7 @@ func (t *sshSmartSubtransport) createConn(addr string, sshConfig *ssh.ClientConf
 	if err != nil {
 		return err
 	}
+	t.conn = conn
 
 	t.connected = true
 	t.client = ssh.NewClient(c, chans, reqs)
@@ -299,6 +320,22 @@ func (t *sshSmartSubtransport) createConn(addr string, sshConfig *ssh.ClientConf
 	return nil
 }
 
+// setDeadline applies the deadline of the given context to the connection,
+// or clears it when there is none, so that reads and writes of a stalled
+// transfer fail once the deadline has passed. Unlike closing the connection
+// from another goroutine, this does not interfere with libgit2 using the
+// stream.
+func (t *sshSmartSubtransport) setDeadline(ctx context.Context) {
+	if t.conn == nil {
+		return
+	}
+	var deadline time.Time
+	if ctx != nil {
+		deadline, _ = ctx.Deadline()
+	}
+	_ = t.conn.SetDeadline(deadline)
+}
+
 // Close closes the smart subtransport.
 //
 // This is called internally ahead of a new action, and also
@@ -328,6 +365,7 @@ func (t *sshSmartSubtransport) Close() error {
 		t.logger.V(logger.TraceLevel).Info("close client")
 	}
 	t.client = nil
+	t.conn = nil
 
 	t.connected = false
 	atomic.AddInt32(t.closedSessions, 1)
@@ -353,6 +391,33 @@ func (stream *sshSmartSubtransportStream) Write(buf []byte) (int, error) {
 func (stream *sshSmartSubtransportStream) Free() {
 }
 
+const (
+	// noCommonHostKeyAlgorithm and serverOffered are part of the error
+	// returned by the SSH handshake when the host key algorithm can not be
+	// negotiated, which is not of a distinct type.
+	noCommonHostKeyAlgorithm = "no common algorithm for host key"
+	serverOffered            = "server offered: "
+)
+
+// hostKeyAlgorithmsError returns an error naming the host key algorithms
+// offered by the server at addr and the ones accepted, when err is caused by
+// the negotiation of the host key algorithm failing. Any other error is
+// returned as is.
+func hostKeyAlgorithmsError(addr string, accepted []string, err error) error {
+	msg := err.Error()
+	i := strings.Index(msg, noCommonHostKeyAlgorithm)
+	if i < 0 {
+		return err
+	}
+	offered := "unknown"
+	if j := strings.Index(msg[i:], serverOffered); j >= 0 {
+		offered = strings.Trim(msg[i+j+len(serverOffered):], "[]")
+		offered = strings.Join(strings.Fields(offered), ", ")
+	}
+	return fmt.Errorf("none of the host key algorithms offered by '%s' are accepted: offered: [%s], accepted: [%s]: %w",
+		addr, offered, strings.Join(accepted, ", "), err)
+}
+
 func createClientConfig(authOpts *git.AuthOptions) (*ssh.ClientConfig, error) {
 	if authOpts == nil {
 		return nil, fmt.Errorf("cannot create ssh client config from nil ssh auth options")
@@ -17,6 +17,9 @@ limitations under the License.
 package managed
 
 import (
+	"context"
+	"errors"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -131,3 +134,47 @@ func TestSSH_E2E(t *testing.T) {
 	g.Expect(err).ToNot(HaveOccurred())
 	repo.Free()
 }
+
+func Test_hostKeyAlgorithmsError(t *testing.T) {
+	accepted := []string{"ssh-ed25519", "ecdsa-sha2-nistp256"}
+
+	t.Run("names offered and accepted algorithms", func(t *testing.T) {
+		g := NewWithT(t)
+
+		err := errors.New("ssh: handshake failed: ssh: no common algorithm for host key; client offered: [ssh-ed25519 ecdsa-sha2-nistp256], server offered: [rsa-sha2-512 rsa-sha2-256]")
+		got := hostKeyAlgorithmsError("github.com:22", accepted, err)
+		g.Expect(got.Error()).To(HavePrefix("none of the host key algorithms offered by 'github.com:22' are accepted: offered: [rsa-sha2-512, rsa-sha2-256], accepted: [ssh-ed25519, ecdsa-sha2-nistp256]"))
+		g.Expect(errors.Is(got, err)).To(BeTrue())
+	})
+
+	t.Run("returns other errors as is", func(t *testing.T) {
+		g := NewWithT(t)
+
+		err := errors.New("ssh: handshake failed: ssh: unable to authenticate")
+		g.Expect(hostKeyAlgorithmsError("github.com:22", accepted, err)).To(Equal(err))
+	})
+}
+
+func TestSSHSmartSubtransport_setDeadline(t *testing.T) {
+	g := NewWithT(t)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	sst := &sshSmartSubtransport{conn: client}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sst.setDeadline(ctx)
+
+	_, err := client.Read(make([]byte, 1))
+	var netErr net.Error
+	g.Expect(errors.As(err, &netErr)).To(BeTrue())
+	g.Expect(netErr.Timeout()).To(BeTrue())
+
+	// A context without deadline clears it.
+	sst.setDeadline(context.Background())
+	go server.Write([]byte("a"))
+	_, err = client.Read(make([]byte, 1))
+	g.Expect(err).ToNot(HaveOccurred())
+}
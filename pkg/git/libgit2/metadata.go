@@ -0,0 +1,46 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+// CheckoutWithoutGitMetadata performs the checkout of the Strategy, and
+// removes the .git directory from the checkout path once it succeeds, leaving
+// only the working tree. The Strategy has released all its handles into the
+// repository by the time it returns, which allows the directory to be
+// removed.
+type CheckoutWithoutGitMetadata struct {
+	Strategy git.CheckoutStrategy
+}
+
+func (c *CheckoutWithoutGitMetadata) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
+	cc, err := c.Strategy.Checkout(ctx, path, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.RemoveAll(filepath.Join(path, ".git")); err != nil {
+		return nil, fmt.Errorf("failed to remove git metadata from '%s': %w", path, err)
+	}
+	return cc, nil
+}
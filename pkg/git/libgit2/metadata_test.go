@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+type mockRepositoryStrategy struct {
+	err error
+}
+
+func (m *mockRepositoryStrategy) Checkout(_ context.Context, path, _ string, _ *git.AuthOptions) (*git.Commit, error) {
+	if err := os.MkdirAll(filepath.Join(path, ".git", "objects"), 0o750); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(path, "file"), nil, 0o640); err != nil {
+		return nil, err
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &git.Commit{Hash: git.Hash("abc")}, nil
+}
+
+func TestCheckoutWithoutGitMetadata_Checkout(t *testing.T) {
+	t.Run("removes git metadata", func(t *testing.T) {
+		g := NewWithT(t)
+
+		tmpDir := t.TempDir()
+		c := &CheckoutWithoutGitMetadata{Strategy: &mockRepositoryStrategy{}}
+		cc, err := c.Checkout(context.TODO(), tmpDir, "https://example.com", &git.AuthOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cc.Hash.String()).To(Equal("abc"))
+		g.Expect(filepath.Join(tmpDir, ".git")).ToNot(BeAnExistingFile())
+		g.Expect(filepath.Join(tmpDir, "file")).To(BeARegularFile())
+	})
+
+	t.Run("keeps git metadata on failure", func(t *testing.T) {
+		g := NewWithT(t)
+
+		tmpDir := t.TempDir()
+		c := &CheckoutWithoutGitMetadata{Strategy: &mockRepositoryStrategy{err: errors.New("checkout failed")}}
+		cc, err := c.Checkout(context.TODO(), tmpDir, "https://example.com", &git.AuthOptions{})
+		g.Expect(err).To(MatchError("checkout failed"))
+		g.Expect(cc).To(BeNil())
+		g.Expect(filepath.Join(tmpDir, ".git")).To(BeADirectory())
+	})
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+// CheckoutWithMetrics performs the checkout of the Strategy, and records
+// its git.CheckoutMetrics with the Recorder once it completes. A checkout
+// returning a partial commit, as the LastRevision was still current, is
+// recorded as short-circuited.
+type CheckoutWithMetrics struct {
+	Strategy git.CheckoutStrategy
+	Recorder git.MetricsRecorder
+}
+
+func (c *CheckoutWithMetrics) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
+	start := time.Now()
+	cc, err := c.Strategy.Checkout(ctx, path, url, opts)
+
+	outcome := git.CheckoutOutcomeFull
+	switch {
+	case err != nil:
+		outcome = git.CheckoutOutcomeError
+	case cc.Partial:
+		outcome = git.CheckoutOutcomeShortCircuited
+	}
+	c.Recorder.RecordCheckout(git.CheckoutMetrics{
+		Implementation: Implementation,
+		Strategy:       strategyName(c.Strategy),
+		Host:           urlHost(url),
+		Outcome:        outcome,
+		Duration:       time.Since(start),
+	})
+	return cc, err
+}
+
+// strategyName returns the kind of checkout the given strategy performs.
+func strategyName(s git.CheckoutStrategy) string {
+	switch s := s.(type) {
+	case *CheckoutBranch:
+		return "branch"
+	case *CheckoutTag:
+		return "tag"
+	case *CheckoutLatestTag:
+		return "latest-tag"
+	case *CheckoutCommit:
+		return "commit"
+	case *CheckoutSemVer:
+		return "semver"
+	case *CheckoutRef:
+		return "ref"
+	case *CheckoutRollback:
+		return "rollback"
+	case *CheckoutWithMirrors:
+		return strategyName(s.Strategy)
+	case *CheckoutWithoutGitMetadata:
+		return strategyName(s.Strategy)
+	default:
+		return "unknown"
+	}
+}
+
+// urlHost returns the host of the given URL, or an empty string if it can
+// not be parsed.
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
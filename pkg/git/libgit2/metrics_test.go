@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+type mockMetricsRecorder struct {
+	metrics []git.CheckoutMetrics
+}
+
+func (m *mockMetricsRecorder) RecordCheckout(metrics git.CheckoutMetrics) {
+	m.metrics = append(m.metrics, metrics)
+}
+
+type mockCommitStrategy struct {
+	commit *git.Commit
+	err    error
+}
+
+func (m *mockCommitStrategy) Checkout(context.Context, string, string, *git.AuthOptions) (*git.Commit, error) {
+	return m.commit, m.err
+}
+
+func TestCheckoutWithMetrics_Checkout(t *testing.T) {
+	tests := []struct {
+		name        string
+		strategy    git.CheckoutStrategy
+		wantOutcome git.CheckoutOutcome
+	}{
+		{
+			name:        "full checkout",
+			strategy:    &mockCommitStrategy{commit: &git.Commit{Hash: git.Hash("abc"), Encoded: []byte("commit")}},
+			wantOutcome: git.CheckoutOutcomeFull,
+		},
+		{
+			name:        "partial commit",
+			strategy:    &mockCommitStrategy{commit: &git.Commit{Hash: git.Hash("abc"), Reference: "refs/heads/main", Partial: true}},
+			wantOutcome: git.CheckoutOutcomeShortCircuited,
+		},
+		{
+			name:        "error",
+			strategy:    &mockCommitStrategy{err: errors.New("checkout failed")},
+			wantOutcome: git.CheckoutOutcomeError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			recorder := &mockMetricsRecorder{}
+			c := &CheckoutWithMetrics{Strategy: tt.strategy, Recorder: recorder}
+			_, _ = c.Checkout(context.TODO(), t.TempDir(), "https://example.com:8443/org/repo", &git.AuthOptions{})
+			g.Expect(recorder.metrics).To(HaveLen(1))
+			g.Expect(recorder.metrics[0].Implementation).To(Equal(Implementation))
+			g.Expect(recorder.metrics[0].Strategy).To(Equal("unknown"))
+			g.Expect(recorder.metrics[0].Host).To(Equal("example.com"))
+			g.Expect(recorder.metrics[0].Outcome).To(Equal(tt.wantOutcome))
+			g.Expect(recorder.metrics[0].Duration).To(BeNumerically(">", 0))
+		})
+	}
+}
+
+func Test_strategyName(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(strategyName(&CheckoutSemVer{})).To(Equal("semver"))
+	g.Expect(strategyName(&CheckoutWithoutGitMetadata{
+		Strategy: &CheckoutWithMirrors{Strategy: &CheckoutTag{}},
+	})).To(Equal("tag"))
+}
+
+func TestCheckout_Metrics(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+	repoURL := server.HTTPAddress() + "/" + repoPath
+	authOpts := &git.AuthOptions{
+		TransportOptionsURL: getTransportOptionsURL(git.HTTP),
+	}
+
+	recorder := &mockMetricsRecorder{}
+	opts := git.CheckoutOptions{Branch: git.DefaultBranch, Metrics: recorder}
+	cc, err := CheckoutStrategyForOptions(context.TODO(), opts).Checkout(context.TODO(), t.TempDir(), repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	opts.LastRevision = cc.String()
+	_, err = CheckoutStrategyForOptions(context.TODO(), opts).Checkout(context.TODO(), t.TempDir(), repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(recorder.metrics).To(HaveLen(2))
+	g.Expect(recorder.metrics[0].Strategy).To(Equal("branch"))
+	g.Expect(recorder.metrics[0].Outcome).To(Equal(git.CheckoutOutcomeFull))
+	g.Expect(recorder.metrics[1].Outcome).To(Equal(git.CheckoutOutcomeShortCircuited))
+}
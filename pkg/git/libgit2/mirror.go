@@ -0,0 +1,145 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	git2go "github.com/libgit2/git2go/v33"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+// networkErrorMessages are the messages of errors which indicate the remote
+// can not be reached. As errors returned by the managed transports lose
+// their identity when passed through libgit2, they are matched on their
+// message.
+var networkErrorMessages = []string{
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"no such host",
+	"network is unreachable",
+	"i/o timeout",
+	"unhandled HTTP error 502",
+	"unhandled HTTP error 503",
+	"unhandled HTTP error 504",
+}
+
+// CheckoutWithMirrors performs the checkout of the Strategy from the URL of
+// the repository, and from each of the MirrorURLs in order when it fails on
+// a network error. Other errors, for example an authentication failure or a
+// missing reference, are returned without attempting the next mirror.
+// The credentials and known_hosts of the AuthOptions are only used for
+// mirrors on the same host as the URL of the repository.
+type CheckoutWithMirrors struct {
+	Strategy   git.CheckoutStrategy
+	MirrorURLs []string
+}
+
+func (c *CheckoutWithMirrors) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
+	if opts == nil {
+		return nil, errors.New("can't checkout using libgit2 with an empty set of auth options")
+	}
+
+	urls := append([]string{url}, c.MirrorURLs...)
+	var err error
+	for i, u := range urls {
+		mirrorOpts := *opts
+		if i > 0 {
+			logr.FromContextOrDiscard(ctx).Info("falling back to mirror after checkout failure",
+				"url", u, "error", err.Error())
+			if cErr := git.RemoveDirContents(path); cErr != nil {
+				return nil, fmt.Errorf("failed to clean up checkout path after '%s': %w", err, cErr)
+			}
+			if mirrorOpts, err = mirrorAuthOptions(url, u, opts); err != nil {
+				return nil, err
+			}
+			// Register each mirror for a unique transport options URL,
+			// which is generated by the Strategy when there is none.
+			if opts.TransportOptionsURL != "" {
+				mirrorOpts.TransportOptionsURL, err = derivedTransportOptionsURL(opts.TransportOptionsURL, u, "mirrors", strconv.Itoa(i))
+				if err != nil {
+					return nil, fmt.Errorf("unable to configure transport for mirror '%s': %w", u, err)
+				}
+			}
+		}
+
+		var cc *git.Commit
+		cc, err = c.Strategy.Checkout(ctx, path, u, &mirrorOpts)
+		if err == nil {
+			cc.URL = u
+			return cc, nil
+		}
+		if ctx.Err() != nil || !isNetworkError(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("unable to checkout from '%s' or any of its mirrors: %w", url, err)
+}
+
+// mirrorAuthOptions returns the AuthOptions to check out the given mirror of
+// the repository at repoURL with. The credentials and known_hosts are dropped
+// for a mirror on a different host, as they are not meant to be sent to it.
+func mirrorAuthOptions(repoURL, mirrorURL string, opts *git.AuthOptions) (git.AuthOptions, error) {
+	mirrorOpts := *opts
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return mirrorOpts, fmt.Errorf("unable to parse URL '%s': %w", repoURL, err)
+	}
+	m, err := url.Parse(mirrorURL)
+	if err != nil {
+		return mirrorOpts, fmt.Errorf("unable to parse mirror URL '%s': %w", mirrorURL, err)
+	}
+	if strings.EqualFold(u.Host, m.Host) {
+		return mirrorOpts, nil
+	}
+	mirrorOpts.Host = m.Host
+	mirrorOpts.Username = ""
+	mirrorOpts.Password = ""
+	mirrorOpts.Identity = nil
+	mirrorOpts.KnownHosts = nil
+	mirrorOpts.CredentialsProvider = nil
+	return mirrorOpts, nil
+}
+
+// isNetworkError returns if the given error is caused by the remote being
+// unreachable, as opposed to for example an authentication failure.
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var gitErr *git.GitError
+	if errors.As(err, &gitErr) &&
+		(gitErr.Code == int(git2go.ErrorCodeAuth) || gitErr.Code == int(git2go.ErrorCodeCertificate)) {
+		return false
+	}
+	for _, msg := range networkErrorMessages {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+type mockMirrorStrategy struct {
+	errs    map[string]error
+	urls    []string
+	optsURL []string
+}
+
+func (m *mockMirrorStrategy) Checkout(_ context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
+	m.urls = append(m.urls, url)
+	m.optsURL = append(m.optsURL, opts.TransportOptionsURL)
+	if err := os.WriteFile(filepath.Join(path, "leftover"), nil, 0o640); err != nil {
+		return nil, err
+	}
+	if err := m.errs[url]; err != nil {
+		return nil, err
+	}
+	return &git.Commit{Hash: git.Hash("abc")}, nil
+}
+
+func TestCheckoutWithMirrors_Checkout(t *testing.T) {
+	unreachable := &git.GitError{Message: "dial tcp 127.0.0.1:1: connect: connection refused", Class: 12, Code: -1}
+	unauthorized := &git.GitError{Message: "unhandled HTTP error 401 Unauthorized", Class: 12, Code: -16}
+
+	tests := []struct {
+		name     string
+		errs     map[string]error
+		wantURLs []string
+		wantURL  string
+		wantErr  string
+	}{
+		{
+			name:     "primary succeeds",
+			wantURLs: []string{"https://primary"},
+			wantURL:  "https://primary",
+		},
+		{
+			name:     "falls back to mirror on network error",
+			errs:     map[string]error{"https://primary": unreachable},
+			wantURLs: []string{"https://primary", "https://mirror-1"},
+			wantURL:  "https://mirror-1",
+		},
+		{
+			name:     "does not fall back on authentication failure",
+			errs:     map[string]error{"https://primary": unauthorized},
+			wantURLs: []string{"https://primary"},
+			wantErr:  unauthorized.Message,
+		},
+		{
+			name:     "does not fall back on missing reference",
+			errs:     map[string]error{"https://primary": errors.New("unable to find 'v1.0.0': reference not found")},
+			wantURLs: []string{"https://primary"},
+			wantErr:  "reference not found",
+		},
+		{
+			name: "all mirrors unreachable",
+			errs: map[string]error{
+				"https://primary":  unreachable,
+				"https://mirror-1": unreachable,
+				"https://mirror-2": unreachable,
+			},
+			wantURLs: []string{"https://primary", "https://mirror-1", "https://mirror-2"},
+			wantErr:  "unable to checkout from 'https://primary' or any of its mirrors",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &mockMirrorStrategy{errs: tt.errs}
+			s := &CheckoutWithMirrors{
+				Strategy:   m,
+				MirrorURLs: []string{"https://mirror-1", "https://mirror-2"},
+			}
+			authOpts := &git.AuthOptions{TransportOptionsURL: "http://obj/uid/1"}
+
+			cc, err := s.Checkout(context.TODO(), t.TempDir(), "https://primary", authOpts)
+			g.Expect(m.urls).To(Equal(tt.wantURLs))
+			g.Expect(m.optsURL[0]).To(Equal(authOpts.TransportOptionsURL))
+			for i := 1; i < len(m.optsURL); i++ {
+				g.Expect(m.optsURL[i]).To(Equal(fmt.Sprintf("http://obj/uid/1/mirrors/%d", i)))
+			}
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				g.Expect(cc).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.URL).To(Equal(tt.wantURL))
+		})
+	}
+}
+
+func Test_mirrorAuthOptions(t *testing.T) {
+	credentials := func(context.Context, git.CredentialsRequest) (git.Credentials, error) {
+		return git.Credentials{Username: "user", Password: "token"}, nil
+	}
+	opts := &git.AuthOptions{
+		Transport:           git.HTTPS,
+		Host:                "example.com",
+		Username:            "user",
+		Password:            "pass",
+		Identity:            []byte("identity"),
+		KnownHosts:          []byte("known_hosts"),
+		CAFile:              []byte("ca"),
+		CredentialsProvider: credentials,
+		TransportOptionsURL: "http://obj/uid/1",
+	}
+
+	tests := []struct {
+		name      string
+		mirrorURL string
+		wantCreds bool
+		wantHost  string
+		wantErr   bool
+	}{
+		{
+			name:      "same host keeps credentials",
+			mirrorURL: "https://example.com/mirror/repo.git",
+			wantCreds: true,
+			wantHost:  "example.com",
+		},
+		{
+			name:      "same host ignores case",
+			mirrorURL: "https://EXAMPLE.com/mirror/repo.git",
+			wantCreds: true,
+			wantHost:  "example.com",
+		},
+		{
+			name:      "different host drops credentials",
+			mirrorURL: "https://mirror.example.com/repo.git",
+			wantHost:  "mirror.example.com",
+		},
+		{
+			name:      "different port drops credentials",
+			mirrorURL: "https://example.com:8443/repo.git",
+			wantHost:  "example.com:8443",
+		},
+		{
+			name:      "invalid mirror URL",
+			mirrorURL: "https://mirror.example.com/%zz",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := mirrorAuthOptions("https://example.com/repo.git", tt.mirrorURL, opts)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got.Host).To(Equal(tt.wantHost))
+			g.Expect(got.CAFile).To(Equal(opts.CAFile))
+			g.Expect(got.TransportOptionsURL).To(Equal(opts.TransportOptionsURL))
+			if tt.wantCreds {
+				g.Expect(got.Username).To(Equal(opts.Username))
+				g.Expect(got.Password).To(Equal(opts.Password))
+				g.Expect(got.Identity).To(Equal(opts.Identity))
+				g.Expect(got.KnownHosts).To(Equal(opts.KnownHosts))
+				g.Expect(got.CredentialsProvider).ToNot(BeNil())
+				return
+			}
+			g.Expect(got.Username).To(BeEmpty())
+			g.Expect(got.Password).To(BeEmpty())
+			g.Expect(got.Identity).To(BeNil())
+			g.Expect(got.KnownHosts).To(BeNil())
+			g.Expect(got.CredentialsProvider).To(BeNil())
+		})
+	}
+}
+
+func TestCheckout_MirrorURLs(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+	mirrorURL := server.HTTPAddress() + "/" + repoPath
+
+	opts := git.CheckoutOptions{
+		Branch:     git.DefaultBranch,
+		MirrorURLs: []string{mirrorURL},
+	}
+	authOpts := &git.AuthOptions{
+		TransportOptionsURL: getTransportOptionsURL(git.HTTP),
+	}
+	tmpDir := t.TempDir()
+	cc, err := CheckoutStrategyForOptions(context.TODO(), opts).Checkout(context.TODO(), tmpDir, "http://127.0.0.1:1/test.git", authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.URL).To(Equal(mirrorURL))
+	g.Expect(filepath.Join(tmpDir, ".git")).To(BeADirectory())
+}
+
+func Test_isNetworkError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "connection refused",
+			err:  &git.GitError{Message: "dial tcp 127.0.0.1:1: connect: connection refused"},
+			want: true,
+		},
+		{
+			name: "wrapped server error",
+			err:  fmt.Errorf("unable to clone: %w", &git.GitError{Message: "unhandled HTTP error 503 Service Unavailable"}),
+			want: true,
+		},
+		{
+			name: "authentication failure",
+			err:  &git.GitError{Message: "i/o timeout", Code: -16},
+			want: false,
+		},
+		{
+			name: "missing repository",
+			err:  &git.GitError{Message: "unhandled HTTP error 404 Not Found"},
+			want: false,
+		},
+		{
+			name: "unrelated error",
+			err:  errors.New("could not create oid"),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(isNetworkError(tt.err)).To(Equal(tt.want))
+		})
+	}
+}
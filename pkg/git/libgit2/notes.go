@@ -0,0 +1,75 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"fmt"
+
+	git2go "github.com/libgit2/git2go/v33"
+)
+
+// fetchRefspecs returns the given refspecs, with the refspec for the
+// NotesRef appended if configured and not yet included. Notes references are
+// not fetched by default, and a reference which does not exist on the remote
+// is ignored.
+func (o checkoutOptions) fetchRefspecs(refspecs ...string) []string {
+	if o.NotesRef == "" {
+		return refspecs
+	}
+	notesRefspec := fmt.Sprintf("+%s:%s", o.NotesRef, o.NotesRef)
+	for _, refspec := range refspecs {
+		if refspec == notesRefspec {
+			return refspecs
+		}
+	}
+	return append(refspecs, notesRefspec)
+}
+
+// createRemote creates the remote of a clone, with the refspec for the
+// NotesRef added to its configured refspecs.
+func (o checkoutOptions) createRemote(repo *git2go.Repository, name, url string) (*git2go.Remote, error) {
+	remote, err := repo.Remotes.Create(name, url)
+	if err != nil || o.NotesRef == "" {
+		return remote, err
+	}
+	remote.Free()
+	for _, refspec := range o.fetchRefspecs() {
+		if err = repo.Remotes.AddFetch(name, refspec); err != nil {
+			return nil, err
+		}
+	}
+	// The refspecs of a remote are loaded when it is looked up.
+	return repo.Remotes.Lookup(name)
+}
+
+// readNote returns the content of the note attached to the given commit in
+// the NotesRef, or an empty string if it is not configured or the commit
+// does not have a note.
+func (o checkoutOptions) readNote(repo *git2go.Repository, oid *git2go.Oid) (string, error) {
+	if o.NotesRef == "" {
+		return "", nil
+	}
+	note, err := repo.Notes.Read(o.NotesRef, oid)
+	if err != nil {
+		if git2go.IsErrorCode(err, git2go.ErrorCodeNotFound) {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to read note of commit '%s' from '%s': %w", oid, o.NotesRef, libGit2Error(err))
+	}
+	defer note.Free()
+	return note.Message(), nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	git2go "github.com/libgit2/git2go/v33"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func TestCheckoutOptions_fetchRefspecs(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(checkoutOptions{}.fetchRefspecs("main")).To(Equal([]string{"main"}))
+	g.Expect(checkoutOptions{}.fetchRefspecs()).To(BeNil())
+
+	o := checkoutOptions{NotesRef: "refs/notes/approvals"}
+	g.Expect(o.fetchRefspecs("main")).To(Equal([]string{"main", "+refs/notes/approvals:refs/notes/approvals"}))
+	g.Expect(o.fetchRefspecs("+refs/notes/approvals:refs/notes/approvals")).
+		To(Equal([]string{"+refs/notes/approvals:refs/notes/approvals"}))
+}
+
+func TestCheckout_Notes(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+	repo, err := git2go.OpenRepository(filepath.Join(server.Root(), repoPath))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+
+	approved, err := commitFile(repo, "file", "approved", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = tag(repo, approved, false, "v1.0.0", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = repo.Notes.Create("refs/notes/approvals", mockSignature(time.Now()), mockSignature(time.Now()),
+		approved, "approved-by: jane", false)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repoURL := server.HTTPAddress() + "/" + repoPath
+	tests := []struct {
+		name      string
+		strategy  func(o checkoutOptions) git.CheckoutStrategy
+		notesRef  string
+		wantNotes string
+	}{
+		{
+			name: "branch",
+			strategy: func(o checkoutOptions) git.CheckoutStrategy {
+				return &CheckoutBranch{Branch: git.DefaultBranch, checkoutOptions: o}
+			},
+			notesRef:  "refs/notes/approvals",
+			wantNotes: "approved-by: jane",
+		},
+		{
+			name: "tag",
+			strategy: func(o checkoutOptions) git.CheckoutStrategy {
+				return &CheckoutTag{Tag: "v1.0.0", checkoutOptions: o}
+			},
+			notesRef:  "refs/notes/approvals",
+			wantNotes: "approved-by: jane",
+		},
+		{
+			name: "semver",
+			strategy: func(o checkoutOptions) git.CheckoutStrategy {
+				return &CheckoutSemVer{SemVer: "*", checkoutOptions: o}
+			},
+			notesRef:  "refs/notes/approvals",
+			wantNotes: "approved-by: jane",
+		},
+		{
+			name: "notes ref not set",
+			strategy: func(o checkoutOptions) git.CheckoutStrategy {
+				return &CheckoutBranch{Branch: git.DefaultBranch, checkoutOptions: o}
+			},
+		},
+		{
+			name: "notes ref missing on remote",
+			strategy: func(o checkoutOptions) git.CheckoutStrategy {
+				return &CheckoutBranch{Branch: git.DefaultBranch, checkoutOptions: o}
+			},
+			notesRef: "refs/notes/missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			authOpts := &git.AuthOptions{TransportOptionsURL: getTransportOptionsURL(git.HTTP)}
+			s := tt.strategy(checkoutOptions{NotesRef: tt.notesRef})
+			cc, err := s.Checkout(context.TODO(), t.TempDir(), repoURL, authOpts)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.Hash.String()).To(Equal(approved.String()))
+			g.Expect(cc.Notes).To(Equal(tt.wantNotes))
+		})
+	}
+}
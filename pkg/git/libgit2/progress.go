@@ -0,0 +1,111 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	git2go "github.com/libgit2/git2go/v33"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+// transferProgress records the progress of fetching objects, and reports it
+// to a git.ProgressFunc at most once per interval, and once the transfer
+// completes.
+type transferProgress struct {
+	report   git.ProgressFunc
+	interval time.Duration
+
+	mu       sync.Mutex
+	stats    *git.TransferProgress
+	reported time.Time
+	complete bool
+}
+
+func newTransferProgress(report git.ProgressFunc) *transferProgress {
+	return &transferProgress{
+		report:   report,
+		interval: git.TransferProgressInterval,
+	}
+}
+
+// update records the given stats, and reports them if the interval has
+// passed since the last report, or the transfer is complete. Updates after
+// completion, while deltas are resolved, are recorded but not reported.
+func (p *transferProgress) update(stats git2go.TransferProgress) {
+	s := git.TransferProgress{
+		TotalObjects:    stats.TotalObjects,
+		IndexedObjects:  stats.IndexedObjects,
+		ReceivedObjects: stats.ReceivedObjects,
+		LocalObjects:    stats.LocalObjects,
+		TotalDeltas:     stats.TotalDeltas,
+		ReceivedBytes:   stats.ReceivedBytes,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats = &s
+	if p.complete {
+		return
+	}
+	p.complete = s.TotalObjects > 0 && s.IndexedObjects == s.TotalObjects
+	if now := time.Now(); p.complete || now.Sub(p.reported) >= p.interval {
+		p.reported = now
+		p.report(s)
+	}
+}
+
+// result returns the last recorded stats, or nil if no objects were fetched.
+func (p *transferProgress) result() *git.TransferProgress {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stats == nil {
+		return nil
+	}
+	s := *p.stats
+	return &s
+}
+
+// fetchCallbacks returns the remoteCallbacks to fetch objects with, which
+// record the transfer progress when a progress sink is configured, and abort
+// the transfer with git.ErrRepositorySizeExceeded once more than MaxSize
+// bytes have been received.
+func (o checkoutOptions) fetchCallbacks(ctx context.Context) git2go.RemoteCallbacks {
+	callbacks := remoteCallbacks(ctx)
+	if o.progress == nil && o.MaxSize <= 0 {
+		return callbacks
+	}
+	abortOnDone := callbacks.TransferProgressCallback
+	callbacks.TransferProgressCallback = func(stats git2go.TransferProgress) error {
+		if o.progress != nil {
+			o.progress.update(stats)
+		}
+		if o.MaxSize > 0 && int64(stats.ReceivedBytes) > o.MaxSize {
+			return fmt.Errorf("%w: received %d bytes, exceeding the limit of %d bytes",
+				git.ErrRepositorySizeExceeded, stats.ReceivedBytes, o.MaxSize)
+		}
+		return abortOnDone(stats)
+	}
+	return callbacks
+}
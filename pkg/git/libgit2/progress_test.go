@@ -0,0 +1,201 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	git2go "github.com/libgit2/git2go/v33"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+	"github.com/fluxcd/source-controller/pkg/git/libgit2/managed"
+)
+
+func TestTransferProgress_update(t *testing.T) {
+	g := NewWithT(t)
+
+	var reports []git.TransferProgress
+	p := newTransferProgress(func(s git.TransferProgress) {
+		reports = append(reports, s)
+	})
+	p.interval = time.Hour
+	g.Expect(p.result()).To(BeNil())
+
+	p.update(git2go.TransferProgress{TotalObjects: 3, ReceivedObjects: 1, ReceivedBytes: 10})
+	p.update(git2go.TransferProgress{TotalObjects: 3, ReceivedObjects: 2, ReceivedBytes: 20})
+	g.Expect(reports).To(HaveLen(1))
+	g.Expect(reports[0].ReceivedObjects).To(Equal(uint(1)))
+	g.Expect(p.result().ReceivedObjects).To(Equal(uint(2)))
+
+	// The completed transfer is reported regardless of the interval, but
+	// only once, while later updates are still recorded.
+	p.update(git2go.TransferProgress{TotalObjects: 3, ReceivedObjects: 3, IndexedObjects: 3, ReceivedBytes: 30})
+	p.update(git2go.TransferProgress{TotalObjects: 3, ReceivedObjects: 3, IndexedObjects: 3, ReceivedBytes: 30, TotalDeltas: 1})
+	g.Expect(reports).To(HaveLen(2))
+	g.Expect(reports[1]).To(Equal(git.TransferProgress{TotalObjects: 3, ReceivedObjects: 3, IndexedObjects: 3, ReceivedBytes: 30}))
+	g.Expect(p.result().TotalDeltas).To(Equal(uint(1)))
+
+	var nilProgress *transferProgress
+	g.Expect(nilProgress.result()).To(BeNil())
+}
+
+func TestCheckout_Progress(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+	repoURL := server.HTTPAddress() + "/" + repoPath
+
+	var reports []git.TransferProgress
+	opts := git.CheckoutOptions{
+		Branch: git.DefaultBranch,
+		Progress: func(p git.TransferProgress) {
+			reports = append(reports, p)
+		},
+	}
+	authOpts := &git.AuthOptions{
+		TransportOptionsURL: getTransportOptionsURL(git.HTTP),
+	}
+	cc, err := CheckoutStrategyForOptions(context.TODO(), opts).Checkout(context.TODO(), t.TempDir(), repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reports).ToNot(BeEmpty())
+	g.Expect(cc.Transfer).ToNot(BeNil())
+	g.Expect(cc.Transfer.ReceivedObjects).To(BeNumerically(">", 0))
+	g.Expect(cc.Transfer.ReceivedObjects).To(Equal(cc.Transfer.TotalObjects))
+	g.Expect(reports[len(reports)-1].ReceivedObjects).To(Equal(cc.Transfer.ReceivedObjects))
+}
+
+func TestCheckoutOptions_fetchCallbacks_maxSize(t *testing.T) {
+	g := NewWithT(t)
+
+	callbacks := checkoutOptions{MaxSize: 20}.fetchCallbacks(context.TODO())
+	g.Expect(callbacks.TransferProgressCallback(git2go.TransferProgress{ReceivedBytes: 20})).To(Succeed())
+	err := callbacks.TransferProgressCallback(git2go.TransferProgress{ReceivedBytes: 21})
+	g.Expect(errors.Is(err, git.ErrRepositorySizeExceeded)).To(BeTrue())
+	g.Expect(err).To(MatchError(ContainSubstring("received 21 bytes, exceeding the limit of 20 bytes")))
+}
+
+func TestCheckout_MaxSize(t *testing.T) {
+	server, err := gittestserver.NewTempGitServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(server.Root())
+
+	if err = server.StartHTTP(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	if err = server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git2go.OpenRepository(filepath.Join(server.Root(), repoPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Free()
+	c, err := commitFile(repo, "file", strings.Repeat("a", 4096), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoURL := server.HTTPAddress() + "/" + repoPath
+
+	tests := []struct {
+		name    string
+		opts    git.CheckoutOptions
+		wantErr bool
+	}{
+		{
+			name:    "branch exceeding limit",
+			opts:    git.CheckoutOptions{Branch: git.DefaultBranch, MaxSize: 64},
+			wantErr: true,
+		},
+		{
+			name:    "commit exceeding limit",
+			opts:    git.CheckoutOptions{Commit: c.String(), MaxSize: 64},
+			wantErr: true,
+		},
+		{
+			name: "branch within limit",
+			opts: git.CheckoutOptions{Branch: git.DefaultBranch, MaxSize: 1 << 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tmpDir := t.TempDir()
+			authOpts := &git.AuthOptions{
+				TransportOptionsURL: getTransportOptionsURL(git.HTTP),
+			}
+			cc, err := CheckoutStrategyForOptions(context.TODO(), tt.opts).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+			if tt.wantErr {
+				g.Expect(errors.Is(err, git.ErrRepositorySizeExceeded)).To(BeTrue(), "unexpected error: %v", err)
+				g.Expect(cc).To(BeNil())
+				entries, err := os.ReadDir(tmpDir)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(entries).To(BeEmpty())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc.Hash.String()).To(Equal(c.String()))
+		})
+	}
+}
+
+func TestCheckout_TransportOptionsRemovedOnPanic(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+
+	opts := git.CheckoutOptions{
+		Branch: git.DefaultBranch,
+		Progress: func(git.TransferProgress) {
+			panic("progress")
+		},
+	}
+	authOpts := &git.AuthOptions{
+		TransportOptionsURL: getTransportOptionsURL(git.HTTP),
+	}
+	_, err = CheckoutStrategyForOptions(context.TODO(), opts).Checkout(context.TODO(), t.TempDir(), server.HTTPAddress()+"/"+repoPath, authOpts)
+	g.Expect(err).To(MatchError(ContainSubstring("recovered from git2go panic")))
+	g.Expect(managed.RemoveStaleTransportOptions(0)).To(BeZero())
+}
@@ -0,0 +1,487 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	git2go "github.com/libgit2/git2go/v33"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+	"github.com/fluxcd/source-controller/pkg/git/libgit2/managed"
+)
+
+// Discover connects to the remote repository at the given URL, and describes
+// it based on the references it advertises. It does not clone or write
+// anything to disk.
+func Discover(ctx context.Context, url string, opts *git.AuthOptions) (_ *git.RepoInfo, err error) {
+	defer recoverPanic(&err)
+
+	remote, closeRemote, err := connectRemote(ctx, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRemote()
+
+	heads, err := remote.Ls()
+	if err != nil {
+		return nil, fmt.Errorf("unable to remote ls for '%s': %w", url, libGit2Error(err))
+	}
+
+	info := &git.RepoInfo{
+		Empty: len(heads) == 0,
+	}
+	var head *git2go.Oid
+	branches := make(map[string]*git2go.Oid)
+	for _, h := range heads {
+		switch {
+		case h.Name == "HEAD":
+			head = h.Id
+		case strings.HasPrefix(h.Name, "refs/heads/"):
+			name := strings.TrimPrefix(h.Name, "refs/heads/")
+			branches[name] = h.Id
+			info.Branches = append(info.Branches, name)
+		case strings.HasPrefix(h.Name, "refs/tags/"):
+			// Annotated tags are advertised twice, once for the tag object
+			// and once for the commit it peels to.
+			if strings.HasSuffix(h.Name, "^{}") {
+				continue
+			}
+			info.Tags = append(info.Tags, strings.TrimPrefix(h.Name, "refs/tags/"))
+		}
+	}
+	sort.Strings(info.Branches)
+	sort.Strings(info.Tags)
+
+	// Prefer the symbolic target of HEAD as advertised by the server, and fall
+	// back to the single branch HEAD points to.
+	if name, err := remote.DefaultBranch(); err == nil && name != "" {
+		info.DefaultBranch = strings.TrimPrefix(name, "refs/heads/")
+	} else if head != nil {
+		var candidates []string
+		for name, id := range branches {
+			if id.Equal(head) {
+				candidates = append(candidates, name)
+			}
+		}
+		if len(candidates) == 1 {
+			info.DefaultBranch = candidates[0]
+		}
+	}
+	return info, nil
+}
+
+// RefExists connects to the remote repository at the given URL, and checks
+// whether the given reference exists. The reference can either be a full
+// reference name (e.g. 'refs/tags/v1.0.0'), or a branch or tag name. It
+// returns the hash of the commit the reference points to, with annotated tags
+// peeled to their target.
+func RefExists(ctx context.Context, url string, opts *git.AuthOptions, ref string) (_ bool, _ string, err error) {
+	defer recoverPanic(&err)
+
+	remote, closeRemote, err := connectRemote(ctx, url, opts)
+	if err != nil {
+		return false, "", err
+	}
+	defer closeRemote()
+
+	exists, hash, err := lsRemoteRef(remote, ref)
+	if err != nil {
+		return false, "", fmt.Errorf("unable to remote ls for '%s': %w", url, err)
+	}
+	return exists, hash, nil
+}
+
+// ListRemoteRefs connects to the remote repository at the given URL, and
+// lists the references it advertises with a name matching the given pattern,
+// in the syntax of path.Match (e.g. 'refs/tags/v1.*'). An empty pattern
+// matches all references. Like Discover, it does not clone or write anything
+// to disk.
+func ListRemoteRefs(ctx context.Context, url string, opts *git.AuthOptions, pattern string) (_ []git.RemoteRef, err error) {
+	defer recoverPanic(&err)
+
+	if pattern != "" {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid reference pattern '%s': %w", pattern, err)
+		}
+	}
+
+	remote, closeRemote, err := connectRemote(ctx, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRemote()
+
+	heads, err := remote.Ls()
+	if err != nil {
+		return nil, fmt.Errorf("unable to remote ls for '%s': %w", url, libGit2Error(err))
+	}
+
+	var refs []git.RemoteRef
+	peeled := make(map[string]string)
+	for _, h := range heads {
+		if name := strings.TrimSuffix(h.Name, "^{}"); name != h.Name {
+			peeled[name] = h.Id.String()
+			continue
+		}
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, h.Name); !ok {
+				continue
+			}
+		}
+		refs = append(refs, git.RemoteRef{Name: h.Name, Hash: git.Hash(h.Id.String())})
+	}
+	for i, r := range refs {
+		if hash, ok := peeled[r.Name]; ok {
+			refs[i].Hash = git.Hash(hash)
+		}
+	}
+	return refs, nil
+}
+
+// lsRemoteBranch looks up the branch with the given name in the references
+// advertised by the connected remote. Unlike remote.Ls, which matches every
+// reference containing the pattern, only refs/heads/<branch> itself matches,
+// which a remote advertises at most once. It returns an empty hash when the
+// remote does not advertise the branch.
+func lsRemoteBranch(remote *git2go.Remote, branch string) (string, error) {
+	name := "refs/heads/" + branch
+	heads, err := remote.Ls(name)
+	if err != nil {
+		return "", libGit2Error(err)
+	}
+	for _, h := range heads {
+		if h.Name == name {
+			return h.Id.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// lsRemoteRef looks up the given reference in the references advertised by
+// the connected remote, trying the full name first, followed by a tag and a
+// branch of that name. Unlike remote.Ls, the reference name must match
+// exactly. It returns the hash of the commit the reference points to, with
+// annotated tags peeled to their target.
+func lsRemoteRef(remote *git2go.Remote, ref string) (bool, string, error) {
+	heads, err := remote.Ls(ref)
+	if err != nil {
+		return false, "", libGit2Error(err)
+	}
+
+	candidates := []string{ref}
+	if !strings.HasPrefix(ref, "refs/") {
+		candidates = append(candidates, "refs/tags/"+ref, "refs/heads/"+ref)
+	}
+	for _, name := range candidates {
+		var id, peeled *git2go.Oid
+		for _, h := range heads {
+			switch h.Name {
+			case name:
+				id = h.Id
+			case name + "^{}":
+				peeled = h.Id
+			}
+		}
+		if peeled != nil {
+			return true, peeled.String(), nil
+		}
+		if id != nil {
+			return true, id.String(), nil
+		}
+	}
+	return false, "", nil
+}
+
+// connectRemote registers the managed transport options for the given URL,
+// and connects an anonymous remote for fetching backed by an in-memory
+// repository. The returned function disconnects the remote and releases all
+// resources, and must be called once the caller is done with the remote.
+func connectRemote(ctx context.Context, url string, opts *git.AuthOptions) (_ *git2go.Remote, _ func(), err error) {
+	if opts, err = registerManagedTransportOptions(ctx, url, opts); err != nil {
+		return nil, nil, err
+	}
+	transportOptsURL := opts.TransportOptionsURL
+
+	var cleanups []func()
+	closeRemote := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+	cleanups = append(cleanups, func() { managed.RemoveTransportOptions(transportOptsURL) })
+	defer func() {
+		if err != nil {
+			closeRemote()
+		}
+	}()
+
+	odb, err := git2go.NewOdb()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create object database: %w", libGit2Error(err))
+	}
+	cleanups = append(cleanups, odb.Free)
+
+	repo, err := git2go.NewRepositoryWrapOdb(odb)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create in-memory repository: %w", libGit2Error(err))
+	}
+	cleanups = append(cleanups, repo.Free)
+
+	remote, err := repo.Remotes.CreateAnonymous(transportOptsURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create remote for '%s': %w", url, libGit2Error(err))
+	}
+	cleanups = append(cleanups, remote.Free)
+
+	callbacks := remoteCallbacks(ctx)
+	if err = remote.ConnectFetch(&callbacks, nil, nil); err != nil {
+		return nil, nil, contextError(ctx, url, fmt.Errorf("unable to fetch-connect to remote '%s': %w", url, libGit2Error(err)))
+	}
+	cleanups = append(cleanups, remote.Disconnect)
+
+	return remote, closeRemote, nil
+}
+
+// remoteCallbacks returns the RemoteCallbacks of the managed transports,
+// extended to abort the transfer as soon as progress is reported, or a
+// reference is updated, after the given context is done. A transfer which
+// stalls without reporting progress is aborted by the managed transports,
+// which are bound to the same context.
+func remoteCallbacks(ctx context.Context) git2go.RemoteCallbacks {
+	callbacks := managed.RemoteCallbacks()
+	callbacks.TransferProgressCallback = func(_ git2go.TransferProgress) error {
+		return ctx.Err()
+	}
+	callbacks.UpdateTipsCallback = func(_ string, _, _ *git2go.Oid) error {
+		return ctx.Err()
+	}
+	return callbacks
+}
+
+// remoteDefaultBranch returns the name of the branch the symbolic HEAD of
+// the given connected remote points to, or git.DefaultBranch when the remote
+// does not advertise a symbolic HEAD.
+func remoteDefaultBranch(remote *git2go.Remote) string {
+	name, err := remote.DefaultBranch()
+	if err != nil || !strings.HasPrefix(name, "refs/heads/") {
+		return git.DefaultBranch
+	}
+	return strings.TrimPrefix(name, "refs/heads/")
+}
+
+// contextError returns the error of the given context wrapped with the URL
+// when it is done, as the error returned by libgit2 for an aborted transfer
+// does not describe its cause. Otherwise, it returns err as classified by
+// remoteError.
+func contextError(ctx context.Context, url string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("git operation for '%s' aborted: %w", url, ctxErr)
+	}
+	return remoteError(url, err)
+}
+
+// authenticationErrorMessages are the messages of errors returned by the
+// managed transports when the remote rejects the credentials. Like
+// transientErrorMessages, they are matched on their message.
+var authenticationErrorMessages = []string{
+	"401 Unauthorized",
+	"403 Forbidden",
+	"unable to authenticate",
+}
+
+// retryAfterPattern matches the Retry-After header the managed HTTP
+// transport includes in the error for a rate limited request.
+var retryAfterPattern = regexp.MustCompile(`\(Retry-After: ([^)]*)\)`)
+
+// remoteError returns the given error of an operation on the remote at the
+// given URL as a git.AuthenticationError, git.TooManyRequestsError or
+// git.TransportError. Errors of missing references or objects, and of
+// exceeding the repository size limit, are returned as is.
+func remoteError(url string, err error) error {
+	if err == nil || errors.Is(err, git.ErrRepositorySizeExceeded) || isNotFoundError(err) {
+		return err
+	}
+	var gitErr *git.GitError
+	if errors.As(err, &gitErr) && gitErr.Code == int(git2go.ErrorCodeAuth) {
+		return &git.AuthenticationError{URL: url, Err: err}
+	}
+	msg := err.Error()
+	for _, m := range authenticationErrorMessages {
+		if strings.Contains(msg, m) {
+			return &git.AuthenticationError{URL: url, Err: err}
+		}
+	}
+	if strings.Contains(msg, "429 Too Many Requests") {
+		var retryAfter string
+		if m := retryAfterPattern.FindStringSubmatch(msg); m != nil {
+			retryAfter = m[1]
+		}
+		return git.NewTooManyRequestsError(url, retryAfter, err)
+	}
+	return &git.TransportError{URL: url, Err: err}
+}
+
+// refNotFoundError returns the given error as a git.RefNotFoundError for the
+// given reference, commit or SemVer range when it is caused by a missing
+// reference or object. Otherwise, it returns err.
+func refNotFoundError(ref string, err error) error {
+	if isNotFoundError(err) {
+		return &git.RefNotFoundError{Ref: ref, Err: err}
+	}
+	return err
+}
+
+// isNotFoundError returns if the error is caused by a missing reference or
+// object in libgit2.
+func isNotFoundError(err error) bool {
+	var gitErr *git.GitError
+	if errors.As(err, &gitErr) {
+		return gitErr.Code == int(git2go.ErrorCodeNotFound)
+	}
+	var libErr *git2go.GitError
+	return errors.As(err, &libErr) && libErr.Code == git2go.ErrorCodeNotFound
+}
+
+// derivedTransportOptionsURL returns a transport options URL derived from the
+// given one by appending the path elements to it. Its protocol matches the
+// one of the target URL, as the managed transports are registered per
+// protocol.
+func derivedTransportOptionsURL(optsURL, targetURL string, elem ...string) (string, error) {
+	var scheme string
+	switch {
+	case strings.HasPrefix(targetURL, "http"):
+		scheme = "http"
+	case strings.HasPrefix(targetURL, "ssh"):
+		scheme = "ssh"
+	default:
+		return "", fmt.Errorf("URL '%s' has invalid transport type, supported types are: http, https, ssh", targetURL)
+	}
+	u, err := url.Parse(optsURL)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse transport options URL: %w", err)
+	}
+	u.Scheme = scheme
+	u.Path = path.Join(append([]string{u.Path}, elem...)...)
+	return u.String(), nil
+}
+
+// anonymousTransportOptionsURL returns a new transport options URL for the
+// given target URL, which is unique to the calling operation.
+func anonymousTransportOptionsURL(targetURL string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("unable to generate transport options URL: %w", err)
+	}
+	return derivedTransportOptionsURL("//anonymous", targetURL, hex.EncodeToString(id))
+}
+
+// equalIgnoringUserInfo returns if the given URLs are equal, apart from
+// their user information.
+func equalIgnoringUserInfo(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return a == b
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return a == b
+	}
+	ua.User, ub.User = nil, nil
+	return ua.String() == ub.String()
+}
+
+// proxyOptions returns the git2go.ProxyOptions for the given proxy
+// configuration, with any credentials included in the URL. A nil
+// configuration results in the proxy being determined from the environment.
+// Returned errors do not contain the proxy URL, as it may hold credentials.
+func proxyOptions(opts *git.ProxyOptions) (*git2go.ProxyOptions, error) {
+	switch {
+	case opts == nil:
+		return &git2go.ProxyOptions{Type: git2go.ProxyTypeAuto}, nil
+	case opts.NoProxy:
+		return &git2go.ProxyOptions{Type: git2go.ProxyTypeNone}, nil
+	case opts.URL == "":
+		if opts.Username != "" || opts.Password != "" {
+			return nil, errors.New("invalid proxy options: proxy credentials require a URL")
+		}
+		return &git2go.ProxyOptions{Type: git2go.ProxyTypeAuto}, nil
+	}
+
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("invalid proxy URL: scheme and host are required")
+	}
+	if opts.Username != "" {
+		u.User = url.UserPassword(opts.Username, opts.Password)
+	}
+	return &git2go.ProxyOptions{Type: git2go.ProxyTypeSpecified, Url: u.String()}, nil
+}
+
+// validateCABundle returns an error if the given CA bundle does not contain
+// a single PEM encoded certificate which can be parsed, as the managed
+// transports reject such a bundle. Anything else in the bundle, like PEM
+// blocks of other types, malformed certificates or trailing data which is not
+// PEM encoded, is skipped by the transports. It is returned as warnings
+// instead, so that bundles which were accepted before keep working.
+func validateCABundle(caBundle []byte) (warnings []string, err error) {
+	var n, certs int
+	rest := caBundle
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		n++
+		if block.Type != "CERTIFICATE" {
+			warnings = append(warnings, fmt.Sprintf("CA bundle contains unexpected PEM block of type '%s' at position %d", block.Type, n))
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			warnings = append(warnings, fmt.Sprintf("CA bundle certificate at position %d could not be parsed: %s", n, err))
+			continue
+		}
+		certs++
+	}
+	if certs == 0 {
+		return nil, errors.New("invalid CA bundle: no PEM encoded certificates found")
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		warnings = append(warnings, fmt.Sprintf("CA bundle data after PEM block at position %d is not PEM encoded", n))
+	}
+	return warnings, nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	git2go "github.com/libgit2/git2go/v33"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+	"github.com/fluxcd/source-controller/pkg/git/libgit2/managed"
+)
+
+// transientErrorMessages are the messages of errors which indicate a
+// temporary network failure, after which the operation may succeed when
+// retried. Like networkErrorMessages, they are matched on their message as
+// errors returned by the managed transports lose their identity when passed
+// through libgit2.
+var transientErrorMessages = []string{
+	"connection reset",
+	"connection timed out",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"unexpected EOF",
+	"temporary failure in name resolution",
+	"server misbehaving",
+}
+
+// withFetchRetries calls fetch, and calls it again up to FetchRetries times
+// while it fails with a transient error, waiting FetchRetryDelay before the
+// first retry and doubling the delay for every next one. Every attempt is
+// bounded by FetchTimeout. It returns the error of the last attempt, or as
+// soon as the context is done.
+func (o checkoutOptions) withFetchRetries(ctx context.Context, url string, fetch func(context.Context) error) error {
+	delay := o.FetchRetryDelay
+	if delay <= 0 {
+		delay = git.DefaultFetchRetryDelay
+	}
+	for attempt := 1; ; attempt++ {
+		err := o.withFetchTimeout(ctx, fetch)
+		if err == nil || attempt > o.FetchRetries || ctx.Err() != nil || !isTransientError(err) {
+			return err
+		}
+		logr.FromContextOrDiscard(ctx).Info("retrying fetch after transient failure",
+			"url", url, "attempt", attempt, "delay", delay.String(), "error", err.Error())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// withFetchTimeout calls fetch with a context derived from ctx which is done
+// after FetchTimeout, if configured. When fetch fails because of this
+// deadline, and not because ctx itself is done, the returned error wraps
+// git.ErrFetchTimeout.
+func (o checkoutOptions) withFetchTimeout(ctx context.Context, fetch func(context.Context) error) error {
+	if o.FetchTimeout <= 0 {
+		return fetch(ctx)
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, o.FetchTimeout)
+	defer cancel()
+
+	err := fetch(fetchCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(fetchCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %s", git.ErrFetchTimeout, o.FetchTimeout, err)
+	}
+	return err
+}
+
+// isTransientError returns if the given error is caused by a temporary
+// network failure. Authentication and certificate errors are never
+// transient.
+func isTransientError(err error) bool {
+	if errors.Is(err, git.ErrFetchTimeout) {
+		return true
+	}
+	var gitErr *git.GitError
+	if errors.As(err, &gitErr) &&
+		(gitErr.Code == int(git2go.ErrorCodeAuth) || gitErr.Code == int(git2go.ErrorCodeCertificate)) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientErrorMessages {
+		if strings.Contains(msg, strings.ToLower(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchOrDisconnect fetches the refspecs from the remote, and disconnects it
+// when the fetch fails so that a retry starts with a new connection. The
+// managed transports of the remote are bound to the given context while
+// fetching, so that a stalled transfer is aborted once it is done. The
+// remote is only disconnected once the fetch has returned, as remotes must
+// not be used concurrently.
+func fetchOrDisconnect(ctx context.Context, remote *git2go.Remote, refspecs []string, opts *git2go.FetchOptions) error {
+	// The URL of the remote is the transport options URL.
+	defer managed.SetTransportOptionsContext(ctx, remote.Url())()
+	if err := remote.Fetch(refspecs, opts, ""); err != nil {
+		remote.Disconnect()
+		return libGit2Error(err)
+	}
+	return nil
+}
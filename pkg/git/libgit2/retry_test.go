@@ -0,0 +1,270 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func TestCheckoutOptions_withFetchRetries(t *testing.T) {
+	reset := &git.GitError{Message: "read tcp 127.0.0.1:1: read: connection reset by peer", Class: 12, Code: -1}
+	unauthorized := &git.GitError{Message: "i/o timeout", Class: 12, Code: -16}
+
+	tests := []struct {
+		name         string
+		retries      int
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "single attempt by default",
+			errs:         []error{reset, nil},
+			wantAttempts: 1,
+			wantErr:      reset,
+		},
+		{
+			name:         "retries transient errors",
+			retries:      2,
+			errs:         []error{reset, reset, nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after retries",
+			retries:      2,
+			errs:         []error{reset, reset, reset, nil},
+			wantAttempts: 3,
+			wantErr:      reset,
+		},
+		{
+			name:         "does not retry authentication errors",
+			retries:      2,
+			errs:         []error{unauthorized, nil},
+			wantAttempts: 1,
+			wantErr:      unauthorized,
+		},
+		{
+			name:         "does not retry missing references",
+			retries:      2,
+			errs:         []error{errors.New("reference 'refs/heads/foo' not found"), nil},
+			wantAttempts: 1,
+			wantErr:      errors.New("reference 'refs/heads/foo' not found"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			o := checkoutOptions{FetchRetries: tt.retries, FetchRetryDelay: time.Millisecond}
+			var attempts int
+			err := o.withFetchRetries(context.TODO(), "https://example.com", func(context.Context) error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+			g.Expect(attempts).To(Equal(tt.wantAttempts))
+			if tt.wantErr != nil {
+				g.Expect(err).To(MatchError(tt.wantErr.Error()))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestCheckoutOptions_withFetchRetries_contextDeadline(t *testing.T) {
+	g := NewWithT(t)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+
+	o := checkoutOptions{FetchRetries: 5, FetchRetryDelay: time.Hour}
+	var attempts int
+	start := time.Now()
+	err := o.withFetchRetries(ctx, "https://example.com", func(context.Context) error {
+		attempts++
+		return errors.New("i/o timeout")
+	})
+	g.Expect(err).To(MatchError("i/o timeout"))
+	g.Expect(attempts).To(Equal(1))
+	g.Expect(time.Since(start)).To(BeNumerically("<", time.Minute))
+}
+
+func TestCheckoutOptions_withFetchTimeout(t *testing.T) {
+	g := NewWithT(t)
+
+	o := checkoutOptions{FetchRetries: 1, FetchRetryDelay: time.Millisecond, FetchTimeout: 20 * time.Millisecond}
+	var attempts int
+	err := o.withFetchRetries(context.TODO(), "https://example.com", func(ctx context.Context) error {
+		attempts++
+		<-ctx.Done()
+		return errors.New("transfer aborted")
+	})
+	g.Expect(err).To(MatchError(git.ErrFetchTimeout))
+	g.Expect(err.Error()).To(ContainSubstring("transfer aborted"))
+	g.Expect(attempts).To(Equal(2))
+
+	// A fetch failing before the timeout is not reported as timed out.
+	err = o.withFetchTimeout(context.TODO(), func(context.Context) error {
+		return errors.New("reference not found")
+	})
+	g.Expect(err).To(MatchError("reference not found"))
+
+	// Neither is a fetch aborted because the parent context is done.
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err = o.withFetchTimeout(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return errors.New("transfer aborted")
+	})
+	g.Expect(errors.Is(err, git.ErrFetchTimeout)).To(BeFalse())
+}
+
+func TestCheckout_FetchRetries(t *testing.T) {
+	server, err := gittestserver.NewTempGitServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(server.Root())
+	if err = server.StartHTTP(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	if err = server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath); err != nil {
+		t.Fatal(err)
+	}
+	target, err := url.Parse(server.HTTPAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retryOpts := checkoutOptions{FetchRetries: 1, FetchRetryDelay: time.Millisecond}
+	tests := []struct {
+		name     string
+		strategy git.CheckoutStrategy
+		wantErr  bool
+	}{
+		{
+			name:     "branch",
+			strategy: &CheckoutBranch{Branch: git.DefaultBranch, checkoutOptions: retryOpts},
+		},
+		{
+			name:     "ref",
+			strategy: &CheckoutRef{Name: "refs/heads/" + git.DefaultBranch, checkoutOptions: retryOpts},
+		},
+		{
+			name:     "ref without retries",
+			strategy: &CheckoutRef{Name: "refs/heads/" + git.DefaultBranch},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			// Abort the response to the first fetch halfway through, which
+			// the client observes as a transient network failure.
+			var fetches int32
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/git-upload-pack") && atomic.AddInt32(&fetches, 1) == 1 {
+					w.Header().Set("Content-Length", "1024")
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.Write([]byte("0008NAK\n"))
+					panic(http.ErrAbortHandler)
+				}
+				proxy.ServeHTTP(w, r)
+			}))
+			defer flaky.Close()
+
+			authOpts := &git.AuthOptions{TransportOptionsURL: getTransportOptionsURL(git.HTTP)}
+			cc, err := tt.strategy.Checkout(context.TODO(), t.TempDir(), flaky.URL+"/"+repoPath, authOpts)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(atomic.LoadInt32(&fetches)).To(Equal(int32(1)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cc).ToNot(BeNil())
+			g.Expect(atomic.LoadInt32(&fetches)).To(Equal(int32(2)))
+		})
+	}
+}
+
+func Test_isTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "connection reset",
+			err:  &git.GitError{Message: "read tcp 127.0.0.1:1: read: connection reset by peer"},
+			want: true,
+		},
+		{
+			name: "wrapped timeout",
+			err:  fmt.Errorf("unable to fetch: %w", &git.GitError{Message: "net/http: TLS handshake timeout"}),
+			want: true,
+		},
+		{
+			name: "temporary DNS failure",
+			err:  &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true},
+			want: true,
+		},
+		{
+			name: "unknown host",
+			err:  &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true},
+			want: false,
+		},
+		{
+			name: "connection refused",
+			err:  &git.GitError{Message: "dial tcp 127.0.0.1:1: connect: connection refused"},
+			want: false,
+		},
+		{
+			name: "authentication failure",
+			err:  &git.GitError{Message: "connection reset", Code: -16},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(isTransientError(tt.err)).To(Equal(tt.want))
+		})
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-logr/logr"
+	git2go "github.com/libgit2/git2go/v33"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+// targetURLConfigKey is the repository configuration key the URL a
+// repository was checked out from is recorded under, as the URL of its
+// remote is a placeholder for the transport options.
+const targetURLConfigKey = "remote.origin.fluxTargetUrl"
+
+// reuseRepository fetches from the remote into the repository at the given
+// path, if ReuseRepository is set and the repository was checked out from the
+// same URL before. It returns nil if there is no such repository, after
+// removing anything at the path which can not be reused, so that it can be
+// replaced by a clean clone.
+func (o checkoutOptions) reuseRepository(ctx context.Context, path, url string, opts *git.AuthOptions, downloadTags git2go.DownloadTags) (*git2go.Repository, error) {
+	if !o.ReuseRepository {
+		return nil, nil
+	}
+	if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+		return nil, nil
+	}
+	if reason := unreusableReason(path, url); reason != "" {
+		logr.FromContextOrDiscard(ctx).Info("discarding repository at checkout path", "reason", reason)
+		if err := git.RemoveDirContents(path); err != nil {
+			return nil, fmt.Errorf("failed to clean up checkout path: %w", err)
+		}
+		return nil, nil
+	}
+
+	repo, remote, err := initializeRepoWithRemote(ctx, path, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer remote.Free()
+
+	// Tags deleted from the remote must not be considered, fetching them
+	// again is cheap as their objects are present.
+	if downloadTags == git2go.DownloadTagsAll {
+		if err = removeTags(repo); err != nil {
+			repo.Free()
+			return nil, err
+		}
+	}
+
+	// The refspecs configured for the remote equal those of a clone, which
+	// are replaced when refspecs are given.
+	var refspecs []string
+	if o.NotesRef != "" {
+		if refspecs, err = remote.FetchRefspecs(); err != nil {
+			repo.Free()
+			return nil, fmt.Errorf("unable to read refspecs of remote '%s': %w", url, libGit2Error(err))
+		}
+		refspecs = o.fetchRefspecs(refspecs...)
+	}
+	err = o.withFetchRetries(ctx, url, func(ctx context.Context) error {
+		return fetchOrDisconnect(ctx, remote, refspecs, &git2go.FetchOptions{
+			DownloadTags:    downloadTags,
+			Prune:           git2go.FetchPruneOn,
+			RemoteCallbacks: o.fetchCallbacks(ctx),
+		})
+	})
+	if err != nil {
+		repo.Free()
+		return nil, contextError(ctx, url, fmt.Errorf("unable to fetch remote '%s': %w", url, err))
+	}
+	return repo, nil
+}
+
+// unreusableReason returns why the repository at the given path can not be
+// reused for the given URL, or an empty string if it can.
+func unreusableReason(path, url string) string {
+	repo, err := git2go.OpenRepository(path)
+	if err != nil {
+		return fmt.Sprintf("unable to open repository: %s", libGit2Error(err))
+	}
+	defer repo.Free()
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Sprintf("unable to open repository config: %s", libGit2Error(err))
+	}
+	defer cfg.Free()
+	if recorded, err := cfg.LookupString(targetURLConfigKey); err != nil || recorded != url {
+		return "repository was checked out from a different URL"
+	}
+	return ""
+}
+
+// recordTargetURL records the URL the given repository was checked out from
+// if ReuseRepository is set, so that a next checkout can reuse it.
+func (o checkoutOptions) recordTargetURL(repo *git2go.Repository, url string) error {
+	if !o.ReuseRepository {
+		return nil
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("unable to open repository config: %w", libGit2Error(err))
+	}
+	defer cfg.Free()
+	if err = cfg.SetString(targetURLConfigKey, url); err != nil {
+		return fmt.Errorf("unable to set %s: %w", targetURLConfigKey, libGit2Error(err))
+	}
+	return nil
+}
+
+// removeTags removes all tags from the given repository.
+func removeTags(repo *git2go.Repository) error {
+	tags, err := repo.Tags.List()
+	if err != nil {
+		return fmt.Errorf("unable to list tags: %w", libGit2Error(err))
+	}
+	for _, t := range tags {
+		if err = repo.Tags.Remove(t); err != nil {
+			return fmt.Errorf("unable to remove tag '%s': %w", t, libGit2Error(err))
+		}
+	}
+	return nil
+}
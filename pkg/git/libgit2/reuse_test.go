@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	git2go "github.com/libgit2/git2go/v33"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func TestCheckout_ReuseRepository(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+	repo, err := git2go.OpenRepository(filepath.Join(server.Root(), repoPath))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+
+	first, err := commitFile(repo, "file", "first", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = tag(repo, first, false, "v1.0.0", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repoURL := server.HTTPAddress() + "/" + repoPath
+	authOpts := &git.AuthOptions{TransportOptionsURL: getTransportOptionsURL(git.HTTP)}
+	opts := checkoutOptions{ReuseRepository: true}
+	tmpDir := t.TempDir()
+
+	cc, err := (&CheckoutCommit{Commit: first.String(), checkoutOptions: opts}).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.Hash.String()).To(Equal(first.String()))
+
+	// Marker in the object database, which survives a fetch into the
+	// existing repository but not a clean clone.
+	marker := filepath.Join(tmpDir, ".git", "reuse-marker")
+	g.Expect(os.WriteFile(marker, nil, 0o644)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(tmpDir, "untracked"), nil, 0o644)).To(Succeed())
+
+	second, err := commitFile(repo, "file", "second", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	cc, err = (&CheckoutCommit{Commit: second.String(), checkoutOptions: opts}).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.Hash.String()).To(Equal(second.String()))
+	g.Expect(marker).To(BeAnExistingFile())
+	g.Expect(filepath.Join(tmpDir, "untracked")).ToNot(BeAnExistingFile())
+	g.Expect(os.ReadFile(filepath.Join(tmpDir, "file"))).To(BeEquivalentTo("second"))
+
+	// Tags removed from the remote are not considered.
+	_, err = tag(repo, second, false, "v2.0.0", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	cc, err = (&CheckoutSemVer{SemVer: "*", checkoutOptions: opts}).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.String()).To(Equal("v2.0.0/" + second.String()))
+	g.Expect(repo.Tags.Remove("v2.0.0")).To(Succeed())
+	cc, err = (&CheckoutSemVer{SemVer: "*", checkoutOptions: opts}).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.String()).To(Equal("v1.0.0/" + first.String()))
+	g.Expect(marker).To(BeAnExistingFile())
+
+	// A repository checked out from another URL is replaced.
+	checkout, err := git2go.OpenRepository(tmpDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(opts.recordTargetURL(checkout, "https://example.com/other")).To(Succeed())
+	checkout.Free()
+	cc, err = (&CheckoutCommit{Commit: first.String(), checkoutOptions: opts}).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.Hash.String()).To(Equal(first.String()))
+	g.Expect(marker).ToNot(BeAnExistingFile())
+
+	// A corrupt repository is replaced.
+	g.Expect(os.WriteFile(filepath.Join(tmpDir, ".git", "HEAD"), []byte("corrupt"), 0o644)).To(Succeed())
+	cc, err = (&CheckoutCommit{Commit: second.String(), checkoutOptions: opts}).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.Hash.String()).To(Equal(second.String()))
+	// A fetch exceeding the size limit leaves the reused repository alone.
+	g.Expect(os.WriteFile(marker, nil, 0o644)).To(Succeed())
+	large, err := commitFile(repo, "large", strings.Repeat("large", 1<<12), time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	limited := opts
+	limited.MaxSize = 64
+	cc, err = (&CheckoutCommit{Commit: large.String(), checkoutOptions: limited}).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+	g.Expect(errors.Is(err, git.ErrRepositorySizeExceeded)).To(BeTrue(), "unexpected error: %v", err)
+	g.Expect(cc).To(BeNil())
+	g.Expect(marker).To(BeAnExistingFile())
+}
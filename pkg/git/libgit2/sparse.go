@@ -0,0 +1,52 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"path"
+	"strings"
+)
+
+// sparsePathspec returns the SparsePaths as pathspecs limiting a checkout
+// or diff, which libgit2 matches against the given path prefixes. It returns
+// nil when the complete tree must be considered.
+func (o checkoutOptions) sparsePathspec() []string {
+	var paths []string
+	for _, p := range o.SparsePaths {
+		p = path.Clean(strings.Trim(p, "/"))
+		if p == "." {
+			return nil
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// inSparsePaths returns if the given path, relative to the root of the
+// repository, is written to the working tree with the SparsePaths.
+func (o checkoutOptions) inSparsePaths(p string) bool {
+	paths := o.sparsePathspec()
+	if paths == nil {
+		return true
+	}
+	for _, prefix := range paths {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") || strings.HasPrefix(prefix, p+"/") {
+			return true
+		}
+	}
+	return false
+}
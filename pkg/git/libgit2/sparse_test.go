@@ -0,0 +1,110 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	git2go "github.com/libgit2/git2go/v33"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func TestCheckoutOptions_inSparsePaths(t *testing.T) {
+	tests := []struct {
+		name        string
+		sparsePaths []string
+		path        string
+		want        bool
+	}{
+		{name: "no sparse paths", path: "any/file", want: true},
+		{name: "root", sparsePaths: []string{"/"}, path: "any/file", want: true},
+		{name: "exact path", sparsePaths: []string{"deploy/prod"}, path: "deploy/prod", want: true},
+		{name: "within prefix", sparsePaths: []string{"deploy/prod/"}, path: "deploy/prod/app", want: true},
+		{name: "parent of prefix", sparsePaths: []string{"deploy/prod"}, path: "deploy", want: true},
+		{name: "sibling", sparsePaths: []string{"deploy/prod"}, path: "deploy/production", want: false},
+		{name: "outside", sparsePaths: []string{"deploy/prod", "base"}, path: "docs", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			o := checkoutOptions{SparsePaths: tt.sparsePaths}
+			g.Expect(o.inSparsePaths(tt.path)).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestCheckout_SparsePaths(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+	repo, err := git2go.OpenRepository(filepath.Join(server.Root(), repoPath))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+	_, err = commitFile(repo, "deploy/staging/app.yaml", "staging", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	cId, err := commitFile(repo, "deploy/prod/app.yaml", "prod", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = tag(repo, cId, false, "v1.0.0", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	authOpts := &git.AuthOptions{TransportOptionsURL: getTransportOptionsURL(git.HTTP)}
+	repoURL := server.HTTPAddress() + "/" + repoPath
+
+	for _, opts := range []git.CheckoutOptions{
+		{Branch: git.DefaultBranch},
+		{Commit: cId.String()},
+		{Tag: "v1.0.0"},
+		{SemVer: "1.x"},
+	} {
+		g := NewWithT(t)
+
+		opts.SparsePaths = []string{"deploy/prod"}
+		opts.VerifyWorktree = true
+		tmpDir := t.TempDir()
+		cc, err := CheckoutStrategyForOptions(context.TODO(), opts).Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cc.Hash.String()).To(Equal(cId.String()))
+		g.Expect(os.ReadFile(filepath.Join(tmpDir, "deploy/prod/app.yaml"))).To(BeEquivalentTo("prod"))
+		g.Expect(filepath.Join(tmpDir, "deploy/staging")).ToNot(BeAnExistingFile())
+	}
+
+	t.Run("empty sparse paths", func(t *testing.T) {
+		g := NewWithT(t)
+
+		tmpDir := t.TempDir()
+		cs := CheckoutStrategyForOptions(context.TODO(), git.CheckoutOptions{Branch: git.DefaultBranch})
+		_, err := cs.Checkout(context.TODO(), tmpDir, repoURL, authOpts)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(filepath.Join(tmpDir, "deploy/staging/app.yaml")).To(BeARegularFile())
+		g.Expect(filepath.Join(tmpDir, "deploy/prod/app.yaml")).To(BeARegularFile())
+	})
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	git2go "github.com/libgit2/git2go/v33"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+// repositoryStats returns the statistics of the given repository if
+// CollectStats is set, or nil otherwise. It must be called before the
+// repository is freed.
+func (o checkoutOptions) repositoryStats(repo *git2go.Repository) (*git.RepositoryStats, error) {
+	if !o.CollectStats {
+		return nil, nil
+	}
+	stats := &git.RepositoryStats{}
+
+	odb, err := repo.Odb()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open object database: %w", libGit2Error(err))
+	}
+	defer odb.Free()
+	if err = odb.ForEach(func(oid *git2go.Oid) error {
+		stats.Objects++
+		size, t, err := odb.ReadHeader(oid)
+		if err != nil {
+			return fmt.Errorf("unable to read object header for '%s': %w", oid.String(), libGit2Error(err))
+		}
+		if t == git2go.ObjectBlob && int64(size) > stats.LargestBlob {
+			stats.LargestBlob = int64(size)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("unable to walk object database: %w", err)
+	}
+
+	iter, err := repo.NewReferenceIterator()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list references: %w", libGit2Error(err))
+	}
+	defer iter.Free()
+	for {
+		ref, err := iter.Next()
+		if git2go.IsErrorCode(err, git2go.ErrorCodeIterOver) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to list references: %w", libGit2Error(err))
+		}
+		ref.Free()
+		stats.Refs++
+	}
+
+	// Both loose objects and packs are stored in the objects directory.
+	err = filepath.WalkDir(filepath.Join(repo.Path(), "objects"), func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stats.Size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine size of object database: %w", err)
+	}
+	return stats, nil
+}
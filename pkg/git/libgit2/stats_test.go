@@ -0,0 +1,74 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	git2go "github.com/libgit2/git2go/v33"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func TestCheckout_CollectStats(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	repoPath := "test.git"
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, repoPath)).To(Succeed())
+	repo, err := git2go.OpenRepository(filepath.Join(server.Root(), repoPath))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+	c, err := commitFile(repo, "large", strings.Repeat("x", 4096), time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = tag(repo, c, false, "v1.0.0", time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repoURL := server.HTTPAddress() + "/" + repoPath
+	authOpts := &git.AuthOptions{TransportOptionsURL: getTransportOptionsURL(git.HTTP)}
+
+	for _, cs := range []git.CheckoutStrategy{
+		&CheckoutBranch{Branch: git.DefaultBranch, checkoutOptions: checkoutOptions{CollectStats: true}},
+		&CheckoutCommit{Commit: c.String(), checkoutOptions: checkoutOptions{CollectStats: true}},
+		&CheckoutSemVer{SemVer: "*", checkoutOptions: checkoutOptions{CollectStats: true}},
+	} {
+		cc, err := cs.Checkout(context.TODO(), t.TempDir(), repoURL, authOpts)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cc.Stats).ToNot(BeNil())
+		// At least the commit, its tree and the blob.
+		g.Expect(cc.Stats.Objects).To(BeNumerically(">=", 3))
+		g.Expect(cc.Stats.Size).To(BeNumerically(">", 0))
+		g.Expect(cc.Stats.Refs).To(BeNumerically(">=", 1))
+		g.Expect(cc.Stats.LargestBlob).To(BeNumerically(">=", 4096))
+	}
+
+	cc, err := (&CheckoutBranch{Branch: git.DefaultBranch}).Checkout(context.TODO(), t.TempDir(), repoURL, authOpts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cc.Stats).To(BeNil())
+}
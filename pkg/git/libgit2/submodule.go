@@ -0,0 +1,157 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	git2go "github.com/libgit2/git2go/v33"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+	"github.com/fluxcd/source-controller/pkg/git/libgit2/managed"
+)
+
+// maxSubmoduleDepth is the maximum depth of nested submodules which are
+// checked out when RecurseSubmodules is enabled.
+const maxSubmoduleDepth = 5
+
+// updateSubmodules initializes and checks out the submodules of the given
+// repository if RecurseSubmodules is enabled, including any nested
+// submodules. The submodules are fetched with the same auth options as the
+// repository itself, which was fetched from the given URL. Nothing is checked
+// out with ResolveOnly.
+func (o checkoutOptions) updateSubmodules(ctx context.Context, repo *git2go.Repository, url string, opts *git.AuthOptions) error {
+	if !o.RecurseSubmodules || o.ResolveOnly {
+		return nil
+	}
+	return o.updateSubmodulesAtDepth(ctx, repo, url, opts, 1)
+}
+
+func (o checkoutOptions) updateSubmodulesAtDepth(ctx context.Context, repo *git2go.Repository, url string, opts *git.AuthOptions, depth int) error {
+	var names []string
+	err := repo.Submodules.Foreach(func(sm *git2go.Submodule, name string) error {
+		// Submodules outside the SparsePaths are not written to the
+		// working tree, while those inside are checked out completely.
+		if depth == 1 && !o.inSparsePaths(sm.Path()) {
+			return nil
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list submodules: %w", libGit2Error(err))
+	}
+	if len(names) > 0 && depth > maxSubmoduleDepth {
+		return fmt.Errorf("submodules of '%s' exceed the maximum recursion depth of %d", url, maxSubmoduleDepth)
+	}
+
+	for _, name := range names {
+		if err = o.updateSubmodule(ctx, repo, name, url, opts, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSubmodule initializes and checks out the submodule with the given
+// name, and recurses into its own submodules.
+func (o checkoutOptions) updateSubmodule(ctx context.Context, repo *git2go.Repository, name, parentURL string, opts *git.AuthOptions, depth int) error {
+	sm, err := repo.Submodules.Lookup(name)
+	if err != nil {
+		return fmt.Errorf("unable to lookup submodule '%s': %w", name, libGit2Error(err))
+	}
+	targetURL, err := resolveSubmoduleURL(parentURL, sm.Url())
+	sm.Free()
+	if err != nil {
+		return fmt.Errorf("unable to resolve URL of submodule '%s': %w", name, err)
+	}
+
+	// The managed transports look up the target URL and credentials by the
+	// URL of the remote, register them for a unique placeholder URL and
+	// point the submodule at it.
+	smOpts := *opts
+	smOpts.TransportOptionsURL, err = submoduleTransportOptionsURL(opts.TransportOptionsURL, targetURL, name)
+	if err != nil {
+		return fmt.Errorf("unable to configure transport for submodule '%s': %w", name, err)
+	}
+	if _, err = registerManagedTransportOptions(ctx, targetURL, &smOpts); err != nil {
+		return err
+	}
+	defer managed.RemoveTransportOptions(smOpts.TransportOptionsURL)
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("unable to open repository config: %w", libGit2Error(err))
+	}
+	err = cfg.SetString(fmt.Sprintf("submodule.%s.url", name), smOpts.TransportOptionsURL)
+	cfg.Free()
+	if err != nil {
+		return fmt.Errorf("unable to configure URL of submodule '%s': %w", name, libGit2Error(err))
+	}
+
+	// Lookup the submodule again, to pick up the configured URL.
+	sm, err = repo.Submodules.Lookup(name)
+	if err != nil {
+		return fmt.Errorf("unable to lookup submodule '%s': %w", name, libGit2Error(err))
+	}
+	defer sm.Free()
+	err = sm.Update(true, &git2go.SubmoduleUpdateOptions{
+		CheckoutOptions: *o.checkoutStrategyOptions(),
+		FetchOptions: git2go.FetchOptions{
+			DownloadTags:    git2go.DownloadTagsNone,
+			RemoteCallbacks: remoteCallbacks(ctx),
+		},
+	})
+	if err != nil {
+		return contextError(ctx, targetURL, fmt.Errorf("unable to checkout submodule '%s' from '%s': %w", name, targetURL, libGit2Error(err)))
+	}
+
+	smRepo, err := sm.Open()
+	if err != nil {
+		return fmt.Errorf("unable to open submodule '%s': %w", name, libGit2Error(err))
+	}
+	defer smRepo.Free()
+	return o.updateSubmodulesAtDepth(ctx, smRepo, targetURL, &smOpts, depth+1)
+}
+
+// resolveSubmoduleURL returns the URL of a submodule, resolving URLs relative
+// to the URL of the parent repository.
+func resolveSubmoduleURL(parentURL, submoduleURL string) (string, error) {
+	if submoduleURL == "" {
+		return "", fmt.Errorf("submodule does not have a URL")
+	}
+	if !strings.HasPrefix(submoduleURL, "./") && !strings.HasPrefix(submoduleURL, "../") {
+		return submoduleURL, nil
+	}
+	u, err := url.Parse(parentURL)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse parent URL: %w", err)
+	}
+	u.Path = path.Join(u.Path, submoduleURL)
+	return u.String(), nil
+}
+
+// submoduleTransportOptionsURL returns a unique transport options URL for the
+// submodule with the given name, derived from the transport options URL of
+// the parent.
+func submoduleTransportOptionsURL(parentOptsURL, targetURL, name string) (string, error) {
+	return derivedTransportOptionsURL(parentOptsURL, targetURL, "submodules", name)
+}
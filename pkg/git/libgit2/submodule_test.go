@@ -0,0 +1,213 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libgit2
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/gittestserver"
+	git2go "github.com/libgit2/git2go/v33"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+func TestCheckout_RecurseSubmodules(t *testing.T) {
+	g := NewWithT(t)
+
+	server, err := gittestserver.NewTempGitServer()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(server.Root())
+	g.Expect(server.StartHTTP()).To(Succeed())
+	defer server.StopHTTP()
+
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, "sub.git")).To(Succeed())
+	subRepo, err := git2go.OpenRepository(filepath.Join(server.Root(), "sub.git"))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer subRepo.Free()
+	subCommit, err := headCommit(subRepo)
+	g.Expect(err).ToNot(HaveOccurred())
+	defer subCommit.Free()
+
+	g.Expect(server.InitRepo("../testdata/git/repo", git.DefaultBranch, "parent.git")).To(Succeed())
+	repo, err := git2go.OpenRepository(filepath.Join(server.Root(), "parent.git"))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer repo.Free()
+	g.Expect(commitSubmodule(repo, "sub", "../sub.git", subCommit.Id())).To(Succeed())
+
+	authOpts := git.AuthOptions{
+		TransportOptionsURL: getTransportOptionsURL(git.HTTP),
+	}
+	repoURL := server.HTTPAddress() + "/parent.git"
+
+	t.Run("with recursion", func(t *testing.T) {
+		g := NewWithT(t)
+
+		tmpDir := t.TempDir()
+		cs := CheckoutStrategyForOptions(context.TODO(), git.CheckoutOptions{
+			Branch:            git.DefaultBranch,
+			RecurseSubmodules: true,
+		})
+		_, err := cs.Checkout(context.TODO(), tmpDir, repoURL, &authOpts)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(filepath.Join(tmpDir, "sub", "foo.txt")).To(BeARegularFile())
+	})
+
+	t.Run("without recursion", func(t *testing.T) {
+		g := NewWithT(t)
+
+		tmpDir := t.TempDir()
+		cs := CheckoutStrategyForOptions(context.TODO(), git.CheckoutOptions{
+			Branch: git.DefaultBranch,
+		})
+		_, err := cs.Checkout(context.TODO(), tmpDir, repoURL, &authOpts)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(filepath.Join(tmpDir, "sub", "foo.txt")).ToNot(BeAnExistingFile())
+	})
+
+	t.Run("unreachable submodule", func(t *testing.T) {
+		g := NewWithT(t)
+
+		g.Expect(commitSubmodule(repo, "missing", "../missing.git", subCommit.Id())).To(Succeed())
+
+		cs := CheckoutStrategyForOptions(context.TODO(), git.CheckoutOptions{
+			Branch:            git.DefaultBranch,
+			RecurseSubmodules: true,
+		})
+		_, err := cs.Checkout(context.TODO(), t.TempDir(), repoURL, &authOpts)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("unable to checkout submodule 'missing'"))
+	})
+}
+
+func Test_resolveSubmoduleURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		parentURL    string
+		submoduleURL string
+		want         string
+		wantErr      string
+	}{
+		{
+			name:         "absolute URL",
+			parentURL:    "https://example.com/org/repo.git",
+			submoduleURL: "ssh://git@example.com/other/sub.git",
+			want:         "ssh://git@example.com/other/sub.git",
+		},
+		{
+			name:         "relative URL",
+			parentURL:    "https://example.com/org/repo.git",
+			submoduleURL: "../sub.git",
+			want:         "https://example.com/org/sub.git",
+		},
+		{
+			name:         "nested relative URL",
+			parentURL:    "https://example.com/org/repo.git",
+			submoduleURL: "./sub.git",
+			want:         "https://example.com/org/repo.git/sub.git",
+		},
+		{
+			name:    "empty URL",
+			wantErr: "submodule does not have a URL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := resolveSubmoduleURL(tt.parentURL, tt.submoduleURL)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
+
+func Test_submoduleTransportOptionsURL(t *testing.T) {
+	g := NewWithT(t)
+
+	got, err := submoduleTransportOptionsURL("http://name/uid/1", "ssh://git@example.com/sub.git", "vendor/sub")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(Equal("ssh://name/uid/1/submodules/vendor/sub"))
+
+	_, err = submoduleTransportOptionsURL("http://name/uid/1", "file:///tmp/sub.git", "sub")
+	g.Expect(err).To(HaveOccurred())
+}
+
+// commitSubmodule commits a submodule at the given path, pointing to the
+// given commit of the repository at url.
+func commitSubmodule(repo *git2go.Repository, path, url string, commit *git2go.Oid) error {
+	gitmodules := ""
+	head, err := headCommit(repo)
+	if err != nil {
+		return err
+	}
+	defer head.Free()
+	tree, err := head.Tree()
+	if err != nil {
+		return err
+	}
+	defer tree.Free()
+	if entry, err := tree.EntryByPath(".gitmodules"); err == nil {
+		blob, err := repo.LookupBlob(entry.Id)
+		if err != nil {
+			return err
+		}
+		gitmodules = string(blob.Contents())
+		blob.Free()
+	}
+	gitmodules += "[submodule \"" + path + "\"]\n\tpath = " + path + "\n\turl = " + url + "\n"
+
+	index, err := repo.Index()
+	if err != nil {
+		return err
+	}
+	defer index.Free()
+	if err = index.ReadTree(tree); err != nil {
+		return err
+	}
+	blobID, err := repo.CreateBlobFromBuffer([]byte(gitmodules))
+	if err != nil {
+		return err
+	}
+	for _, entry := range []*git2go.IndexEntry{
+		{Mode: git2go.FilemodeBlob, Id: blobID, Path: ".gitmodules"},
+		{Mode: git2go.FilemodeCommit, Id: commit, Path: path},
+	} {
+		if err = index.Add(entry); err != nil {
+			return err
+		}
+	}
+	treeID, err := index.WriteTree()
+	if err != nil {
+		return err
+	}
+	newTree, err := repo.LookupTree(treeID)
+	if err != nil {
+		return err
+	}
+	defer newTree.Free()
+	_, err = repo.CreateCommit("HEAD", mockSignature(time.Now()), mockSignature(time.Now()), "Adding submodule "+path, newTree, head)
+	return err
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package git
 
 import (
+	"context"
 	"fmt"
 	"net/url"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 )
@@ -41,17 +43,306 @@ type CheckoutOptions struct {
 	// SemVer tag expression to checkout, takes precedence over Tag.
 	SemVer string `json:"semver,omitempty"`
 
+	// LatestTag defines if the tag with the highest version should be
+	// checked out, takes precedence over Tag.
+	LatestTag bool
+
+	// TagPrefix limits SemVer and LatestTag to the tags with the given
+	// prefix, for example 'api/' for tags like 'api/v1.2.3', which is
+	// stripped before the remainder is parsed as a version.
+	TagPrefix string
+
+	// TagFilter limits SemVer and LatestTag to the tags with a name matching
+	// the given glob pattern, for example 'v1.*', before the commits of the
+	// tags are looked up. The pattern is matched against the complete tag
+	// name, including any TagPrefix, and a '*' does not match a '/'. This
+	// reduces the work done for repositories with many tags, without
+	// affecting which of the remaining tags is checked out. Not supported by
+	// all Implementations.
+	TagFilter string
+
+	// IgnorePrerelease excludes tags with a pre-release version, for example
+	// 'v1.4.0-rc.1', from SemVer and LatestTag, unless the SemVer constraint
+	// itself contains a pre-release version.
+	IgnorePrerelease bool
+
 	// Commit SHA1 to checkout, takes precedence over Tag and SemVer,
 	// can be combined with Branch with some Implementations.
 	Commit string
 
-	// RecurseSubmodules defines if submodules should be checked out,
-	// not supported by all Implementations.
+	// Name of the fully qualified reference to checkout, for example
+	// 'refs/pull/1/head', takes precedence over Commit, SemVer, Tag and
+	// Branch.
+	Name string
+
+	// RollbackCommit is a previously observed commit of Branch to check out,
+	// for example one recorded by an external ledger to roll back to. Unlike
+	// Commit, the checkout fails with a RefNotFoundError when the commit is no
+	// longer part of the history of the Branch at the remote. It takes
+	// precedence over Commit, SemVer and Tag. Implementations which do not
+	// support it fail the checkout with an UnsupportedOptionError.
+	RollbackCommit string
+
+	// RecurseSubmodules defines if submodules should be checked out.
 	RecurseSubmodules bool
 
 	// LastRevision holds the last observed revision of the local repository.
 	// It is used to skip clone operations when no changes were detected.
 	LastRevision string
+
+	// ShallowSince limits the fetched history to commits newer than the
+	// given time. When the Implementation or remote does not support
+	// shallow-since fetches, a full fetch is performed instead.
+	ShallowSince time.Time
+
+	// Depth limits the number of commits fetched for Branch and Tag
+	// checkouts. Zero retains the default behavior of the Implementation.
+	// When the remote does not support shallow clones, the checkout fails
+	// with ErrShallowNotSupported. The libgit2 Implementation ignores it
+	// and performs a full fetch, recording a warning on the Commit.
+	Depth int
+
+	// Filter is the partial clone filter specification, for example
+	// 'blob:none', to omit objects which are not required for the checked
+	// out commit. When the Implementation or remote does not support partial
+	// clones, all objects are fetched instead.
+	Filter string
+
+	// MaxFileSize is the maximum size in bytes of any single file in the
+	// tree of the commit being checked out, which is rejected with
+	// ErrFileTooLarge before the working tree is written. Zero means no
+	// limit.
+	MaxFileSize int64
+
+	// SecureCleanup defines if the files in the working tree should be
+	// overwritten before they are removed by Cleanup.
+	SecureCleanup bool
+
+	// NormalizeTimestamps defines if the author and committer timestamps of
+	// the returned Commit should be converted to UTC. By default, the
+	// original timezone offset of the signatures is preserved.
+	NormalizeTimestamps bool
+
+	// AllowedSignersPath is the path of an allowed_signers file in the
+	// repository, used to verify the SSH signature of the checked out
+	// commit against the tree of the commit itself. Implementations which
+	// do not support it fail the checkout with an UnsupportedOptionError.
+	AllowedSignersPath string
+
+	// AutoCRLF overrides the 'core.autocrlf' configuration used to write the
+	// working tree, and can be set to 'true', 'false' or 'input'. When empty,
+	// the configuration of the host applies. The text and eol settings in
+	// .gitattributes are honored regardless, unless DisableFilters is set.
+	// Not supported by all Implementations.
+	AutoCRLF string
+
+	// DisableFilters defines if line ending conversion and other filters
+	// should be skipped, writing files exactly as they are stored in the
+	// repository. Not supported by all Implementations.
+	DisableFilters bool
+
+	// IndexPath is the path of the file to write the Git index to while
+	// checking out, instead of the repository in the working directory. Its
+	// directory must be writable, and the file is removed once the checkout
+	// completes. Not supported by all Implementations.
+	IndexPath string
+
+	// PublicKeyRing is an armored PGP key ring to verify the signature of
+	// the checked out commit against. When set, unsigned commits and
+	// commits not signed by any of the keys are rejected with
+	// ErrCommitSignatureInvalid. Implementations which do not support it
+	// fail the checkout with an UnsupportedOptionError.
+	PublicKeyRing string
+
+	// SSHPublicKeys holds the SSH public keys in the authorized_keys format
+	// to verify SSH signatures of the checked out commit against. It can be
+	// combined with PublicKeyRing, in which case the format of the commit
+	// signature determines which keys it is verified with. Implementations
+	// which do not support it fail the checkout with an
+	// UnsupportedOptionError.
+	SSHPublicKeys string
+
+	// MirrorURLs are the URLs of mirrors of the repository, which are tried
+	// in order when the checkout from the URL of the repository fails on a
+	// network error. The credentials and known_hosts of the AuthOptions are
+	// not used for mirrors on a different host. Not supported by all
+	// Implementations.
+	MirrorURLs []string
+
+	// Progress is called with the progress of fetching objects from the
+	// remote, at most once per TransferProgressInterval and once the
+	// transfer completes. Not supported by all Implementations.
+	Progress ProgressFunc
+
+	// Metrics records the CheckoutMetrics of the checkout once it completes.
+	// Not supported by all Implementations.
+	Metrics MetricsRecorder
+
+	// RequireFastForward rejects a branch update with ErrNonFastForward when
+	// the new commit of the Branch is not a descendant of the commit of the
+	// LastRevision, for example after a force push rewriting its history.
+	// It has no effect when LastRevision is empty. Implementations which do
+	// not support it fail the checkout with an UnsupportedOptionError.
+	RequireFastForward bool
+
+	// ResolveBranches resolves the branches of the remote containing the
+	// Commit when it is checked out without a Branch. They are returned in
+	// Commit.Branches, and Commit.Reference is set to the branch when there
+	// is exactly one. This requires fetching all branches instead of only
+	// the Commit. Not supported by all Implementations.
+	ResolveBranches bool
+
+	// ReuseRepository defines if a repository at the checkout path, which
+	// was checked out from the same URL before, should be fetched into
+	// incrementally instead of being cloned again. Only applies to Commit
+	// and SemVer checkouts, not supported by all Implementations.
+	ReuseRepository bool
+
+	// RemoveGitMetadata defines if the .git directory should be removed from
+	// the checkout path once the checkout succeeds, leaving only the working
+	// tree. As this removes the repository, it can not be combined with
+	// ReuseRepository. Not supported by all Implementations.
+	RemoveGitMetadata bool
+
+	// MaxSize is the maximum number of bytes received from the remote while
+	// fetching, after which the checkout is aborted with
+	// ErrRepositorySizeExceeded. Zero means no limit. Not supported by all
+	// Implementations.
+	MaxSize int64
+
+	// FetchRetries is the number of times fetching from the remote is
+	// retried after a transient network failure, for example a connection
+	// reset or timeout. Zero means a single attempt is made. Not supported
+	// by all Implementations.
+	FetchRetries int
+
+	// FetchRetryDelay is the delay before the first retry of a fetch, which
+	// is doubled for every next retry. When zero, DefaultFetchRetryDelay is
+	// used. Retries never extend beyond the deadline of the context of the
+	// checkout.
+	FetchRetryDelay time.Duration
+
+	// FetchTimeout is the maximum duration of a single fetch from the
+	// remote, including a clone, after which it is aborted with
+	// ErrFetchTimeout. Every retry gets the full duration again. Zero means
+	// only the deadline of the context of the checkout applies. Not
+	// supported by all Implementations.
+	FetchTimeout time.Duration
+
+	// NotesRef is the full name of the git notes reference to fetch along
+	// with the commit, for example 'refs/notes/approvals'. The note attached
+	// to the checked out commit is returned in Commit.Notes. Not supported by
+	// all Implementations.
+	NotesRef string
+
+	// VerifyWorktree compares the working tree to the tree of the checked
+	// out commit once it has been written, and fails the checkout with
+	// ErrWorktreeMismatch if any file differs. This catches partially
+	// written working trees at the cost of an extra walk of the tree. Not
+	// supported by all Implementations.
+	VerifyWorktree bool
+
+	// CollectStats gathers the RepositoryStats of the repository once it
+	// has been checked out, and returns them in Commit.Stats. This requires
+	// walking the complete object database. Not supported by all
+	// Implementations.
+	CollectStats bool
+
+	// CheckoutMode defines how files already present in the working tree
+	// are treated when it is written, for example when a repository is
+	// reused. Defaults to CheckoutModeForce. Not supported by all
+	// Implementations.
+	CheckoutMode CheckoutMode
+
+	// SparsePaths limits the files written to the working directory to the
+	// given path prefixes, relative to the root of the repository, for
+	// example 'deploy/production'. The returned Commit still describes the
+	// complete commit. When empty, all files are written. Not supported by
+	// all Implementations.
+	SparsePaths []string
+
+	// ResolveOnly fetches the repository and resolves the reference to a
+	// commit, without writing the working tree. Contrary to the LastRevision
+	// check, the remote is always fetched, and the returned Commit is
+	// complete. Submodules are not checked out.
+	ResolveOnly bool
+}
+
+// CheckoutMode defines how a checkout treats the files in the working tree.
+type CheckoutMode string
+
+const (
+	// CheckoutModeForce overwrites modified files, making the working tree
+	// match the commit.
+	CheckoutModeForce CheckoutMode = "force"
+	// CheckoutModeSafe only updates unmodified files, and fails with
+	// ErrCheckoutConflict when a modified file would have to be updated.
+	// Files which were deleted from the working tree are not recreated.
+	CheckoutModeSafe CheckoutMode = "safe"
+	// CheckoutModeRecreateMissing equals CheckoutModeSafe, but recreates
+	// files which were deleted from the working tree.
+	CheckoutModeRecreateMissing CheckoutMode = "recreate-missing"
+)
+
+// DefaultFetchRetryDelay is the delay before the first retry of a fetch when
+// CheckoutOptions.FetchRetryDelay is not set.
+const DefaultFetchRetryDelay = time.Second
+
+// TransferProgressInterval is the minimum interval between two calls of
+// CheckoutOptions.Progress while objects are being fetched.
+const TransferProgressInterval = time.Second
+
+// TransferProgress describes the progress of fetching objects from a remote.
+type TransferProgress struct {
+	// TotalObjects is the number of objects to receive.
+	TotalObjects uint
+	// IndexedObjects is the number of received objects which are indexed.
+	IndexedObjects uint
+	// ReceivedObjects is the number of objects received so far.
+	ReceivedObjects uint
+	// LocalObjects is the number of objects which were available locally.
+	LocalObjects uint
+	// TotalDeltas is the number of deltas in the received pack.
+	TotalDeltas uint
+	// ReceivedBytes is the number of bytes received so far.
+	ReceivedBytes uint
+}
+
+// ProgressFunc receives the TransferProgress of a fetch.
+type ProgressFunc func(TransferProgress)
+
+// CheckoutOutcome describes how a checkout completed.
+type CheckoutOutcome string
+
+const (
+	// CheckoutOutcomeShortCircuited is the outcome of a checkout which
+	// returned a partial commit, as the LastRevision was still current.
+	CheckoutOutcomeShortCircuited CheckoutOutcome = "short-circuited"
+	// CheckoutOutcomeFull is the outcome of a checkout which fetched objects
+	// and wrote the working tree.
+	CheckoutOutcomeFull CheckoutOutcome = "full"
+	// CheckoutOutcomeError is the outcome of a failed checkout.
+	CheckoutOutcomeError CheckoutOutcome = "error"
+)
+
+// CheckoutMetrics describes a completed checkout.
+type CheckoutMetrics struct {
+	// Implementation is the Implementation which performed the checkout.
+	Implementation Implementation
+	// Strategy is the kind of checkout, for example 'branch' or 'semver'.
+	Strategy string
+	// Host is the host of the URL the checkout was performed from.
+	Host string
+	// Outcome describes how the checkout completed.
+	Outcome CheckoutOutcome
+	// Duration is the time the checkout took.
+	Duration time.Duration
+}
+
+// MetricsRecorder records the CheckoutMetrics of every checkout, for
+// example as Prometheus metrics.
+type MetricsRecorder interface {
+	RecordCheckout(CheckoutMetrics)
 }
 
 type TransportType string
@@ -71,7 +362,10 @@ type AuthOptions struct {
 	Password   string
 	Identity   []byte
 	KnownHosts []byte
-	CAFile     []byte
+	// CAFile is the PEM encoded CA bundle to verify the TLS certificate of
+	// the remote against, instead of the system trust store. It only
+	// applies to the operations using these AuthOptions.
+	CAFile []byte
 	// TransportOptionsURL is a unique identifier for this set of authentication
 	// options. It's used by managed libgit2 transports to uniquely identify
 	// which credentials to use for a particular Git operation, and avoid misuse
@@ -81,7 +375,111 @@ type AuthOptions struct {
 	// It's a field of AuthOptions despite not providing any kind of authentication
 	// info, as it's the only way to sneak it into git.Checkout, without polluting
 	// it's args and keeping it generic.
+	// When empty, a unique one is generated for every operation, which
+	// allows checking out public repositories without any authentication.
 	TransportOptionsURL string
+	// PackChecksumHeader is the name of the HTTP response header in which the
+	// server provides the SHA-1 checksum of the packfile it sends, for example
+	// set by a mirror or proxy in front of a plain HTTP Git server. When set,
+	// and the header is present on the response, the received packfile is
+	// verified against it. This is a no-op for servers which do not provide
+	// the header, and for SSH. Not supported by all Implementations.
+	PackChecksumHeader string
+	// HostKeyAlgos are the host key algorithms accepted from the SSH server,
+	// in order of preference. When set, they take precedence over the
+	// HostKeyAlgos configured for all SSH connections. Not supported by all
+	// Implementations.
+	HostKeyAlgos []string
+	// Proxy configures the proxy to connect to the remote origin through.
+	// When nil, the proxy is determined from the environment. Not supported
+	// by all Implementations.
+	Proxy *ProxyOptions
+	// CredentialsProvider returns the username and password to authenticate
+	// with against HTTP(S) remotes, taking precedence over Username and
+	// Password. The returned Credentials are reused for the connections
+	// made to the same URL within a checkout until they expire, and are
+	// requested again when the remote rejects them, which allows
+	// short-lived tokens to be rotated during a checkout. Not supported by
+	// all Implementations.
+	CredentialsProvider CredentialsProvider
+	// HTTPTransport tunes the connections made to HTTP(S) remotes, for
+	// example for networks which drop idle connections. When nil, the
+	// defaults of the transport apply. Not supported by all Implementations.
+	HTTPTransport *HTTPTransportOptions
+	// URLRewrite rewrites the URL of the remote right before the transport
+	// connects to it, for example to redirect clones from a public host to
+	// a mirror. The credentials, known_hosts and TLS configuration of the
+	// AuthOptions are used unchanged, and the returned Commit is not
+	// affected. Not supported by all Implementations.
+	URLRewrite URLRewriteFunc
+}
+
+// URLRewriteFunc returns the URL to connect to instead of the given URL of a
+// remote, or the given URL itself to connect to it unchanged. It must be
+// safe for concurrent use.
+type URLRewriteFunc func(url string) (string, error)
+
+// HTTPTransportOptions tunes the connections of the transport to HTTP(S)
+// remotes. With libgit2, they apply to all operations of the managed HTTP
+// transport: clones and fetches of all checkout strategies and submodules,
+// and the listing of remote references by Discover, ListRemoteRefs,
+// RefExists and the LastRevision checks of the checkout strategies.
+type HTTPTransportOptions struct {
+	// IdleConnTimeout is the maximum duration an idle connection is kept
+	// open for reuse. Zero keeps the default of the transport.
+	IdleConnTimeout time.Duration
+	// ResponseHeaderTimeout is the maximum duration to wait for the headers
+	// of a response after a request has been written. Zero means no
+	// timeout.
+	ResponseHeaderTimeout time.Duration
+	// DisableKeepAlives uses a new connection for every request, instead
+	// of reusing idle connections.
+	DisableKeepAlives bool
+}
+
+// CredentialsProvider returns the Credentials for the remote described by
+// the CredentialsRequest. It must be safe for concurrent use, as multiple
+// checkouts may connect to remotes at the same time.
+type CredentialsProvider func(ctx context.Context, req CredentialsRequest) (Credentials, error)
+
+// CredentialsRequest describes the remote a CredentialsProvider is asked to
+// return Credentials for.
+type CredentialsRequest struct {
+	// URL of the remote the connection is made to, before it is rewritten
+	// by the URLRewrite of the AuthOptions.
+	URL string
+	// Username configured in the AuthOptions, if any, as a hint to select
+	// the credentials with.
+	Username string
+	// Reauthenticate is true when the remote rejected the credentials
+	// previously returned for the connection, for example because they
+	// expired.
+	Reauthenticate bool
+}
+
+// Credentials to authenticate with against a remote. When both are empty,
+// no authentication is attempted.
+type Credentials struct {
+	Username string
+	Password string
+	// ExpiresAt is the time the credentials expire at, after which they
+	// are requested again instead of being reused within the same
+	// checkout. The zero value means they do not expire during a checkout.
+	ExpiresAt time.Time
+}
+
+// ProxyOptions are the options for connecting to a remote origin through an
+// HTTP proxy.
+type ProxyOptions struct {
+	// URL of the proxy, e.g. "http://proxy.example.com:3128".
+	URL string
+	// Username to authenticate with against the proxy.
+	Username string
+	// Password to authenticate with against the proxy.
+	Password string
+	// NoProxy disables the use of any proxy, including the one configured
+	// in the environment. It takes precedence over URL.
+	NoProxy bool
 }
 
 // KexAlgos hosts the key exchange algorithms to be used for SSH connections.
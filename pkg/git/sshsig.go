@@ -0,0 +1,307 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshSignatureArmorStart = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureArmorEnd   = "-----END SSH SIGNATURE-----"
+	sshSignatureMagic      = "SSHSIG"
+	sshSignatureVersion    = 1
+	// sshSignatureNamespace is the namespace Git uses when signing commits
+	// and tags with SSH keys.
+	sshSignatureNamespace = "git"
+)
+
+// sshSignature is a parsed SSHSIG signature, as produced by
+// 'ssh-keygen -Y sign'.
+// Ref: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+type sshSignature struct {
+	PublicKey     ssh.PublicKey
+	Namespace     string
+	HashAlgorithm string
+	Signature     *ssh.Signature
+}
+
+// parseSSHSignature parses the given armored SSHSIG signature.
+func parseSSHSignature(armored string) (*sshSignature, error) {
+	s := strings.TrimSpace(armored)
+	if !strings.HasPrefix(s, sshSignatureArmorStart) || !strings.HasSuffix(s, sshSignatureArmorEnd) {
+		return nil, fmt.Errorf("not an armored SSH signature")
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, sshSignatureArmorStart), sshSignatureArmorEnd)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode SSH signature: %w", err)
+	}
+	if !bytes.HasPrefix(blob, []byte(sshSignatureMagic)) {
+		return nil, fmt.Errorf("invalid SSH signature magic preamble")
+	}
+
+	var w struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err = ssh.Unmarshal(blob[len(sshSignatureMagic):], &w); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal SSH signature: %w", err)
+	}
+	if w.Version != sshSignatureVersion {
+		return nil, fmt.Errorf("unsupported SSH signature version %d", w.Version)
+	}
+	pub, err := ssh.ParsePublicKey(w.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH signature public key: %w", err)
+	}
+	sig := new(ssh.Signature)
+	if err = ssh.Unmarshal(w.Signature, sig); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal SSH signature blob: %w", err)
+	}
+	return &sshSignature{
+		PublicKey:     pub,
+		Namespace:     w.Namespace,
+		HashAlgorithm: w.HashAlgorithm,
+		Signature:     sig,
+	}, nil
+}
+
+// verify checks the signature was made over the given message by the
+// embedded public key.
+func (s *sshSignature) verify(message []byte) error {
+	var h hash.Hash
+	switch s.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported SSH signature hash algorithm '%s'", s.HashAlgorithm)
+	}
+	h.Write(message)
+
+	signed := ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{
+		Namespace:     s.Namespace,
+		HashAlgorithm: s.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})
+	return s.PublicKey.Verify(append([]byte(sshSignatureMagic), signed...), s.Signature)
+}
+
+// parseAuthorizedKeys parses the public keys from the given data in the
+// authorized_keys format, ignoring any options and comments.
+func parseAuthorizedKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for rest := data; len(bytes.TrimSpace(rest)) > 0; {
+		key, _, _, r, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		rest = r
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found")
+	}
+	return keys, nil
+}
+
+// allowedSigner is a single entry of an allowed_signers file.
+// Ref: https://man.openbsd.org/ssh-keygen.1#ALLOWED_SIGNERS
+type allowedSigner struct {
+	Principals  []string
+	Namespaces  []string
+	ValidAfter  time.Time
+	ValidBefore time.Time
+	PublicKey   ssh.PublicKey
+}
+
+// allows returns if the entry allows the given principal to sign within the
+// given namespace at the given time.
+func (s allowedSigner) allows(principal, namespace string, at time.Time) bool {
+	if !matchPatternList(principal, s.Principals) {
+		return false
+	}
+	if len(s.Namespaces) > 0 && !matchPatternList(namespace, s.Namespaces) {
+		return false
+	}
+	if !s.ValidAfter.IsZero() && at.Before(s.ValidAfter) {
+		return false
+	}
+	if !s.ValidBefore.IsZero() && at.After(s.ValidBefore) {
+		return false
+	}
+	return true
+}
+
+// parseAllowedSigners parses the given allowed_signers file contents.
+// Certificate authority entries are ignored, as certificate based signatures
+// are not supported.
+func parseAllowedSigners(data []byte) ([]allowedSigner, error) {
+	var signers []allowedSigner
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		signer, ok, err := parseAllowedSignersLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if ok {
+			signers = append(signers, signer)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return signers, nil
+}
+
+func parseAllowedSignersLine(line string) (allowedSigner, bool, error) {
+	var signer allowedSigner
+
+	var principals, rest string
+	if strings.HasPrefix(line, `"`) {
+		end := strings.Index(line[1:], `"`)
+		if end < 0 {
+			return signer, false, fmt.Errorf("unterminated quoted principals")
+		}
+		principals, rest = line[1:end+1], line[end+2:]
+	} else {
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return signer, false, fmt.Errorf("missing public key")
+		}
+		principals, rest = line[:i], line[i:]
+	}
+	signer.Principals = strings.Split(principals, ",")
+
+	pub, _, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(rest)))
+	if err != nil {
+		return signer, false, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	signer.PublicKey = pub
+
+	for _, o := range options {
+		name, value, _ := strings.Cut(o, "=")
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(name) {
+		case "cert-authority":
+			return signer, false, nil
+		case "namespaces":
+			signer.Namespaces = strings.Split(value, ",")
+		case "valid-after":
+			if signer.ValidAfter, err = parseAllowedSignersTime(value); err != nil {
+				return signer, false, fmt.Errorf("invalid valid-after option: %w", err)
+			}
+		case "valid-before":
+			if signer.ValidBefore, err = parseAllowedSignersTime(value); err != nil {
+				return signer, false, fmt.Errorf("invalid valid-before option: %w", err)
+			}
+		default:
+			return signer, false, fmt.Errorf("unsupported option '%s'", name)
+		}
+	}
+	return signer, true, nil
+}
+
+// parseAllowedSignersTime parses a YYYYMMDD[HHMM[SS]] timestamp, which is
+// interpreted in UTC when suffixed with 'Z', and in local time otherwise.
+func parseAllowedSignersTime(s string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(s, "Z") || strings.HasSuffix(s, "z") {
+		s, loc = s[:len(s)-1], time.UTC
+	}
+	var layout string
+	switch len(s) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("malformed timestamp '%s'", s)
+	}
+	return time.ParseInLocation(layout, s, loc)
+}
+
+// matchPatternList reports whether s matches the given list of patterns, as
+// implemented by OpenSSH. Patterns may contain '*' and '?' wildcards, and a
+// match of a pattern prefixed with '!' negates the result for the whole list.
+func matchPatternList(s string, patterns []string) bool {
+	var matched bool
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			if matchPattern(s, p[1:]) {
+				return false
+			}
+			continue
+		}
+		if matchPattern(s, p) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPattern reports whether s matches the given wildcard pattern.
+func matchPattern(s, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchPattern(s[i:], pattern[1:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		s, pattern = s[1:], pattern[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCommit_VerifySSHAllowedSigners(t *testing.T) {
+	g := NewWithT(t)
+
+	signer := newSSHSigner(g)
+	other := newSSHSigner(g)
+
+	when := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	commit := func(namespace string, s ssh.Signer) *Commit {
+		return &Commit{
+			Committer: Signature{Email: "jane@example.com", When: when},
+			Encoded:   []byte(encodedCommitFixture),
+			Signature: sshSign(g, s, namespace, []byte(encodedCommitFixture)),
+		}
+	}
+	allowed := func(s ssh.Signer, prefix string) []byte {
+		return []byte(prefix + " " + string(ssh.MarshalAuthorizedKey(s.PublicKey())))
+	}
+
+	tests := []struct {
+		name           string
+		commit         *Commit
+		allowedSigners []byte
+		wantErr        string
+	}{
+		{
+			name:           "allowed signer",
+			commit:         commit("git", signer),
+			allowedSigners: allowed(signer, "jane@example.com"),
+		},
+		{
+			name:           "allowed signer with wildcard principal and options",
+			commit:         commit("git", signer),
+			allowedSigners: allowed(signer, `*@example.com namespaces="git",valid-after="20220101",valid-before="20230101Z"`),
+		},
+		{
+			name:           "principal does not match committer",
+			commit:         commit("git", signer),
+			allowedSigners: allowed(signer, "john@example.com"),
+			wantErr:        "is not an allowed signer for 'jane@example.com'",
+		},
+		{
+			name:           "negated principal",
+			commit:         commit("git", signer),
+			allowedSigners: allowed(signer, "*@example.com,!jane@example.com"),
+			wantErr:        "is not an allowed signer",
+		},
+		{
+			name:           "key not valid yet",
+			commit:         commit("git", signer),
+			allowedSigners: allowed(signer, `jane@example.com valid-after="20220602Z"`),
+			wantErr:        "is not an allowed signer",
+		},
+		{
+			name:           "key expired",
+			commit:         commit("git", signer),
+			allowedSigners: allowed(signer, `jane@example.com valid-before="202205311200Z"`),
+			wantErr:        "is not an allowed signer",
+		},
+		{
+			name:           "namespace not allowed",
+			commit:         commit("git", signer),
+			allowedSigners: allowed(signer, `jane@example.com namespaces="file"`),
+			wantErr:        "is not an allowed signer",
+		},
+		{
+			name:           "signed by other key",
+			commit:         commit("git", other),
+			allowedSigners: allowed(signer, "jane@example.com"),
+			wantErr:        "is not an allowed signer",
+		},
+		{
+			name:           "signature namespace is not git",
+			commit:         commit("file", signer),
+			allowedSigners: allowed(signer, "jane@example.com"),
+			wantErr:        "unexpected SSH signature namespace 'file'",
+		},
+		{
+			name: "tampered commit",
+			commit: func() *Commit {
+				c := commit("git", signer)
+				c.Encoded = []byte(malformedEncodedCommitFixture)
+				return c
+			}(),
+			allowedSigners: allowed(signer, "jane@example.com"),
+			wantErr:        "failed to verify SSH signature",
+		},
+		{
+			name:           "malformed allowed signers",
+			commit:         commit("git", signer),
+			allowedSigners: []byte("# comment\n\njane@example.com invalid"),
+			wantErr:        "failed to parse allowed signers: line 3",
+		},
+		{
+			name: "PGP signature",
+			commit: &Commit{
+				Encoded:   []byte(encodedCommitFixture),
+				Signature: signatureCommitFixture,
+			},
+			allowedSigners: allowed(signer, "jane@example.com"),
+			wantErr:        "failed to parse SSH signature: not an armored SSH signature",
+		},
+		{
+			name:           "missing signature",
+			commit:         &Commit{Encoded: []byte(encodedCommitFixture)},
+			allowedSigners: allowed(signer, "jane@example.com"),
+			wantErr:        "commit does not have a SSH signature",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			got, err := tt.commit.VerifySSHAllowedSigners(tt.allowedSigners)
+			if tt.wantErr != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantErr))
+				g.Expect(got).To(BeEmpty())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(ssh.FingerprintSHA256(signer.PublicKey())))
+		})
+	}
+}
+
+func Test_parseAllowedSigners(t *testing.T) {
+	g := NewWithT(t)
+
+	pub := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newSSHSigner(g).PublicKey())))
+	data := fmt.Sprintf(`# comment
+jane@example.com,john@example.com %[1]s
+"*@example.com" namespaces="git,file",valid-after="20220101Z" %[1]s jane
+*@example.com cert-authority %[1]s
+`, pub)
+
+	signers, err := parseAllowedSigners([]byte(data))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(signers).To(HaveLen(2))
+	g.Expect(signers[0].Principals).To(Equal([]string{"jane@example.com", "john@example.com"}))
+	g.Expect(signers[0].Namespaces).To(BeEmpty())
+	g.Expect(signers[1].Principals).To(Equal([]string{"*@example.com"}))
+	g.Expect(signers[1].Namespaces).To(Equal([]string{"git", "file"}))
+	g.Expect(signers[1].ValidAfter).To(Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	_, err = parseAllowedSigners([]byte("jane@example.com unknown-option " + pub))
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("unsupported option 'unknown-option'"))
+}
+
+func Test_matchPatternList(t *testing.T) {
+	tests := []struct {
+		s        string
+		patterns []string
+		want     bool
+	}{
+		{s: "jane@example.com", patterns: []string{"jane@example.com"}, want: true},
+		{s: "jane@example.com", patterns: []string{"*@example.com"}, want: true},
+		{s: "jane@example.com", patterns: []string{"j?ne@*"}, want: true},
+		{s: "jane@example.com", patterns: []string{"*@example.org"}, want: false},
+		{s: "jane@example.com", patterns: []string{"*", "!jane@*"}, want: false},
+		{s: "jane@example.com", patterns: []string{"!john@*"}, want: false},
+		{s: "jane@example.com", patterns: []string{"!john@*", "*"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %v", tt.s, tt.patterns), func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(matchPatternList(tt.s, tt.patterns)).To(Equal(tt.want))
+		})
+	}
+}
+
+func newSSHSigner(g *WithT) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	signer, err := ssh.NewSignerFromKey(priv)
+	g.Expect(err).ToNot(HaveOccurred())
+	return signer
+}
+
+// sshSign creates an armored SSHSIG signature of the message, equal to
+// 'ssh-keygen -Y sign -n <namespace>'.
+func sshSign(g *WithT, signer ssh.Signer, namespace string, message []byte) string {
+	h := sha512.Sum512(message)
+	signed := ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{Namespace: namespace, HashAlgorithm: "sha512", Hash: h[:]})
+	sig, err := signer.Sign(rand.Reader, append([]byte(sshSignatureMagic), signed...))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	blob := ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{
+		Version:       sshSignatureVersion,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})
+	return fmt.Sprintf("%s\n%s\n%s", sshSignatureArmorStart,
+		base64.StdEncoding.EncodeToString(append([]byte(sshSignatureMagic), blob...)), sshSignatureArmorEnd)
+}
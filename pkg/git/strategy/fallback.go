@@ -0,0 +1,120 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-logr/logr"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+	"github.com/fluxcd/source-controller/pkg/git/gogit"
+	"github.com/fluxcd/source-controller/pkg/git/libgit2"
+)
+
+// FallbackCondition matches a git.GitError returned by the primary
+// CheckoutStrategy of a fallback strategy.
+type FallbackCondition struct {
+	// Class is the git.GitError class to match.
+	Class int
+	// Code is the git.GitError code to match. When zero, any code of the
+	// Class matches.
+	Code int
+}
+
+// matches returns if the given error is a git.GitError matching the
+// condition.
+func (c FallbackCondition) matches(err error) bool {
+	var gitErr *git.GitError
+	if !errors.As(err, &gitErr) {
+		return false
+	}
+	return gitErr.Class == c.Class && (c.Code == 0 || gitErr.Code == c.Code)
+}
+
+// CheckoutStrategyWithFallback returns a CheckoutStrategy for the libgit2
+// Implementation, which falls back to the go-git Implementation when libgit2
+// returns an error matching any of the given conditions. Without conditions,
+// the plain libgit2 CheckoutStrategy is returned.
+func CheckoutStrategyWithFallback(ctx context.Context, opts git.CheckoutOptions, conditions ...FallbackCondition) git.CheckoutStrategy {
+	primary := libgit2.CheckoutStrategyForOptions(ctx, opts)
+	if len(conditions) == 0 {
+		return primary
+	}
+	return &FallbackCheckoutStrategy{
+		Primary:    primary,
+		Fallback:   gogit.CheckoutStrategyForOptions(ctx, opts),
+		Conditions: conditions,
+	}
+}
+
+// FallbackCheckoutStrategy is a CheckoutStrategy which retries a failed
+// checkout of the Primary strategy using the Fallback strategy, when the
+// error matches any of the Conditions.
+type FallbackCheckoutStrategy struct {
+	// Primary is the CheckoutStrategy attempted first.
+	Primary git.CheckoutStrategy
+	// Fallback is the CheckoutStrategy attempted when Primary fails with an
+	// error matching any of the Conditions.
+	Fallback git.CheckoutStrategy
+	// Conditions holds the errors to fall back on.
+	Conditions []FallbackCondition
+}
+
+// Checkout performs the checkout using the Primary strategy, and retries it
+// using the Fallback strategy if the error matches any of the Conditions.
+// Anything the Primary strategy wrote to the path is removed before the
+// Fallback strategy is attempted. It does not fall back when the path had
+// content before the checkout, or when the Fallback strategy can not honour
+// the checkout options, and returns the error of the Primary strategy instead.
+func (s *FallbackCheckoutStrategy) Checkout(ctx context.Context, path, url string, opts *git.AuthOptions) (*git.Commit, error) {
+	state := git.RecordPathState(path)
+	c, err := s.Primary.Checkout(ctx, path, url, opts)
+	if err == nil || !s.shouldFallback(err) {
+		return c, err
+	}
+	if !state.Owned() {
+		logr.FromContextOrDiscard(ctx).Info("not falling back to alternative Git implementation, checkout path was not empty",
+			"error", err.Error())
+		return nil, err
+	}
+
+	logr.FromContextOrDiscard(ctx).Info("falling back to alternative Git implementation after checkout failure",
+		"error", err.Error())
+	if cErr := state.Restore(); cErr != nil {
+		return nil, fmt.Errorf("failed to clean up checkout path after '%s': %w", err, cErr)
+	}
+	c, fErr := s.Fallback.Checkout(ctx, path, url, opts)
+	var unsupportedErr *git.UnsupportedOptionError
+	if errors.As(fErr, &unsupportedErr) {
+		logr.FromContextOrDiscard(ctx).Info("alternative Git implementation can not honour the checkout options",
+			"error", fErr.Error())
+		return nil, err
+	}
+	return c, fErr
+}
+
+func (s *FallbackCheckoutStrategy) shouldFallback(err error) bool {
+	for _, c := range s.Conditions {
+		if c.matches(err) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/source-controller/pkg/git"
+)
+
+type mockCheckoutStrategy struct {
+	commit *git.Commit
+	err    error
+	called bool
+}
+
+func (m *mockCheckoutStrategy) Checkout(_ context.Context, path, _ string, _ *git.AuthOptions) (*git.Commit, error) {
+	m.called = true
+	if err := os.MkdirAll(path, 0o750); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(path, "leftover")); err == nil {
+		return nil, errors.New("leftover of previous checkout")
+	}
+	if err := os.WriteFile(filepath.Join(path, "leftover"), nil, 0o640); err != nil {
+		return nil, err
+	}
+	return m.commit, m.err
+}
+
+func TestFallbackCheckoutStrategy_Checkout(t *testing.T) {
+	netErr := &git.GitError{Message: "unexpected http status code: 502", Class: 12, Code: -1}
+
+	tests := []struct {
+		name         string
+		primaryErr   error
+		conditions   []FallbackCondition
+		wantFallback bool
+		wantErr      error
+	}{
+		{
+			name: "primary succeeds",
+		},
+		{
+			name:         "error matches class",
+			primaryErr:   netErr,
+			conditions:   []FallbackCondition{{Class: 12}},
+			wantFallback: true,
+		},
+		{
+			name:         "wrapped error matches class and code",
+			primaryErr:   fmt.Errorf("unable to fetch: %w", netErr),
+			conditions:   []FallbackCondition{{Class: 9}, {Class: 12, Code: -1}},
+			wantFallback: true,
+		},
+		{
+			name:       "error does not match code",
+			primaryErr: netErr,
+			conditions: []FallbackCondition{{Class: 12, Code: -3}},
+			wantErr:    netErr,
+		},
+		{
+			name:       "error is not a GitError",
+			primaryErr: errors.New("unable to clone"),
+			conditions: []FallbackCondition{{Class: 12}},
+			wantErr:    errors.New("unable to clone"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			dir := t.TempDir()
+			primary := &mockCheckoutStrategy{commit: &git.Commit{Hash: git.Hash("primary")}, err: tt.primaryErr}
+			fallback := &mockCheckoutStrategy{commit: &git.Commit{Hash: git.Hash("fallback")}}
+			s := &FallbackCheckoutStrategy{
+				Primary:    primary,
+				Fallback:   fallback,
+				Conditions: tt.conditions,
+			}
+
+			c, err := s.Checkout(context.TODO(), dir, "https://example.com", nil)
+			g.Expect(primary.called).To(BeTrue())
+			g.Expect(fallback.called).To(Equal(tt.wantFallback))
+			if tt.wantErr != nil {
+				g.Expect(err).To(MatchError(tt.wantErr.Error()))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.wantFallback {
+				g.Expect(c.Hash.String()).To(Equal("fallback"))
+				return
+			}
+			g.Expect(c.Hash.String()).To(Equal("primary"))
+		})
+	}
+}
+
+func TestFallbackCheckoutStrategy_CheckoutPath(t *testing.T) {
+	netErr := &git.GitError{Message: "unexpected http status code: 502", Class: 12, Code: -1}
+
+	tests := []struct {
+		name         string
+		setup        func(dir string) string
+		fallbackErr  error
+		wantFallback bool
+		wantErr      error
+		wantExisting bool
+	}{
+		{
+			name: "path does not exist",
+			setup: func(dir string) string {
+				return filepath.Join(dir, "checkout")
+			},
+			wantFallback: true,
+		},
+		{
+			name: "path is empty",
+			setup: func(dir string) string {
+				return dir
+			},
+			wantFallback: true,
+		},
+		{
+			name: "path is not empty",
+			setup: func(dir string) string {
+				_ = os.WriteFile(filepath.Join(dir, "existing"), nil, 0o640)
+				return dir
+			},
+			wantErr:      netErr,
+			wantExisting: true,
+		},
+		{
+			name: "fallback does not support options",
+			setup: func(dir string) string {
+				return filepath.Join(dir, "checkout")
+			},
+			fallbackErr:  &git.UnsupportedOptionError{Option: "PublicKeyRing", Implementation: "go-git"},
+			wantFallback: true,
+			wantErr:      netErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			path := tt.setup(t.TempDir())
+			primary := &mockCheckoutStrategy{err: netErr}
+			fallback := &mockCheckoutStrategy{commit: &git.Commit{Hash: git.Hash("fallback")}, err: tt.fallbackErr}
+			s := &FallbackCheckoutStrategy{
+				Primary:    primary,
+				Fallback:   fallback,
+				Conditions: []FallbackCondition{{Class: 12}},
+			}
+
+			c, err := s.Checkout(context.TODO(), path, "https://example.com", nil)
+			g.Expect(fallback.called).To(Equal(tt.wantFallback))
+			if tt.wantExisting {
+				g.Expect(filepath.Join(path, "existing")).To(BeAnExistingFile())
+			}
+			if tt.wantErr != nil {
+				g.Expect(err).To(MatchError(tt.wantErr.Error()))
+				g.Expect(c).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(c.Hash.String()).To(Equal("fallback"))
+		})
+	}
+}
@@ -328,7 +328,7 @@ func TestCheckoutStrategyForImplementation_SemVerCheckout(t *testing.T) {
 		{
 			name:       "Errors without match",
 			constraint: ">=1.0.0",
-			expectErr:  errors.New("no match found for semver: >=1.0.0"),
+			expectErr:  &git.RefNotFoundError{Ref: ">=1.0.0", Err: errors.New("no match found for semver: >=1.0.0")},
 		},
 	}
 	testFunc := func(tt testCase, impl git.Implementation) func(t *testing.T) {